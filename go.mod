@@ -3,12 +3,15 @@ module MelvinBot
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/bwmarrin/discordgo v0.23.2
+	github.com/gorilla/websocket v1.4.0
 	github.com/joho/godotenv v1.4.0
 	github.com/robfig/cron v1.2.0
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
-	github.com/gorilla/websocket v1.4.0 // indirect
 	golang.org/x/crypto v0.0.0-20181030102418-4d3f4d9ffa16 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
@@ -1,16 +1,166 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 
+	"MelvinBot/src/cli"
+	"MelvinBot/src/config"
 	"MelvinBot/src/discord"
-
-	"github.com/joho/godotenv"
+	"MelvinBot/src/logging"
 )
 
+var logger = logging.For("main")
+
+const usage = `usage: melvinbot [-config melvinbot.toml] <command> [flags]
+
+  run [-record events.jsonl]               start the bot, the default with no command
+  check-config                             report on the config without connecting
+  replay events.jsonl                      run a recording through the handlers and print what the bot would do
+  import-quotes -guild <id> -csv <file>    add author,quote rows to a guild's quotes
+  export [-format json|csv] [-guild <id>] [quotes|stats]
+                                           write quotes or stats to stdout
+  migrate [-dry-run]                       bring every storage file up to date, -dry-run only says what would change
+  inspect [-guild <id>] quotes|stats       summarise every guild, or list everything for one
+  inspect -guild <id> -find author=<name> quotes
+                                           look quotes up by id=, author= or message= in the database
+  move-to-database                         copy every storage file into storage.database_file
+
+Everything but run works on the storage directly, stop the bot first or it will write over any changes.
+`
+
 func main() {
-	godotenv.Load("/home/nelly/apps/.env")
-	token := os.Getenv("token")
-	bot := discord.NewBot(token)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	configPath := flag.String("config", "melvinbot.toml", "path to the config file")
+	// Still here so -record before the command keeps working
+	recordPath := flag.String("record", "", "append every gateway event to this JSONL file, for melvinbot replay")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logging.Fatal(logger, "could not load config", "err", err)
+	}
+
+	command, args := "run", []string{}
+	if flag.NArg() > 0 {
+		command, args = flag.Arg(0), flag.Args()[1:]
+	}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = flag.Usage
+
+	switch command {
+	case "run":
+		flags.StringVar(recordPath, "record", *recordPath, "append every gateway event to this JSONL file, for melvinbot replay")
+		flags.Parse(args)
+		run(cfg, *configPath, *recordPath)
+
+	case "check-config":
+		flags.Parse(args)
+		problems := discord.CheckConfig(cfg)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%s has %d problem(s)\n", *configPath, len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("%s is ok\n", *configPath)
+
+	case "replay":
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: melvinbot replay events.jsonl")
+			os.Exit(2)
+		}
+		err = discord.Replay(*cfg, flags.Arg(0), os.Stdout)
+		if err != nil {
+			logging.Fatal(logger, "replay failed", "err", err)
+		}
+
+	case "import-quotes":
+		guildID := flags.String("guild", "", "the guild to add the quotes to")
+		csvPath := flags.String("csv", "", "a csv of author,quote rows with no header")
+		flags.Parse(args)
+		if *guildID == "" || *csvPath == "" {
+			fmt.Fprintln(os.Stderr, "usage: melvinbot import-quotes -guild <id> -csv <file>")
+			os.Exit(2)
+		}
+		offline(cli.ImportQuotes(cfg, *guildID, *csvPath, os.Stdout))
+
+	case "export":
+		format := flags.String("format", "json", "json or csv")
+		guildID := flags.String("guild", "", "just this guild, csv needs one")
+		flags.Parse(args)
+		what := "quotes"
+		if flags.NArg() > 0 {
+			what = flags.Arg(0)
+		}
+		offline(cli.Export(cfg, what, *format, *guildID, os.Stdout))
+
+	case "migrate":
+		dryRun := flags.Bool("dry-run", false, "say what each file needs without writing anything")
+		flags.Parse(args)
+		offline(cli.Migrate(cfg, *dryRun, os.Stdout))
+
+	case "inspect":
+		guildID := flags.String("guild", "", "list everything for just this guild")
+		find := flags.String("find", "", "id=, author= or message= to look up quotes with, needs the database backend")
+		flags.Parse(args)
+		if flags.NArg() != 1 || (*find != "" && (*guildID == "" || flags.Arg(0) != "quotes")) {
+			fmt.Fprintln(os.Stderr, "usage: melvinbot inspect [-guild <id> [-find author=<name>]] quotes|stats")
+			os.Exit(2)
+		}
+		if *find != "" {
+			offline(cli.FindQuotes(cfg, *guildID, *find, os.Stdout))
+			break
+		}
+		offline(cli.Inspect(cfg, flags.Arg(0), *guildID, os.Stdout))
+
+	case "move-to-database":
+		flags.Parse(args)
+		offline(cli.MoveToDatabase(cfg, os.Stdout))
+
+	default:
+		fmt.Fprintf(os.Stderr, "melvinbot has no command called %s\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func run(cfg *config.Config, configPath string, recordPath string) {
+	problems := discord.CheckConfig(cfg)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			logger.Error(problem.Error())
+		}
+		logging.Fatal(logger, "config has problems, run melvinbot check-config for details", "config", configPath, "problems", len(problems))
+	}
+
+	err := logging.Setup(cfg.Logging)
+	if err != nil {
+		logging.Fatal(logger, "could not set up logging", "err", err)
+	}
+	defer logging.Close()
+
+	bot := discord.NewBot(cfg)
+	if recordPath != "" {
+		err = bot.RecordEvents(recordPath)
+		if err != nil {
+			logging.Fatal(logger, "could not record events", "err", err)
+		}
+	}
 	bot.RunBot()
 }
+
+// offline exits with a failed maintenance command's error. They only need the storage files, so unlike run they
+// don't care if the rest of the config is valid
+func offline(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
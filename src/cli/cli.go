@@ -0,0 +1,393 @@
+// Package cli is maintenance that works straight on the storage files or database with the bot stopped, it never
+// connects to Discord. A running bot would write over whatever these change on its next sync
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"MelvinBot/src/config"
+	parse "MelvinBot/src/csv"
+	"MelvinBot/src/features"
+	"MelvinBot/src/permissions"
+	"MelvinBot/src/quotes"
+	"MelvinBot/src/scheduler"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/stats"
+	"MelvinBot/src/store"
+)
+
+// file is one of the bot's storage files and the store it's loaded into
+type file struct {
+	name    string
+	path    string // The database's with that backend
+	data    store.Persistent
+	backups store.Backups
+}
+
+// offline is everything the bot stores, with nothing loaded yet
+type offline struct {
+	quotes quotes.Quotes
+	stats  stats.Counts
+	files  []file
+	db     *store.DB // Only with the database backend
+}
+
+// open needs closing, the database can only be open in one place at a time
+func open(cfg *config.Config) (*offline, error) {
+	data := &offline{quotes: quotes.New(), stats: stats.New()}
+	// The bot keeps the schedule on its scheduler, offline one without any jobs is all we need
+	schedule := scheduler.New().Store()
+	backups := store.Backups{Keep: cfg.Storage.Backups, Every: cfg.Storage.BackupInterval.Duration}
+	data.files = []file{
+		{"stats", cfg.Storage.StatsFile, data.stats, backups},
+		{"quotes", cfg.Storage.QuotesFile, data.quotes, backups},
+		{"features", cfg.Storage.FeaturesFile, features.New(), backups},
+		{"permissions", cfg.Storage.PermissionsFile, permissions.New(), backups},
+		{"schedule", cfg.Storage.ScheduleFile, schedule, backups},
+		{"selfdestruct", cfg.Storage.SelfDestructFile, selfdestruct.New(), backups},
+	}
+	if cfg.Storage.Backend != config.BackendDatabase {
+		return data, nil
+	}
+	db, err := store.OpenDB(cfg.Storage.DatabaseFile)
+	if err != nil {
+		return nil, err
+	}
+	data.db = db
+	for i := range data.files {
+		data.files[i].path = cfg.Storage.DatabaseFile
+	}
+	return data, nil
+}
+
+func (o *offline) close() {
+	if o.db != nil {
+		o.db.Close()
+	}
+}
+
+func (o *offline) storageFor(f file) (store.Storage, error) {
+	if o.db != nil {
+		return f.data.OpenIn(o.db, f.name)
+	}
+	return f.data.Open(f.name, f.backups, f.path)
+}
+
+// load reads a store, one that doesn't exist yet is only an error if it has to
+func (o *offline) load(name string, mustExist bool) (store.Storage, error) {
+	f := o.lookup(name)
+	storage, err := o.storageFor(f)
+	if err != nil {
+		return nil, err
+	}
+	err = storage.Get()
+	if errors.Is(err, os.ErrNotExist) && !mustExist {
+		return storage, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s from %s: %w", f.name, f.path, err)
+	}
+	return storage, nil
+}
+
+func (o *offline) lookup(name string) file {
+	for _, f := range o.files {
+		if f.name == name {
+			return f
+		}
+	}
+	panic("no storage file called " + name)
+}
+
+// ImportQuotes adds every quote in a csv of author,quote rows to a guild, skipping any it already has
+func ImportQuotes(cfg *config.Config, guildID string, csvPath string, out io.Writer) error {
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+	imported, err := parse.ReadQuotes(csvFile)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", csvPath, err)
+	}
+
+	data, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer data.close()
+	storage, err := data.load("quotes", false)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, quote := range data.quotes.Search(guildID, "") {
+		have[quote.Quote.Quote] = true
+	}
+
+	added := 0
+	for _, quote := range imported {
+		if have[quote.Quote] {
+			continue
+		}
+		// No user ID, so these count as unknown authors in !quote stats
+		data.quotes.AddQuoteToDatabase(guildID, quote.Quote, []string{}, quote.Author, "", "", "")
+		added++
+	}
+	if added > 0 {
+		err = storage.Put()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "Imported %d quote(s) into guild %s, skipped %d we already had\n", added, guildID, len(imported)-added)
+	return nil
+}
+
+// Export writes quotes or stats as json or csv. Quote csv has author,quote first so it can be imported again
+func Export(cfg *config.Config, what string, format string, guildID string, out io.Writer) error {
+	if what != "quotes" && what != "stats" {
+		return fmt.Errorf("can only export quotes or stats, not %s", what)
+	}
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("can only export as json or csv, not %s", format)
+	}
+	if format == "csv" && guildID == "" {
+		return errors.New("csv needs a guild, it has nowhere to say which guild a row is from")
+	}
+	data, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer data.close()
+	_, err = data.load(what, true)
+	if err != nil {
+		return err
+	}
+
+	guildIDs := []string{guildID}
+	if guildID == "" {
+		guildIDs = data.guildsIn(what)
+	}
+
+	if format == "json" {
+		export := map[string]any{}
+		for _, id := range guildIDs {
+			if what == "quotes" {
+				export[id] = data.quotes.Search(id, "")
+			} else {
+				board, _ := data.stats.Leaderboard(id)
+				export[id] = board
+			}
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	}
+
+	writer := csv.NewWriter(out)
+	if what == "quotes" {
+		for _, quote := range data.quotes.Search(guildID, "") {
+			writer.Write([]string{quote.Author, quote.Quote.Quote, strconv.Itoa(quote.ID), quote.UserID, strings.Join(quote.AttachmentURLs, " ")})
+		}
+	} else {
+		board, _ := data.stats.Leaderboard(guildID)
+		for _, posts := range board {
+			writer.Write([]string{posts.Name, strconv.Itoa(posts.Posts)})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Migrate brings every storage file up to the current version of its data and rewrites it in the current format. A
+// dry run only says which migrations each file needs and what they'd change
+func Migrate(cfg *config.Config, dryRun bool, out io.Writer) error {
+	data, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer data.close()
+	for _, f := range data.files {
+		storage, err := data.storageFor(f)
+		if err != nil {
+			return err
+		}
+		steps, err := storage.Plan()
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(out, "%s: nothing at %s yet, the bot makes it on start\n", f.name, f.path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %s from %s: %w", f.name, f.path, err)
+		}
+		if len(steps) == 0 {
+			fmt.Fprintf(out, "%s: %s is up to date\n", f.name, f.path)
+		}
+		for _, step := range steps {
+			fmt.Fprintf(out, "%s: version %d to %d, %s: %s\n", f.name, step.From, step.From+1, step.Description, step.Summary)
+		}
+		if dryRun {
+			continue
+		}
+
+		err = storage.Get()
+		if err == nil {
+			err = storage.Put()
+		}
+		if err != nil {
+			return fmt.Errorf("could not migrate %s in %s: %w", f.name, f.path, err)
+		}
+		fmt.Fprintf(out, "%s: wrote %s\n", f.name, f.path)
+	}
+	if dryRun {
+		fmt.Fprintln(out, "dry run, nothing was written")
+	}
+	return nil
+}
+
+// Inspect summarises quotes or stats for every guild, or lists them all for one
+func Inspect(cfg *config.Config, what string, guildID string, out io.Writer) error {
+	if what != "quotes" && what != "stats" {
+		return fmt.Errorf("can only inspect quotes or stats, not %s", what)
+	}
+	data, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer data.close()
+	_, err = data.load(what, true)
+	if err != nil {
+		return err
+	}
+
+	if guildID != "" {
+		if what == "quotes" {
+			for _, quote := range data.quotes.Search(guildID, "") {
+				fmt.Fprintf(out, "%d : %s : %s\n", quote.ID, quote.Author, quote.Quote.Quote)
+			}
+			return nil
+		}
+		board, ok := data.stats.Leaderboard(guildID)
+		if !ok {
+			return fmt.Errorf("no stats for guild %s", guildID)
+		}
+		for _, posts := range board {
+			fmt.Fprintf(out, "%s : %d\n", posts.Name, posts.Posts)
+		}
+		return nil
+	}
+
+	for _, id := range data.guildsIn(what) {
+		name := id
+		if guild, ok := cfg.Guilds[id]; ok && guild.Name != "" {
+			name = fmt.Sprintf("%s (%s)", guild.Name, id)
+		}
+		if what == "quotes" {
+			database, _ := data.quotes.Copy(id)
+			live := data.quotes.Search(id, "")
+			fmt.Fprintf(out, "%s: %d quotes, %d deleted, %d authors\n", name, len(live), len(database.Quotes)-len(live), len(database.MapFromAuthorToQuoteIndices))
+			continue
+		}
+		board, _ := data.stats.Leaderboard(id)
+		total := 0
+		for _, posts := range board {
+			total += posts.Posts
+		}
+		fmt.Fprintf(out, "%s: %d posts by %d people\n", name, total, len(board))
+	}
+	return nil
+}
+
+func (o *offline) guildsIn(what string) []string {
+	ids := o.stats.Guilds()
+	if what == "quotes" {
+		ids = o.quotes.Guilds()
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// MoveToDatabase copies every storage file into the database, to switch storage.backend over to it. Anything that's
+// already in the database is left alone, and the files stay where they are
+func MoveToDatabase(cfg *config.Config, out io.Writer) error {
+	fromFiles := *cfg
+	fromFiles.Storage.Backend = config.BackendFiles
+	data, err := open(&fromFiles)
+	if err != nil {
+		return err
+	}
+	db, err := store.OpenDB(cfg.Storage.DatabaseFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, f := range data.files {
+		moved, err := db.Has(f.name)
+		if err != nil {
+			return err
+		}
+		if moved {
+			fmt.Fprintf(out, "%s: already in %s, skipping\n", f.name, cfg.Storage.DatabaseFile)
+			continue
+		}
+		_, err = data.load(f.name, true)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(out, "%s: nothing at %s, skipping\n", f.name, f.path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		storage, err := f.data.OpenIn(db, f.name)
+		if err == nil {
+			err = storage.Put()
+		}
+		if err != nil {
+			return fmt.Errorf("could not move %s into %s: %w", f.name, cfg.Storage.DatabaseFile, err)
+		}
+		fmt.Fprintf(out, "%s: moved %s into %s\n", f.name, f.path, cfg.Storage.DatabaseFile)
+	}
+	if cfg.Storage.Backend != config.BackendDatabase {
+		fmt.Fprintf(out, "set storage.backend = %q to start using it\n", config.BackendDatabase)
+	}
+	return nil
+}
+
+// FindQuotes looks quotes in a guild up by id, author or message, like author=melvin. It goes straight to the
+// indexes, so it needs the database backend
+func FindQuotes(cfg *config.Config, guildID string, find string, out io.Writer) error {
+	by, value, ok := strings.Cut(find, "=")
+	if !ok {
+		return fmt.Errorf("find quotes with id=, author= or message=, not %s", find)
+	}
+	data, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer data.close()
+	if data.db == nil {
+		return fmt.Errorf("finding quotes needs storage.backend = %q, inspect -guild lists them all instead", config.BackendDatabase)
+	}
+
+	found, err := quotes.Find(data.db, guildID, by, value)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no quotes in guild %s with %s", guildID, find)
+	}
+	for _, quote := range found {
+		fmt.Fprintf(out, "%d : %s : %s\n", quote.ID, quote.Author, quote.Quote.Quote)
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+)
+
+// Every key in the config file can be overridden by an env var named after its path, like MELVIN_STORAGE_STATS_FILE
+const envPrefix = "MELVIN"
+
+type Config struct {
+	// Secrets are never read from the config file, only from the environment (or the env file)
+	EnvFile         string           `toml:"env_file"`
+	ShutdownTimeout Duration         `toml:"shutdown_timeout"` // How long in flight handlers get to finish when we're asked to stop
+	Storage         Storage          `toml:"storage"`
+	Quotes          Quotes           `toml:"quotes"`
+	Jellyfin        Jellyfin         `toml:"jellyfin"`
+	Dota            Dota             `toml:"dota"`
+	Interactions    Interactions     `toml:"interactions"`
+	Cooldowns       Cooldowns        `toml:"cooldowns"`
+	Errors          Errors           `toml:"errors"`
+	Logging         Logging          `toml:"logging"`
+	Metrics         Metrics          `toml:"metrics"`
+	Dashboard       Dashboard        `toml:"dashboard"`
+	Guilds          map[string]Guild `toml:"guilds"` // Keyed by guild ID, can't be overridden by env
+
+	Secrets Secrets `toml:"-"`
+
+	// Problems found while loading that don't stop us from loading, reported by Validate
+	problems []error
+}
+
+type Storage struct {
+	StatsFile       string `toml:"stats_file"`
+	QuotesFile      string `toml:"quotes_file"`
+	FeaturesFile    string `toml:"features_file"`
+	PermissionsFile string `toml:"permissions_file"`
+	ScheduleFile    string `toml:"schedule_file"`
+	// Messages waiting to self destruct, so they still go if we restart first
+	SelfDestructFile string   `toml:"self_destruct_file"`
+	SyncInterval     Duration `toml:"sync_interval"`
+	// How many old copies of each file to keep next to it, a new one is made at most every backup_interval
+	Backups        int      `toml:"backups"`
+	BackupInterval Duration `toml:"backup_interval"`
+	// files keeps everything in the files above, database keeps it all in database_file instead
+	Backend      string `toml:"backend"`
+	DatabaseFile string `toml:"database_file"`
+}
+
+const (
+	BackendFiles    = "files"
+	BackendDatabase = "database"
+)
+
+type Quotes struct {
+	// The quote of the day gets posted here
+	BoardChannelID string `toml:"board_channel_id"`
+	BoardGuildID   string `toml:"board_guild_id"`
+}
+
+type Jellyfin struct {
+	URL            string   `toml:"url"`
+	UpdateChannels []string `toml:"update_channels"`
+}
+
+type Dota struct {
+	ReminderChannelID string `toml:"reminder_channel_id"`
+	ErrorChannelID    string `toml:"error_channel_id"`
+}
+
+// Interactions is the HTTP endpoint Discord sends slash commands to, it's off unless listen is set
+type Interactions struct {
+	Listen        string `toml:"listen"` // Something like 127.0.0.1:8080, put a TLS proxy in front of it
+	ApplicationID string `toml:"application_id"`
+	PublicKey     string `toml:"public_key"` // Hex, from the General Information page of the developer portal
+	// Register commands in just these guilds, where they show up straight away. Empty registers them globally
+	GuildIDs []string `toml:"guild_ids"`
+}
+
+// Cooldowns are keyed by command name for commands and by feature name for triggers like monkas.
+// Each entry replaces the default for that name entirely
+type Cooldowns struct {
+	Commands map[string]Cooldown `toml:"commands"`
+	Triggers map[string]Cooldown `toml:"triggers"`
+}
+
+// Cooldown limits each user and each channel separately, a zero Rate doesn't limit that side at all
+type Cooldown struct {
+	User    Rate `toml:"user"`
+	Channel Rate `toml:"channel"`
+	Reply   bool `toml:"reply"` // Tell whoever hit the limit to slow down, only commands ever reply
+}
+
+// Errors is where handler panics get reported, with neither channel_id nor user_id set they are only logged
+type Errors struct {
+	ChannelID string `toml:"channel_id"`
+	UserID    string `toml:"user_id"` // DM'd the report, and can see errors from every guild with !errors
+	Rate      Rate   `toml:"rate"`    // Per handler, so one broken handler can't drown out the rest
+	Keep      int    `toml:"keep"`    // How many recent failures !errors can show
+}
+
+// Logging always goes to stdout, and to File as well when it's set
+type Logging struct {
+	Level     string `toml:"level"`  // debug, info, warn or error. !loglevel changes it until the next restart
+	Format    string `toml:"format"` // text or json
+	File      string `toml:"file"`
+	MaxSizeMB int    `toml:"max_size_mb"` // File is rotated once it would grow past this
+	Keep      int    `toml:"keep"`        // How many rotated files to keep around, as file.1 (newest) to file.N
+}
+
+// Metrics serves /metrics and /healthz, it's off unless listen is set. There's no auth so keep it on localhost
+type Metrics struct {
+	Listen string `toml:"listen"`
+}
+
+// Dashboard is a web page for browsing and fixing quotes and stats and seeing jobs and errors, it's off unless listen
+// is set. It needs dashboardtoken in the environment, either as a bearer token or the password for username
+type Dashboard struct {
+	Listen   string `toml:"listen"`
+	Username string `toml:"username"`
+}
+
+type Guild struct {
+	Name string `toml:"name"`
+	// Features turned on when we first create the features file
+	Features []string `toml:"features"`
+}
+
+type Secrets struct {
+	Token          string
+	JellyfinUserID string
+	JellyfinAPIKey string
+	DashboardToken string
+}
+
+// Duration lets us write durations like "1m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Rate is written like "3/1m", three uses a minute which can all be used at once
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	count, per, ok := strings.Cut(string(text), "/")
+	if !ok {
+		return fmt.Errorf("rate %q should look like 3/1m", text)
+	}
+	var err error
+	r.Count, err = strconv.Atoi(count)
+	if err != nil {
+		return fmt.Errorf("rate %q should look like 3/1m: %w", text, err)
+	}
+	r.Per, err = time.ParseDuration(per)
+	if err != nil {
+		return fmt.Errorf("rate %q should look like 3/1m: %w", text, err)
+	}
+	return nil
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Count, r.Per)
+}
+
+// Default matches where everything lived before we had a config file
+func Default() *Config {
+	return &Config{
+		EnvFile:         "/home/nelly/apps/.env",
+		ShutdownTimeout: Duration{30 * time.Second},
+		Storage: Storage{
+			StatsFile:        "/etc/melvinstats",
+			QuotesFile:       "/home/nelly/apps/bot/melvinquotes",
+			FeaturesFile:     "/etc/melvinfeatures",
+			PermissionsFile:  "/etc/melvinpermissions",
+			ScheduleFile:     "/etc/melvinschedule",
+			SelfDestructFile: "/etc/melvinselfdestruct",
+			SyncInterval:     Duration{1 * time.Minute},
+			Backups:          5,
+			BackupInterval:   Duration{1 * time.Hour},
+			Backend:          BackendFiles,
+			DatabaseFile:     "/etc/melvin.db",
+		},
+		Jellyfin: Jellyfin{
+			URL: "http://localhost:8096/jelly",
+		},
+		// These all hit something upstream or are easy to spam
+		Cooldowns: Cooldowns{
+			Commands: map[string]Cooldown{
+				"nlquote":      {User: Rate{2, 30 * time.Second}, Channel: Rate{5, time.Minute}, Reply: true},
+				"dota2matches": {Channel: Rate{1, time.Minute}, Reply: true},
+			},
+			Triggers: map[string]Cooldown{
+				"monkas":     {Channel: Rate{1, time.Minute}},
+				"nisha.cook": {Channel: Rate{1, time.Minute}},
+			},
+		},
+		Errors: Errors{
+			Rate: Rate{3, 10 * time.Minute},
+			Keep: 50,
+		},
+		Logging: Logging{
+			Level:     "info",
+			Format:    "text",
+			MaxSizeMB: 10,
+			Keep:      5,
+		},
+		Dashboard: Dashboard{
+			Username: "admin",
+		},
+		Guilds: map[string]Guild{},
+	}
+}
+
+// Load reads the config file on top of the defaults, then the env file and env overrides on top of that.
+// Only a config file we can't parse is an error, everything else is reported by Validate
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	meta, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config file %s: %w", path, err)
+	}
+	for _, key := range meta.Undecoded() {
+		cfg.problems = append(cfg.problems, fmt.Errorf("unknown key %s in %s", key, path))
+	}
+
+	// The env file can be moved by env var too, so look for that override first
+	if envFile, ok := os.LookupEnv(envPrefix + "_ENV_FILE"); ok {
+		cfg.EnvFile = envFile
+	}
+	if cfg.EnvFile != "" {
+		err = godotenv.Load(cfg.EnvFile)
+		if err != nil {
+			cfg.problems = append(cfg.problems, fmt.Errorf("could not load env file %s: %v", cfg.EnvFile, err))
+		}
+	}
+
+	cfg.problems = append(cfg.problems, applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix)...)
+
+	// These names predate the config file so keep them as they are
+	cfg.Secrets = Secrets{
+		Token:          os.Getenv("token"),
+		JellyfinUserID: os.Getenv("jellyuserid"),
+		JellyfinAPIKey: os.Getenv("jellyapikey"),
+		DashboardToken: os.Getenv("dashboardtoken"),
+	}
+
+	return cfg, nil
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+func applyEnv(v reflect.Value, prefix string) []error {
+	problems := []error{}
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("toml")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			problems = append(problems, applyEnv(field, name)...)
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case field.Type() == durationType:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s is not a duration: %v", name, err))
+				continue
+			}
+			field.Set(reflect.ValueOf(Duration{d}))
+		case field.Kind() == reflect.String:
+			field.SetString(value)
+		case field.Kind() == reflect.Int:
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s is not a number: %v", name, err))
+				continue
+			}
+			field.SetInt(int64(i))
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			list := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+		default:
+			problems = append(problems, fmt.Errorf("%s can't be set from the environment", name))
+		}
+	}
+	return problems
+}
+
+// Validate reports every problem with the config at once rather than stopping at the first
+func (c *Config) Validate() []error {
+	problems := append([]error{}, c.problems...)
+
+	if c.Secrets.Token == "" {
+		problems = append(problems, errors.New("no discord token, set token in the env file or environment"))
+	}
+
+	problems = append(problems, checkFile("storage.stats_file", c.Storage.StatsFile)...)
+	problems = append(problems, checkFile("storage.quotes_file", c.Storage.QuotesFile)...)
+	problems = append(problems, checkFile("storage.features_file", c.Storage.FeaturesFile)...)
+	problems = append(problems, checkFile("storage.permissions_file", c.Storage.PermissionsFile)...)
+	problems = append(problems, checkFile("storage.schedule_file", c.Storage.ScheduleFile)...)
+	problems = append(problems, checkFile("storage.self_destruct_file", c.Storage.SelfDestructFile)...)
+	if c.Storage.SyncInterval.Duration <= 0 {
+		problems = append(problems, errors.New("storage.sync_interval must be more than 0"))
+	}
+	if c.Storage.Backups < 0 {
+		problems = append(problems, errors.New("storage.backups can't be negative, use 0 to keep none"))
+	}
+	if c.Storage.Backups > 0 && c.Storage.BackupInterval.Duration <= 0 {
+		problems = append(problems, errors.New("storage.backup_interval must be more than 0"))
+	}
+	switch c.Storage.Backend {
+	case BackendFiles:
+	case BackendDatabase:
+		problems = append(problems, checkFile("storage.database_file", c.Storage.DatabaseFile)...)
+	default:
+		problems = append(problems, fmt.Errorf("storage.backend must be %s or %s, not %q", BackendFiles, BackendDatabase, c.Storage.Backend))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		problems = append(problems, errors.New("shutdown_timeout must be more than 0"))
+	}
+
+	if c.Quotes.BoardChannelID != "" || c.Quotes.BoardGuildID != "" {
+		problems = append(problems, checkID("quotes.board_channel_id", c.Quotes.BoardChannelID)...)
+		problems = append(problems, checkID("quotes.board_guild_id", c.Quotes.BoardGuildID)...)
+	}
+
+	if len(c.Jellyfin.UpdateChannels) > 0 {
+		jellyURL, err := url.Parse(c.Jellyfin.URL)
+		if err != nil || (jellyURL.Scheme != "http" && jellyURL.Scheme != "https") || jellyURL.Host == "" {
+			problems = append(problems, fmt.Errorf("jellyfin.url %q is not an http url", c.Jellyfin.URL))
+		}
+		for _, channel := range c.Jellyfin.UpdateChannels {
+			problems = append(problems, checkID("jellyfin.update_channels", channel)...)
+		}
+		if c.Secrets.JellyfinUserID == "" || c.Secrets.JellyfinAPIKey == "" {
+			problems = append(problems, errors.New("jellyfin.update_channels is set but jellyuserid or jellyapikey is missing from the environment"))
+		}
+	}
+
+	if c.Dota.ReminderChannelID != "" || c.Dota.ErrorChannelID != "" {
+		problems = append(problems, checkID("dota.reminder_channel_id", c.Dota.ReminderChannelID)...)
+		problems = append(problems, checkID("dota.error_channel_id", c.Dota.ErrorChannelID)...)
+	}
+
+	if c.Interactions.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Interactions.Listen); err != nil {
+			problems = append(problems, fmt.Errorf("interactions.listen %q is not a host:port: %v", c.Interactions.Listen, err))
+		}
+		problems = append(problems, checkID("interactions.application_id", c.Interactions.ApplicationID)...)
+		if key, err := hex.DecodeString(c.Interactions.PublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+			problems = append(problems, fmt.Errorf("interactions.public_key should be %d hex characters", ed25519.PublicKeySize*2))
+		}
+		for _, guildID := range c.Interactions.GuildIDs {
+			problems = append(problems, checkID("interactions.guild_ids", guildID)...)
+		}
+	}
+
+	for kind, cooldowns := range map[string]map[string]Cooldown{"commands": c.Cooldowns.Commands, "triggers": c.Cooldowns.Triggers} {
+		for name, cooldown := range cooldowns {
+			for side, rate := range map[string]Rate{"user": cooldown.User, "channel": cooldown.Channel} {
+				if rate.Count < 0 || rate.Per < 0 || (rate.Count == 0) != (rate.Per == 0) {
+					problems = append(problems, fmt.Errorf("cooldowns.%s.%s.%s %s should be a positive count per a positive duration", kind, name, side, rate))
+				}
+			}
+		}
+	}
+
+	if c.Errors.ChannelID != "" {
+		problems = append(problems, checkID("errors.channel_id", c.Errors.ChannelID)...)
+	}
+	if c.Errors.UserID != "" {
+		problems = append(problems, checkID("errors.user_id", c.Errors.UserID)...)
+	}
+	if c.Errors.Rate.Count <= 0 || c.Errors.Rate.Per <= 0 {
+		problems = append(problems, fmt.Errorf("errors.rate %s should be a positive count per a positive duration", c.Errors.Rate))
+	}
+	if c.Errors.Keep <= 0 {
+		problems = append(problems, errors.New("errors.keep must be more than 0"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		problems = append(problems, fmt.Errorf("logging.level %q should be debug, info, warn or error", c.Logging.Level))
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		problems = append(problems, fmt.Errorf("logging.format %q should be text or json", c.Logging.Format))
+	}
+	if c.Logging.File != "" {
+		problems = append(problems, checkFile("logging.file", c.Logging.File)...)
+	}
+	if c.Logging.MaxSizeMB <= 0 {
+		problems = append(problems, errors.New("logging.max_size_mb must be more than 0"))
+	}
+	if c.Logging.Keep < 0 {
+		problems = append(problems, errors.New("logging.keep can't be negative"))
+	}
+
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			problems = append(problems, fmt.Errorf("metrics.listen %q is not a host:port: %v", c.Metrics.Listen, err))
+		}
+	}
+
+	if c.Dashboard.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Dashboard.Listen); err != nil {
+			problems = append(problems, fmt.Errorf("dashboard.listen %q is not a host:port: %v", c.Dashboard.Listen, err))
+		}
+		if c.Secrets.DashboardToken == "" {
+			problems = append(problems, errors.New("dashboard.listen is set but dashboardtoken is missing from the environment"))
+		}
+		if c.Dashboard.Username == "" {
+			problems = append(problems, errors.New("dashboard.username can't be empty"))
+		}
+	}
+
+	for guildID := range c.Guilds {
+		problems = append(problems, checkID("guilds", guildID)...)
+	}
+
+	return problems
+}
+
+// Discord IDs are snowflakes, which are always just digits
+func checkID(key string, id string) []error {
+	if id == "" {
+		return []error{fmt.Errorf("%s is missing", key)}
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return []error{fmt.Errorf("%s %q is not a discord ID", key, id)}
+	}
+	return nil
+}
+
+// We create storage files ourselves, so only the directory has to exist
+func checkFile(key string, path string) []error {
+	if path == "" {
+		return []error{fmt.Errorf("%s is missing", key)}
+	}
+	dir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return []error{fmt.Errorf("%s: %v", key, err)}
+	}
+	if !dir.IsDir() {
+		return []error{fmt.Errorf("%s: %s is not a directory", key, filepath.Dir(path))}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package cooldown rate limits commands and triggers with a token bucket per user and per channel
+package cooldown
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"MelvinBot/src/config"
+)
+
+// Don't bother cleaning up buckets until there are this many
+const pruneAfter = 1000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	limits map[string]config.Cooldown
+	// Clock is time.Now unless something like replay needs its own idea of now
+	Clock func() time.Time
+
+	lock    sync.Mutex
+	buckets map[string]*bucket // name/user/ID or name/channel/ID
+}
+
+func New(limits map[string]config.Cooldown) *Limiter {
+	return &Limiter{
+		limits:  limits,
+		Clock:   time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Limited is whether name has any cooldown at all, so callers can skip the work when it doesn't
+func (l *Limiter) Limited(name string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.limits[name]
+	return ok
+}
+
+// Reply is whether someone hitting the limit should be told to slow down
+func (l *Limiter) Reply(name string) bool {
+	return l.Limited(name) && l.limits[name].Reply
+}
+
+// Ready reports whether name could be used right now without using it up, and if not how long until it can
+func (l *Limiter) Ready(name, userID, channelID string) (bool, time.Duration) {
+	if !l.Limited(name) {
+		return true, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.ready(name, userID, channelID, l.Clock())
+}
+
+// Allow uses name if both the user and the channel have a token left, otherwise it takes nothing
+func (l *Limiter) Allow(name, userID, channelID string) (bool, time.Duration) {
+	if !l.Limited(name) {
+		return true, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.Clock()
+	ok, wait := l.ready(name, userID, channelID, now)
+	if ok {
+		l.take(name, userID, channelID, now)
+	}
+	return ok, wait
+}
+
+// Take uses name even if it's over the limit, for triggers where we only know afterwards that they fired
+func (l *Limiter) Take(name, userID, channelID string) {
+	if !l.Limited(name) {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.take(name, userID, channelID, l.Clock())
+}
+
+func (l *Limiter) ready(name, userID, channelID string, now time.Time) (bool, time.Duration) {
+	limit := l.limits[name]
+	userWait := l.refill(name+"/user/"+userID, limit.User, now)
+	channelWait := l.refill(name+"/channel/"+channelID, limit.Channel, now)
+	wait := max(userWait, channelWait)
+	return wait == 0, wait
+}
+
+// take refills first since Take might come before Ready ever made the buckets, or after a prune dropped them
+func (l *Limiter) take(name, userID, channelID string, now time.Time) {
+	limit := l.limits[name]
+	if limit.User.Count > 0 {
+		l.refill(name+"/user/"+userID, limit.User, now)
+		l.buckets[name+"/user/"+userID].tokens--
+	}
+	if limit.Channel.Count > 0 {
+		l.refill(name+"/channel/"+channelID, limit.Channel, now)
+		l.buckets[name+"/channel/"+channelID].tokens--
+	}
+	if len(l.buckets) > pruneAfter {
+		l.prune(now)
+	}
+}
+
+// refill tops up a bucket for the time since it was last used and says how long until it has a whole token
+func (l *Limiter) refill(key string, rate config.Rate, now time.Time) time.Duration {
+	if rate.Count <= 0 {
+		return 0
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Count), last: now}
+		l.buckets[key] = b
+	}
+
+	perToken := rate.Per / time.Duration(rate.Count)
+	b.tokens = min(float64(rate.Count), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+// prune drops buckets that have filled back up, they're the same as a bucket we've never seen
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		// Names can have dots but never slashes
+		name, rest, _ := strings.Cut(key, "/")
+		rate := l.limits[name].User
+		if strings.HasPrefix(rest, "channel/") {
+			rate = l.limits[name].Channel
+		}
+		if l.refill(key, rate, now) == 0 && b.tokens >= float64(rate.Count) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cooldown
+
+import (
+	"testing"
+	"time"
+
+	"MelvinBot/src/config"
+)
+
+func TestAllow(t *testing.T) {
+	type use struct {
+		at      time.Duration // Since the first use
+		user    string
+		channel string
+		ok      bool
+		wait    time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit config.Cooldown
+		uses  []use
+	}{
+		{
+			name:  "per user",
+			limit: config.Cooldown{User: config.Rate{Count: 2, Per: 30 * time.Second}},
+			uses: []use{
+				{at: 0, user: "a", channel: "c", ok: true},
+				{at: 0, user: "a", channel: "c", ok: true},
+				{at: 0, user: "a", channel: "c", wait: 15 * time.Second},
+				{at: 0, user: "b", channel: "c", ok: true},
+				{at: 15 * time.Second, user: "a", channel: "c", ok: true},
+				{at: 15 * time.Second, user: "a", channel: "c", wait: 15 * time.Second},
+				{at: time.Hour, user: "a", channel: "c", ok: true},
+				{at: time.Hour, user: "a", channel: "c", ok: true},
+			},
+		},
+		{
+			name:  "per channel",
+			limit: config.Cooldown{Channel: config.Rate{Count: 1, Per: time.Minute}},
+			uses: []use{
+				{at: 0, user: "a", channel: "c", ok: true},
+				{at: 0, user: "b", channel: "c", wait: time.Minute},
+				{at: 0, user: "a", channel: "d", ok: true},
+				{at: 30 * time.Second, user: "b", channel: "c", wait: 30 * time.Second},
+				{at: time.Minute, user: "b", channel: "c", ok: true},
+			},
+		},
+		{
+			name: "user and channel",
+			limit: config.Cooldown{
+				User:    config.Rate{Count: 1, Per: 10 * time.Second},
+				Channel: config.Rate{Count: 3, Per: time.Minute},
+			},
+			uses: []use{
+				{at: 0, user: "a", channel: "c", ok: true},
+				// Turned away by the user limit, which mustn't use up the channel's
+				{at: 5 * time.Second, user: "a", channel: "c", wait: 5 * time.Second},
+				{at: 5 * time.Second, user: "b", channel: "c", ok: true},
+				{at: 5 * time.Second, user: "d", channel: "c", ok: true},
+				{at: 5 * time.Second, user: "e", channel: "c", wait: 15 * time.Second},
+				{at: 20 * time.Second, user: "e", channel: "c", ok: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			now := start
+			l := New(map[string]config.Cooldown{"cmd": test.limit})
+			l.Clock = func() time.Time { return now }
+
+			for i, use := range test.uses {
+				now = start.Add(use.at)
+				ok, wait := l.Allow("cmd", use.user, use.channel)
+				if ok != use.ok || wait != use.wait {
+					t.Errorf("use %d by %s in %s at %s: got %v, %s want %v, %s", i, use.user, use.channel, use.at, ok, wait, use.ok, use.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	l := New(map[string]config.Cooldown{"cmd": {User: config.Rate{Count: 1, Per: time.Minute}}})
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow("other", "a", "c"); !ok {
+			t.Fatal("a name without a cooldown was limited")
+		}
+	}
+	if l.Limited("other") || !l.Limited("cmd") {
+		t.Error("Limited doesn't match the limits")
+	}
+	var none *Limiter
+	if ok, _ := none.Allow("cmd", "a", "c"); !ok {
+		t.Error("a nil limiter limited something")
+	}
+}
+
+// Take is for triggers, it can go over the limit and that's paid back before the next use
+func TestTakeOverLimit(t *testing.T) {
+	now := time.Now()
+	l := New(map[string]config.Cooldown{"trigger": {Channel: config.Rate{Count: 1, Per: 10 * time.Second}}})
+	l.Clock = func() time.Time { return now }
+
+	l.Take("trigger", "a", "c")
+	l.Take("trigger", "a", "c")
+	ok, wait := l.Ready("trigger", "a", "c")
+	if ok || wait != 20*time.Second {
+		t.Fatalf("after going over got %v, %s want false, 20s", ok, wait)
+	}
+	// Ready only looks
+	ok, wait = l.Ready("trigger", "a", "c")
+	if ok || wait != 20*time.Second {
+		t.Fatalf("looking again got %v, %s want false, 20s", ok, wait)
+	}
+
+	now = now.Add(20 * time.Second)
+	if ok, _ := l.Ready("trigger", "a", "c"); !ok {
+		t.Error("still limited once paid back")
+	}
+}
@@ -1,41 +1,40 @@
 package parse
 
 import (
-	"MelvinBot/src/quotes"
 	"encoding/csv"
 	"fmt"
-	"os"
+	"io"
+
+	"MelvinBot/src/quotes"
 )
 
-func ParseAndDedupCsv() ([]quotes.Quote, error) {
+// ReadQuotes reads author,quote rows with no header, any columns after those are ignored. A quote that's already been
+// read is skipped so a file pasted together from a few exports doesn't double up
+func ReadQuotes(r io.Reader) ([]quotes.Quote, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
 	var allQuotes []quotes.Quote
-	csvFile, err := os.Open("/home/nelly/apps/bot/parsed_quotes.csv")
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	defer csvFile.Close()
-
-	reader := csv.NewReader(csvFile)
-	var person, quote string
 	quoteExistsMap := make(map[string]bool)
-	csvRaw, err := reader.ReadAll()
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	for _, row := range csvRaw {
-		person = row[0]
-		quote = row[1]
-		if _, exists := quoteExistsMap[quote]; exists {
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return allQuotes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: expected author,quote but got %d column(s)", line, len(row))
+		}
+
+		person, quote := row[0], row[1]
+		if quoteExistsMap[quote] {
 			continue
-		} else {
-			quoteExistsMap[quote] = true
-			allQuotes = append(allQuotes, quotes.Quote{
-				Author: person,
-				Quote:  quote,
-			})
 		}
+		quoteExistsMap[quote] = true
+		allQuotes = append(allQuotes, quotes.Quote{
+			Author: person,
+			Quote:  quote,
+		})
 	}
-	return allQuotes, err
 }
@@ -0,0 +1,236 @@
+// Package dashboard is a web page for browsing and fixing the bot's data, so nobody has to read the JSON files.
+// It's plain html/template with forms, nothing to build
+package dashboard
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/quotes"
+	"MelvinBot/src/report"
+	"MelvinBot/src/scheduler"
+	"MelvinBot/src/stats"
+)
+
+var logger = logging.For("dashboard")
+
+// How many errors the front page shows, and how many quotes a search shows before it needs narrowing
+const (
+	errorsShown = 20
+	quotesShown = 200
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var funcs = template.FuncMap{
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format("Mon 2 Jan 2006 15:04 MST")
+	},
+}
+
+type Server struct {
+	cfg      *config.Config
+	jobs     *scheduler.Scheduler
+	reporter *report.Reporter
+	saved    quotes.Quotes
+	counts   stats.Counts
+	pages    map[string]*template.Template
+	mux      *http.ServeMux
+	// csrf goes in every form, another site can't know it so it can't post to us with the browser's saved login
+	csrf string
+}
+
+// guild is a guild ID with its name from the config if it has one
+type guild struct {
+	ID   string
+	Name string
+}
+
+func New(cfg *config.Config, jobs *scheduler.Scheduler, reporter *report.Reporter, saved quotes.Quotes, counts stats.Counts) (*Server, error) {
+	mac := hmac.New(sha256.New, []byte(cfg.Secrets.DashboardToken))
+	mac.Write([]byte("csrf"))
+	d := &Server{
+		cfg:      cfg,
+		jobs:     jobs,
+		reporter: reporter,
+		saved:    saved,
+		counts:   counts,
+		pages:    map[string]*template.Template{},
+		mux:      http.NewServeMux(),
+		csrf:     hex.EncodeToString(mac.Sum(nil)),
+	}
+
+	for _, page := range []string{"index", "quotes", "stats"} {
+		t, err := template.New("layout.html").Funcs(funcs).ParseFS(templates, "templates/layout.html", "templates/"+page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the %s page: %w", page, err)
+		}
+		d.pages[page] = t
+	}
+
+	d.mux.HandleFunc("/", d.index)
+	d.mux.HandleFunc("/quotes", d.quotes)
+	d.mux.HandleFunc("/quotes/edit", d.editQuote)
+	d.mux.HandleFunc("/quotes/delete", d.deleteQuote)
+	d.mux.HandleFunc("/stats", d.stats)
+	return d, nil
+}
+
+func (d *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !d.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="MelvinBot", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPost && subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(d.csrf)) != 1 {
+		http.Error(w, "Stale form, go back and reload", http.StatusForbidden)
+		return
+	}
+	d.mux.ServeHTTP(w, r)
+}
+
+// authorized takes the token as a bearer token, or as the password for basic auth so a browser can log in
+func (d *Server) authorized(r *http.Request) bool {
+	token := []byte(d.cfg.Secrets.DashboardToken)
+	if len(token) == 0 {
+		return false
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(bearer), token) == 1
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(d.cfg.Dashboard.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), token) == 1
+	return userOK && passwordOK
+}
+
+func (d *Server) render(w http.ResponseWriter, page string, data map[string]any) {
+	data["CSRF"] = d.csrf
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := d.pages[page].Execute(w, data)
+	if err != nil {
+		logger.Error("error rendering page", "page", page, "err", err)
+	}
+}
+
+// guilds is every guild with quotes or stats, or a name in the config
+func (d *Server) guilds() []guild {
+	ids := map[string]bool{}
+	for _, id := range append(d.saved.Guilds(), d.counts.Guilds()...) {
+		ids[id] = true
+	}
+	for id := range d.cfg.Guilds {
+		ids[id] = true
+	}
+
+	guilds := []guild{}
+	for id := range ids {
+		guilds = append(guilds, d.guild(id))
+	}
+	sort.Slice(guilds, func(i, j int) bool {
+		return guilds[i].Name < guilds[j].Name
+	})
+	return guilds
+}
+
+func (d *Server) guild(id string) guild {
+	name := d.cfg.Guilds[id].Name
+	if name == "" {
+		name = id
+	}
+	return guild{ID: id, Name: name}
+}
+
+func (d *Server) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	d.render(w, "index", map[string]any{
+		"Guilds": d.guilds(),
+		"Jobs":   d.jobs.List(true),
+		"Errors": d.reporter.Recent("", errorsShown),
+	})
+}
+
+func (d *Server) quotes(w http.ResponseWriter, r *http.Request) {
+	guildID := r.FormValue("guild")
+	search := r.FormValue("q")
+	found := d.saved.Search(guildID, search)
+	total := len(found)
+	if len(found) > quotesShown {
+		found = found[:quotesShown]
+	}
+	d.render(w, "quotes", map[string]any{
+		"Guild":  d.guild(guildID),
+		"Search": search,
+		"Quotes": found,
+		"Total":  total,
+		"Done":   r.FormValue("done"),
+	})
+}
+
+// changeQuote runs change on the quote in a posted form and goes back to the search it came from
+func (d *Server) changeQuote(w http.ResponseWriter, r *http.Request, what string, change func(guildID string, id int) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	guildID := r.PostFormValue("guild")
+	id, err := strconv.Atoi(r.PostFormValue("id"))
+	if err == nil {
+		err = change(guildID, id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Info("quote changed from the dashboard", "guild", guildID, "quote", id, "change", what, "remote", r.RemoteAddr)
+
+	back := url.Values{"guild": {guildID}, "q": {r.PostFormValue("q")}, "done": {fmt.Sprintf("Quote %d %s", id, what)}}
+	http.Redirect(w, r, "/quotes?"+back.Encode(), http.StatusSeeOther)
+}
+
+func (d *Server) editQuote(w http.ResponseWriter, r *http.Request) {
+	d.changeQuote(w, r, "edited", func(guildID string, id int) error {
+		text := strings.TrimSpace(r.PostFormValue("quote"))
+		if text == "" {
+			return fmt.Errorf("quotes can't be empty, delete it instead")
+		}
+		return d.saved.SetQuote(guildID, id, text)
+	})
+}
+
+func (d *Server) deleteQuote(w http.ResponseWriter, r *http.Request) {
+	d.changeQuote(w, r, "deleted", d.saved.DeleteQuote)
+}
+
+func (d *Server) stats(w http.ResponseWriter, r *http.Request) {
+	guildID := r.FormValue("guild")
+	board, tracked := d.counts.Leaderboard(guildID)
+	d.render(w, "stats", map[string]any{
+		"Guild":   d.guild(guildID),
+		"Board":   board,
+		"Tracked": tracked,
+	})
+}
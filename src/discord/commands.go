@@ -0,0 +1,86 @@
+package discord
+
+import (
+	"context"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/dota2matchreminder"
+	"MelvinBot/src/jellyfin"
+	"MelvinBot/src/module"
+	"MelvinBot/src/nisha"
+	"MelvinBot/src/nlquotes"
+	"MelvinBot/src/permissions"
+	"MelvinBot/src/quotes"
+	"MelvinBot/src/report"
+	"MelvinBot/src/scheduler"
+	"MelvinBot/src/stats"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Add modules here, each one brings its own commands, handlers and jobs
+func botModules(state botState, saved quotes.Quotes, counts stats.Counts) []module.Module {
+	return []module.Module{
+		&quotes.Module{Quotes: saved},
+		&stats.Module{Counts: counts},
+		&nisha.Module{},
+		&nlquotes.Module{},
+		&jellyfin.Module{},
+		&dota2matchreminder.Module{},
+		&pinModule{state: state},
+		&memesModule{},
+	}
+}
+
+// The bot's own commands go here, !help is generated from these and every module's
+func commands(state botState, reporter *report.Reporter, jobs *scheduler.Scheduler) []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "feature",
+			Args:        []module.Arg{{Name: "action", Kind: module.ArgString, Description: "list, enable or disable"}, {Name: "name", Kind: module.ArgString, Optional: true}},
+			Description: "Lists the features in this server with list, admins can enable or disable them by name",
+			Run:         state.featureCommand,
+		},
+		{
+			Name:        "errors",
+			Args:        []module.Arg{{Name: "number", Kind: module.ArgInt, Optional: true}},
+			Description: "Admins only, lists the last few things that broke in this server, or the full details of one",
+			Permission:  permissions.Admin,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				errorsCommand(s, m, args, reporter, state.deletions)
+			},
+		},
+		{
+			Name:        "loglevel",
+			Args:        []module.Arg{{Name: "level", Kind: module.ArgString, Optional: true, Description: "debug, info, warn or error"}},
+			Description: "Admins only, shows or changes how much the bot logs until it restarts",
+			Permission:  permissions.Admin,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				logLevelCommand(ctx, s, m, args, state.deletions)
+			},
+		},
+		{
+			Name: "perm",
+			Args: []module.Arg{
+				{Name: "action", Kind: module.ArgString, Description: "list, allow, deny, reset or audit"},
+				{Name: "name", Kind: module.ArgString, Optional: true},
+				{Name: "target", Kind: module.ArgRest, Optional: true},
+			},
+			Description: "Admins only, shows or changes who can use each command in this server. Allow or deny a @role, @user, a permission like manage_messages, or everyone",
+			Permission:  permissions.Admin,
+			Run:         state.permCommand,
+		},
+		{
+			Name: "schedule",
+			Args: []module.Arg{
+				{Name: "action", Kind: module.ArgString, Optional: true, Description: "list, pause, resume, run, delete or restore"},
+				{Name: "job", Kind: module.ArgRest, Optional: true},
+			},
+			Description: "Admins only, lists the bot's scheduled jobs and when they run next, or pauses, runs or deletes one by name",
+			Permission:  permissions.Admin,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				scheduleCommand(ctx, s, m, args, jobs, state.deletions)
+			},
+		},
+	}
+}
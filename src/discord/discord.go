@@ -1,215 +1,502 @@
 package discord
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
-	"MelvinBot/src/dota2matchreminder"
-	"MelvinBot/src/jellyfin"
-	"MelvinBot/src/nisha"
-	"MelvinBot/src/nlquotes"
+	"MelvinBot/src/config"
+	"MelvinBot/src/dashboard"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/features"
+	"MelvinBot/src/interactions"
+	"MelvinBot/src/lifecycle"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/module"
+	"MelvinBot/src/outbox"
+	"MelvinBot/src/permissions"
 	"MelvinBot/src/quotes"
+	"MelvinBot/src/report"
+	"MelvinBot/src/scheduler"
+	"MelvinBot/src/selfdestruct"
 	"MelvinBot/src/stats"
 	"MelvinBot/src/store"
-	"MelvinBot/src/util"
 
 	disc "github.com/bwmarrin/discordgo"
-	cron "github.com/robfig/cron"
+)
+
+var logger = logging.For("discord")
+
+var (
+	jobRuns    = metrics.NewCounter("melvin_job_runs_total", "Scheduled jobs run, by whether they finished or panicked.", "job", "outcome")
+	jobSeconds = metrics.NewHistogram("melvin_job_seconds", "How long scheduled jobs took to run.", metrics.DefaultBuckets, "job")
 )
 
 type Bot struct {
-	discord   *disc.Session
-	store     store.Storage
-	quotes    store.Storage
-	statsfile string
+	botState
+	discord *disc.Session
+	config  *config.Config
+	modules []module.Module
+	stores  []*storage // The bot's own and whatever the modules asked for
+	db      *store.DB  // Only with the database backend, the stores are kept in files otherwise
+	jobs    *scheduler.Scheduler
+	quotes  quotes.Quotes // Shared with the dashboard
+	stats   stats.Counts
 }
 
-func NewBot(token string) Bot {
-	discord, err := disc.New("Bot " + token)
-	if err != nil {
-		log.Fatal("could not connect to discord")
+// botState is the features, permissions and pending deletions the bot keeps for every guild. Each bot has its own, so
+// a replay or a test never touches a live one's
+type botState struct {
+	features    features.Overrides
+	permissions permissions.Rules
+	deletions   selfdestruct.Queue
+}
+
+func newBotState() botState {
+	state := botState{features: features.New(), permissions: permissions.New(), deletions: selfdestruct.New()}
+	state.features.Register(botFeatures...)
+	state.permissions.Register(botActions...)
+	return state
+}
+
+// storage is a file the bot loads on start, syncs on a timer and saves on shutdown
+type storage struct {
+	store.Storage
+	name string
+	file string
+	seed func() // Fills it in the first time, before there's a file
+}
+
+// CheckConfig is everything wrong with the config, including things only the bot knows about like feature names
+func CheckConfig(cfg *config.Config) []error {
+	problems := cfg.Validate()
+
+	// Nothing gets run, this is just to see what features, commands and triggers there are
+	state := newBotState()
+	for guildID, guild := range cfg.Guilds {
+		for _, name := range guild.Features {
+			if _, ok := state.features.Lookup(name); !ok {
+				problems = append(problems, fmt.Errorf("guilds.%s.features: there is no feature called %s", guildID, name))
+			}
+		}
 	}
 
-	statsFile := "/etc/melvinstats"
-	storage, err := store.NewLocalStorage(&stats.StatsPerGuild, true, statsFile)
-	if err != nil {
-		log.Fatal("could not get local stats")
+	handlers := newEventHandlers(state, botModules(state, quotes.New(), stats.New()), cfg.Cooldowns, nil, nil, nil)
+	for name := range cfg.Cooldowns.Commands {
+		if cmd, ok := handlers.router.Lookup(name); !ok || cmd.Name != name {
+			problems = append(problems, fmt.Errorf("cooldowns.commands: there is no command called %s, aliases don't count", name))
+		}
 	}
+	for name := range cfg.Cooldowns.Triggers {
+		if !slices.ContainsFunc(handlers.messageCreate, func(h gatedHandler[*disc.MessageCreate]) bool { return h.feature == name && name != "" }) {
+			problems = append(problems, fmt.Errorf("cooldowns.triggers: there is no trigger for the feature %s", name))
+		}
+	}
+	return problems
+}
 
-	quotes, err := store.NewLocalStorage(&quotes.GuildIDToQuoteDatabase, true, quotes.Filepath)
+func NewBot(cfg *config.Config) *Bot {
+	discord, err := disc.New("Bot " + cfg.Secrets.Token)
 	if err != nil {
-		log.Fatal("could not get quotes")
+		logging.Fatal(logger, "could not connect to discord", "err", err)
 	}
+	discord.Client.Transport = metrics.Transport("discord", discord.Client.Transport)
 
-	return Bot{discord, storage, quotes, statsFile}
-}
+	var db *store.DB
+	if cfg.Storage.Backend == config.BackendDatabase {
+		db, err = store.OpenDB(cfg.Storage.DatabaseFile)
+		if err != nil {
+			logging.Fatal(logger, "could not open the database", "err", err)
+		}
+	}
+	state := newBotState()
+	featureStorage, err := openStorage(cfg, db, "features", state.features, cfg.Storage.FeaturesFile)
+	if err != nil {
+		logging.Fatal(logger, "could not get features", "err", err)
+	}
 
-func (bot Bot) RunBot() {
+	permissionStorage, err := openStorage(cfg, db, "permissions", state.permissions, cfg.Storage.PermissionsFile)
+	if err != nil {
+		logging.Fatal(logger, "could not get permissions", "err", err)
+	}
 
-	// Init stats
-	if _, err := os.Stat(bot.statsfile); errors.Is(err, os.ErrNotExist) {
-		bot.store.Put()
+	selfDestructStorage, err := openStorage(cfg, db, "selfdestruct", state.deletions, cfg.Storage.SelfDestructFile)
+	if err != nil {
+		logging.Fatal(logger, "could not get self destructing messages", "err", err)
 	}
 
-	err := bot.store.Get()
+	jobs := scheduler.New()
+	scheduleStorage, err := openStorage(cfg, db, "schedule", jobs.Store(), cfg.Storage.ScheduleFile)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(logger, "could not get the schedule", "err", err)
 	}
 
-	bot.store.SyncOnTimer(1 * time.Minute)
+	saved, counts := quotes.New(), stats.New()
+	return &Bot{
+		botState: state,
+		discord:  discord,
+		config:   cfg,
+		modules:  botModules(state, saved, counts),
+		stores: []*storage{
+			{Storage: featureStorage, name: "features", file: cfg.Storage.FeaturesFile, seed: func() { state.seedFeatures(cfg.Guilds) }},
+			{Storage: permissionStorage, name: "permissions", file: cfg.Storage.PermissionsFile},
+			{Storage: scheduleStorage, name: "schedule", file: cfg.Storage.ScheduleFile},
+			{Storage: selfDestructStorage, name: "selfdestruct", file: cfg.Storage.SelfDestructFile},
+		},
+		db:     db,
+		jobs:   jobs,
+		quotes: saved,
+		stats:  counts,
+	}
+}
 
-	// Init quotes
-	if _, err := os.Stat(quotes.Filepath); errors.Is(err, os.ErrNotExist) {
-		bot.quotes.Put()
+// initModules gives every module what it needs. job is how anything a module starts on its own gets run
+func (bot *Bot) initModules(api session.Session, job func(name string, f func(ctx context.Context)) bool) error {
+	for _, m := range bot.modules {
+		err := m.Init(module.Deps{
+			Config:    bot.config,
+			Session:   api,
+			Logger:    logging.For(m.Name()),
+			Go:        job,
+			Storage:   bot.persist,
+			Allowed:   bot.allowedToMessage,
+			Deletions: bot.deletions,
+		})
+		if err != nil {
+			return fmt.Errorf("could not start %s: %w", m.Name(), err)
+		}
 	}
+	return nil
+}
 
-	err = bot.quotes.Get()
+// persist is how modules ask for storage, it's kept in sync like the bot's own
+func (bot *Bot) persist(name string, data store.Persistent, file string) (store.Storage, error) {
+	local, err := openStorage(bot.config, bot.db, name, data, file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	bot.stores = append(bot.stores, &storage{Storage: local, name: name, file: file})
+	return local, nil
+}
 
-	bot.quotes.SyncOnTimer(1 * time.Minute)
-	jf := jellyfin.NewJellyUpdater(bot.discord)
-	// For scheduled jobs
-	c := cron.New()
-	// Send quote at 8:00AM every day
-	quoteBoardChannelID := "1093406748783693854"
-	c.AddFunc("0 0 8 * * *", func() { sendRandomQuote(bot.discord, quoteBoardChannelID, util.Wolfcord_GuildID) }) // Magic bullshit that puts it at midnight PST
-	for _, channel := range jellyfin.JellyfinUpdateChannels {
-		c.AddFunc("0 0 4 * * *", jf.SendUpdateMessageToChannel(channel))
+func (bot *Bot) shutdownModules() {
+	for _, m := range bot.modules {
+		m.Shutdown()
 	}
+}
 
-	c.Start()
+// openStorage keeps data in db if there is one, or in file
+func openStorage(cfg *config.Config, db *store.DB, name string, data store.Persistent, file string) (store.Storage, error) {
+	if db != nil {
+		return data.OpenIn(db, name)
+	}
+	return data.Open(name, store.Backups{Keep: cfg.Storage.Backups, Every: cfg.Storage.BackupInterval.Duration}, file)
+}
 
-	err = dota2matchreminder.StartDota2MatchReminder(bot.discord)
+// closeStorage is after the last put, it only has anything to do with the database backend
+func (bot *Bot) closeStorage() {
+	if bot.db == nil {
+		return
+	}
+	err := bot.db.Close()
 	if err != nil {
-		log.Println("error in dota 2 match reminder", err)
+		logger.Error("failed to close the database", "err", err)
 	}
+}
 
-	// Add message handlers here
-	bot.discord.AddHandler(goMessageHandler(monkaS))
-	bot.discord.AddHandler(goMessageHandler(csBoring))
-	bot.discord.AddHandler(goMessageHandler(stats.TrackStats))
-	bot.discord.AddHandler(goMessageHandler(stats.PrintStats))
-	bot.discord.AddHandler(goMessageHandler(nisha.DidSomebodySaySex))
-	bot.discord.AddHandler(goMessageHandler(nisha.ThisIsNotADvd))
-	bot.discord.AddHandler(goMessageHandler(nisha.GeorgeCarlin))
-	bot.discord.AddHandler(goMessageHandler(nisha.Tetazoo))
-	bot.discord.AddHandler(goMessageHandler(nisha.Glounge))
-	bot.discord.AddHandler(goMessageHandler(nisha.Iiwii))
-	bot.discord.AddHandler(goMessageHandler(nisha.Lethimcook))
-	bot.discord.AddHandler(goMessageHandler(nisha.Miami))
-	bot.discord.AddHandler(goMessageHandler(nisha.KillDamian))
-	bot.discord.AddHandler(goMessageHandler(quotes.HandleQuote))
-	bot.discord.AddHandler(goMessageHandler(quotes.RemoveQuote))
-	bot.discord.AddHandler(goMessageHandler(nlquotes.HandleNLQuote))
-	bot.discord.AddHandler(goMessageHandler(jf.RecentHandler))
-	bot.discord.AddHandler(goMessageHandler(dota2matchreminder.HandleDota2Matches))
-
-	// add other handlers here (reacts etc)
-	bot.discord.AddHandler(goReactionAddHandler(quotes.AddQuote))
-	bot.discord.AddHandler(goReactionAddHandler(pinFromReaction))
-	bot.discord.AddHandler(goReactionRemoveHandler(unpinFromReaction))
+// loadStorage reads everything the bot persists, creating the files the first time we run. A file that's gone but
+// still has a backup is loaded from that instead of starting over, and if none of its backups load either we don't
+// start at all rather than write over them
+func (bot *Bot) loadStorage() error {
+	for _, storage := range bot.stores {
+		err := storage.Get()
+		if errors.Is(err, os.ErrNotExist) {
+			if storage.seed != nil {
+				storage.seed()
+			}
+			err = storage.Put()
+		}
+		if err != nil {
+			return fmt.Errorf("could not load %s: %w", storage.name, err)
+		}
+	}
+	return nil
+}
 
-	err = bot.discord.Open()
+func (bot *Bot) RunBot() {
+	stop, err := bot.Start()
 	if err != nil {
-		log.Fatal("couldnt open connection", err)
+		logging.Fatal(logger, "could not start", "err", err)
 	}
+
 	// Wait here until CTRL-C or other term signal is received.
-	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
+	logger.Info("Bot is now running.  Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
-	// Place stats one last time for consistency
-	err = bot.store.Put()
+	stop()
+}
+
+// Start starts every module, loads storage, schedules jobs and connects without blocking. Call the returned func to
+// shut it all down again
+func (bot *Bot) Start() (func(), error) {
+	lc := lifecycle.New()
+	api := session.Wrap(bot.discord)
+	// Reports skip the outbox, otherwise a report that can't be sent would report itself
+	reporter := report.New(api, bot.config.Errors)
+	out := outbox.New(api, func(channelID string, err error) {
+		reporter.Report(report.Failure{Time: time.Now(), Source: "outbox", Where: report.Where{ChannelID: channelID}, Err: err.Error()})
+	})
+	// job is lc.Go for anything not started by an event, so a panic there is reported too
+	job := func(name string, f func(ctx context.Context)) bool {
+		return lc.Go(func(ctx context.Context) {
+			ctx = logging.With(ctx, "job", name)
+			start := time.Now()
+			outcome := "panic"
+			defer func() {
+				jobRuns.Inc(name, outcome)
+				jobSeconds.Since(start, name)
+			}()
+			defer reporter.Recover(name, report.Where{})
+			f(ctx)
+			outcome = "ok"
+		})
+	}
+	// abort stops whatever has been started when we can't finish starting
+	abort := func() {
+		bot.jobs.Stop()
+		bot.shutdownModules()
+		lc.Shutdown(0)
+	}
+
+	err := bot.initModules(out, job)
 	if err != nil {
-		log.Printf("failed put call on shutdown: %v", err)
+		abort()
+		return nil, err
 	}
 
-	err = bot.quotes.Put()
+	err = bot.loadStorage()
 	if err != nil {
-		log.Printf("failed put call on shutdown: %v", err)
+		abort()
+		return nil, err
 	}
 
-	c.Stop()
+	syncInterval := bot.config.Storage.SyncInterval.Duration
+	for _, storage := range bot.stores {
+		storage := storage
+		lc.Service(func(ctx context.Context) { storage.SyncOnTimer(ctx, syncInterval) })
+	}
+	lc.Service(func(ctx context.Context) { bot.deletions.Run(ctx, api) })
+
+	// Scheduled jobs are tracked like handlers so shutdown waits for them. Starting after storage is loaded means
+	// anything missed while we were down gets run
+	for _, m := range bot.modules {
+		for _, scheduled := range m.ScheduledJobs() {
+			err = bot.jobs.Add(scheduled.Name, scheduled.Spec, scheduled.Timezone, scheduled.Run)
+			if err != nil {
+				abort()
+				return nil, fmt.Errorf("could not schedule %s: %w", m.Name(), err)
+			}
+		}
+	}
+	bot.jobs.Start(job)
 
-	// Cleanly close down the Discord session.
-	bot.discord.Close()
-}
+	handlers := newEventHandlers(bot.botState, bot.modules, bot.config.Cooldowns, reporter, bot.jobs, lc.Go)
+	handlers.addTo(bot.discord, out)
 
-// Handlers
-func monkaS(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
+	err = bot.discord.Open()
+	if err != nil {
+		abort()
+		return nil, fmt.Errorf("couldnt open connection: %w", err)
 	}
 
-	if strings.Contains(strings.ToLower(m.Message.Content), "monkas") {
-		s.ChannelMessageSend(m.ChannelID, "monkaS")
+	var slash *http.Server
+	if bot.config.Interactions.Listen != "" {
+		slash, err = bot.startInteractions(lc, out, handlers.router)
+		if err != nil {
+			abort()
+			bot.discord.Close()
+			return nil, err
+		}
 	}
-}
 
-func csBoring(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
+	var monitor *http.Server
+	if bot.config.Metrics.Listen != "" {
+		monitor, err = bot.startMetrics()
+		if err != nil {
+			if slash != nil {
+				slash.Close()
+			}
+			abort()
+			bot.discord.Close()
+			return nil, fmt.Errorf("couldnt listen for metrics: %w", err)
+		}
 	}
 
-	if m.GuildID != util.Melvin_GuildID {
-		return
+	var admin *http.Server
+	if bot.config.Dashboard.Listen != "" {
+		admin, err = bot.startDashboard(reporter)
+		if err != nil {
+			if monitor != nil {
+				monitor.Close()
+			}
+			if slash != nil {
+				slash.Close()
+			}
+			abort()
+			bot.discord.Close()
+			return nil, err
+		}
 	}
 
-	if strings.Contains(m.Message.Content, " cs") || m.Message.Content == "cs" {
-		boringStrings := []string{"im bored", "bored", "boring", "boring game"}
-		randInt := rand.Intn(len(boringStrings))
-		s.ChannelMessageSend(m.ChannelID, boringStrings[randInt])
+	stop := func() {
+		deadline := bot.config.ShutdownTimeout.Duration
+		logger.Info("shutting down, waiting for handlers to finish", "deadline", deadline)
+
+		// Stop anything new from starting
+		if slash != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), deadline)
+			err := slash.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("failed to close interactions endpoint", "err", err)
+			}
+		}
+		if admin != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), deadline)
+			err := admin.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("failed to close dashboard", "err", err)
+			}
+		}
+		bot.jobs.Stop()
+		bot.shutdownModules()
+
+		// Handlers still need the session while they finish up
+		err := lc.Shutdown(deadline)
+		if err != nil {
+			logger.Warn("shutdown", "err", err)
+		}
+
+		// Place stats one last time for consistency
+		for _, storage := range bot.stores {
+			err = storage.Put()
+			if err != nil {
+				logger.Error("failed put call on shutdown", "file", storage.file, "err", err)
+			}
+		}
+		bot.closeStorage()
+
+		// Cleanly close down the Discord session.
+		bot.discord.Close()
+
+		// Last so /healthz can be watched right up until we're gone
+		if monitor != nil {
+			monitor.Close()
+		}
 	}
+	return stop, nil
 }
 
-func sendRandomQuote(s *disc.Session, channelID string, guildID string) {
-	database, ok := quotes.GuildIDToQuoteDatabase[guildID]
-	// Just in case we never have init'd quotes in this server
-	if !ok {
-		newDatabase := &quotes.QuoteDatabase{
-			Quotes:                      []quotes.Quote{},
-			MapFromAuthorToQuoteIndices: map[string][]int{},
-			Lock:                        &sync.Mutex{},
-		}
-		quotes.GuildIDToQuoteDatabase[guildID] = newDatabase
-		database = newDatabase
+// startInteractions registers our slash commands and starts listening for Discord to send them
+func (bot *Bot) startInteractions(lc *lifecycle.Lifecycle, api session.Session, router *Router) (*http.Server, error) {
+	cfg := bot.config.Interactions
+	publicKey, err := interactions.ParsePublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, err
 	}
-	database.Lock.Lock()
-	defer database.Lock.Unlock()
 
-	totalQuotes := len(database.Quotes)
-	if totalQuotes == 0 {
-		return
+	err = registerSlashCommands(bot.discord, cfg.ApplicationID, cfg.GuildIDs, router.slashCommands())
+	if err != nil {
+		return nil, err
 	}
 
-	database.SendQuote(s, channelID, rand.Intn(totalQuotes), totalQuotes)
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("couldnt listen for interactions: %w", err)
+	}
+	handler := interactions.NewServer(publicKey, bot.discord.Client, lc.Go, func(ctx context.Context, i *interactions.Interaction, reply *interactions.Reply) {
+		router.HandleInteraction(ctx, api, i, reply)
+	})
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("interactions endpoint stopped", "err", err)
+		}
+	}()
+	return server, nil
+}
+
+// startDashboard serves the dashboard until the server is shut down
+func (bot *Bot) startDashboard(reporter *report.Reporter) (*http.Server, error) {
+	handler, err := dashboard.New(bot.config, bot.jobs, reporter, bot.quotes, bot.stats)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", bot.config.Dashboard.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("couldnt listen for the dashboard: %w", err)
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("dashboard stopped", "err", err)
+		}
+	}()
+	return server, nil
+}
+
+// memesModule replies to a couple of things people say
+type memesModule struct {
+	module.Base
+}
+
+func (*memesModule) Name() string {
+	return "memes"
 }
 
-func goMessageHandler(f func(*disc.Session, *disc.MessageCreate)) func(*disc.Session, *disc.MessageCreate) {
-	return func(s *disc.Session, mc *disc.MessageCreate) {
-		go f(s, mc)
+func (*memesModule) Handlers() module.Handlers {
+	return module.Handlers{
+		Message: []module.Handler[*disc.MessageCreate]{
+			{Feature: "monkas", Run: monkaS},
+			{Feature: "csboring", Run: csBoring},
+		},
 	}
 }
 
-func goReactionAddHandler(f func(*disc.Session, *disc.MessageReactionAdd)) func(*disc.Session, *disc.MessageReactionAdd) {
-	return func(s *disc.Session, mc *disc.MessageReactionAdd) {
-		go f(s, mc)
+// Handlers
+func monkaS(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
+		return // it me
+	}
+
+	if strings.Contains(strings.ToLower(m.Message.Content), "monkas") {
+		s.ChannelMessageSend(m.ChannelID, "monkaS")
 	}
 }
 
-func goReactionRemoveHandler(f func(*disc.Session, *disc.MessageReactionRemove)) func(*disc.Session, *disc.MessageReactionRemove) {
-	return func(s *disc.Session, mc *disc.MessageReactionRemove) {
-		go f(s, mc)
+func csBoring(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
+		return // it me
+	}
+
+	if strings.Contains(m.Message.Content, " cs") || m.Message.Content == "cs" {
+		boringStrings := []string{"im bored", "bored", "boring", "boring game"}
+		randInt := rand.Intn(len(boringStrings))
+		s.ChannelMessageSend(m.ChannelID, boringStrings[randInt])
 	}
 }
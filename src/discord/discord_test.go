@@ -0,0 +1,102 @@
+package discord
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/fakediscord"
+	"MelvinBot/src/quotes"
+	"MelvinBot/src/store"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// TestQuoteReaction runs the whole bot against fakediscord: a 💬 saves the quote and the ack deletes itself
+func TestQuoteReaction(t *testing.T) {
+	srv := fakediscord.New("1")
+	defer srv.Close()
+	defer srv.Install()()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Secrets.Token = "test"
+	cfg.ShutdownTimeout.Duration = 5 * time.Second
+	cfg.Storage.StatsFile = filepath.Join(dir, "stats")
+	cfg.Storage.QuotesFile = filepath.Join(dir, "quotes")
+	cfg.Storage.FeaturesFile = filepath.Join(dir, "features")
+	cfg.Storage.PermissionsFile = filepath.Join(dir, "permissions")
+	cfg.Storage.ScheduleFile = filepath.Join(dir, "schedule")
+	cfg.Storage.SelfDestructFile = filepath.Join(dir, "selfdestruct")
+
+	bot := NewBot(cfg)
+	stop, err := bot.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := false
+	defer func() {
+		if !stopped {
+			stop()
+		}
+	}()
+	err = srv.WaitReady(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := srv.PostMessage("10", "20", &disc.User{ID: "30", Username: "alice"}, "to be or not to be")
+	err = srv.React("10", "20", msg.ID, "31", "💬")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ack *disc.Message
+	if !srv.WaitFor(5*time.Second, func() bool {
+		for _, sent := range srv.Sent() {
+			if strings.HasPrefix(sent.Content, "Added quote [#0]") {
+				ack = sent
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatal("the quote was never acked")
+	}
+
+	// Jump past the ack's deadline rather than wait it out
+	bot.deletions.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+	if !srv.WaitFor(5*time.Second, func() bool {
+		for _, deleted := range srv.Deleted() {
+			if deleted.ID == ack.ID {
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatal("the ack was never deleted")
+	}
+
+	// Stopping puts everything, so the quote should be in the file
+	stop()
+	stopped = true
+	saved := quotes.New()
+	storage, err := saved.Open("quotes", store.Backups{}, cfg.Storage.QuotesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, ok := saved.Copy("10")
+	if !ok || len(database.Quotes) != 1 {
+		t.Fatalf("saved quotes are %+v", database)
+	}
+	quote := database.Quotes[0]
+	if quote.Quote != "to be or not to be" || quote.UserID != "30" || quote.MessageID != msg.ID {
+		t.Errorf("saved quote 0 is %+v", quote)
+	}
+}
@@ -0,0 +1,152 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+const guildFlag = "--guild"
+
+// handleDM runs a command sent to us in DMs. Everything is per guild, so it runs against whichever guild the author
+// picked with --guild, or the only one they share with us
+func (r *Router) handleDM(ctx context.Context, s session.Session, m *disc.MessageCreate, name string, cmd *module.Command, input string) {
+	s = r.replies.track(s, m)
+	invoked := commandPrefix + name
+	if !cmd.DM && !cmd.DMOnly {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("%s only works in a server", invoked), 10*time.Second)
+		return
+	}
+
+	selector, input := cutGuildFlag(input)
+	guild, problem := pickGuild(sharedGuilds(s, m.Author.ID), selector)
+	if problem != "" {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, problem, 30*time.Second)
+		return
+	}
+	if !r.features.Enabled(guild.ID, cmd.Feature) {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("%s is turned off in %s", invoked, guild.Name), 10*time.Second)
+		return
+	}
+
+	args, err := cmd.ParseArgs(input)
+	if err != nil {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("%v, usage: `%s`", err, cmd.Usage()), 10*time.Second)
+		return
+	}
+
+	// The command sees the guild but replies still go to the DM
+	message := *m.Message
+	message.GuildID = guild.ID
+	dm := &disc.MessageCreate{Message: &message}
+	s = dmSession{s}
+	if !r.permitted(s, dm, cmd, invoked) || !r.cooledDown(s, dm, cmd, invoked) {
+		return
+	}
+	r.run(ctx, s, dm, cmd, args, invoked)
+}
+
+// cutGuildFlag takes --guild <server> off the start or end of a command's input, the server can be in quotes if it has
+// spaces. Anywhere else it's part of what the command was given, like the text of a quote
+func cutGuildFlag(input string) (selector string, rest string) {
+	input = strings.TrimSpace(input)
+	if selector, after, ok := guildFlagAt(input); ok {
+		return selector, strings.TrimSpace(after)
+	}
+	if i := strings.LastIndex(input, " "+guildFlag); i != -1 {
+		if selector, after, ok := guildFlagAt(input[i+1:]); ok && strings.TrimSpace(after) == "" {
+			return selector, strings.TrimSpace(input[:i])
+		}
+	}
+	return "", input
+}
+
+// guildFlagAt reads --guild <server> from the start of input, after is whatever follows it
+func guildFlagAt(input string) (selector string, after string, ok bool) {
+	after, found := strings.CutPrefix(input, guildFlag)
+	if !found || (after != "" && after[0] != ' ' && after[0] != '=') {
+		return "", input, false
+	}
+	after = strings.TrimPrefix(after, "=")
+	after = strings.TrimLeft(after, " ")
+	if quoted, ok := strings.CutPrefix(after, `"`); ok {
+		selector, after, _ = strings.Cut(quoted, `"`)
+	} else {
+		selector, after = util.CutWord(after)
+	}
+	return selector, after, true
+}
+
+// sharedGuilds is every guild both us and the user are in, sorted by name
+func sharedGuilds(s session.Session, userID string) []*disc.Guild {
+	shared := []*disc.Guild{}
+	for _, guild := range s.BotGuilds() {
+		if _, err := s.GuildMember(guild.ID, userID); err != nil {
+			continue
+		}
+		name := guild.Name
+		if name == "" {
+			name = guild.ID
+		}
+		shared = append(shared, &disc.Guild{ID: guild.ID, Name: name})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i].Name < shared[j].Name
+	})
+	return shared
+}
+
+// pickGuild finds the guild selector names by ID or name, or the only one there is without a selector. If it can't,
+// problem says why for whoever's asking
+func pickGuild(shared []*disc.Guild, selector string) (guild *disc.Guild, problem string) {
+	if len(shared) == 0 {
+		return nil, "We aren't in any servers together, so there's nothing for me to look at"
+	}
+	if selector == "" {
+		if len(shared) == 1 {
+			return shared[0], ""
+		}
+		return nil, fmt.Sprintf("We're in more than one server together, add `%s <server>` to pick one of %s", guildFlag, listGuilds(shared))
+	}
+	for _, guild := range shared {
+		if guild.ID == selector || strings.EqualFold(guild.Name, selector) {
+			return guild, ""
+		}
+	}
+	return nil, fmt.Sprintf("We aren't in a server called %s together, pick one of %s", selector, listGuilds(shared))
+}
+
+func listGuilds(guilds []*disc.Guild) string {
+	names := []string{}
+	for _, guild := range guilds {
+		names = append(names, fmt.Sprintf("%s (`%s`)", guild.Name, guild.ID))
+	}
+	return strings.Join(names, ", ")
+}
+
+// dmSession is how a command run from a DM talks to Discord. A DM channel isn't in any guild, so nobody has any channel
+// permissions there and only rules by role or user can let them do something
+type dmSession struct {
+	session.Session
+}
+
+func (dmSession) UserChannelPermissions(userID, channelID string) (int64, error) {
+	return 0, nil
+}
+
+func (dm dmSession) ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	return session.SendSplit(dm.Session, channelID, data)
+}
+
+func inDM(s session.Session) bool {
+	_, ok := s.(dmSession)
+	return ok
+}
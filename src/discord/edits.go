@@ -0,0 +1,146 @@
+package discord
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/selfdestruct"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Editing a command runs it again for this long after it was sent, after that it's just an edit
+const editWindow = 10 * time.Minute
+
+// sentReplies is what the bot sent back for one command message
+type sentReplies struct {
+	channelID string
+	content   string // What the command said when it was run, so an update that didn't change it can be skipped
+	replies   []string
+	at        time.Time
+}
+
+// replyLog remembers the replies to recent commands so an edit can take them back before running it again
+type replyLog struct {
+	// Clock is time.Now unless something like replay needs its own idea of now
+	Clock func() time.Time
+	// A reply that's taken back doesn't need deleting again later
+	deletions selfdestruct.Queue
+
+	lock     sync.Mutex
+	commands map[string]*sentReplies // Keyed by the command's message ID
+}
+
+func newReplyLog(deletions selfdestruct.Queue) *replyLog {
+	return &replyLog{Clock: time.Now, deletions: deletions, commands: map[string]*sentReplies{}}
+}
+
+// track starts a fresh log for m, anything sent to its channel through the returned session is logged
+func (l *replyLog) track(s session.Session, m *disc.MessageCreate) session.Session {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.Clock()
+	for id, sent := range l.commands {
+		if now.Sub(sent.at) > editWindow {
+			delete(l.commands, id)
+		}
+	}
+	sent := &sentReplies{channelID: m.ChannelID, content: m.Content, at: now}
+	l.commands[m.ID] = sent
+	return &replySession{Session: s, channelID: m.ChannelID, record: func(id string) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		sent.replies = append(sent.replies, id)
+	}}
+}
+
+// changed is false for a command we've already run with exactly this content
+func (l *replyLog) changed(messageID, content string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sent, ok := l.commands[messageID]
+	return !ok || sent.content != content
+}
+
+// takeBack deletes everything the bot sent for a command and forgets it
+func (l *replyLog) takeBack(ctx context.Context, s session.Session, messageID string) {
+	l.lock.Lock()
+	sent, ok := l.commands[messageID]
+	delete(l.commands, messageID)
+	l.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, id := range sent.replies {
+		l.deletions.Cancel(id)
+		err := s.ChannelMessageDelete(sent.channelID, id)
+		if err != nil {
+			logger.WarnContext(ctx, "could not delete reply", "message", id, "err", err)
+		}
+	}
+}
+
+// HandleEdit runs an edited command again, replacing whatever it sent the first time
+func (r *Router) HandleEdit(ctx context.Context, s session.Session, m *disc.MessageUpdate) {
+	if m.Author.ID == s.BotUserID() {
+		return // it me
+	}
+
+	// Only recent messages, nobody expects fixing a typo from last week to run it
+	posted, err := m.Timestamp.Parse()
+	if err != nil || r.replies.Clock().Sub(posted) > editWindow {
+		return
+	}
+	if !r.replies.changed(m.ID, m.Content) {
+		return
+	}
+
+	r.replies.takeBack(ctx, s, m.ID)
+	r.Handle(ctx, s, &disc.MessageCreate{Message: m.Message})
+}
+
+// HandleDelete cleans up after a command whose message is gone
+func (r *Router) HandleDelete(ctx context.Context, s session.Session, m *disc.MessageDelete) {
+	r.replies.takeBack(ctx, s, m.ID)
+}
+
+// replySession logs every message a command sends back to its own channel
+type replySession struct {
+	session.Session
+	channelID string
+	record    func(id string)
+}
+
+// ChannelMessageSendSplit logs every piece a long reply was split into, so taking it back gets all of them
+func (rs *replySession) ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	sent, err := session.SendSplit(rs.Session, channelID, data)
+	if channelID == rs.channelID {
+		for _, msg := range sent {
+			if msg != nil {
+				rs.record(msg.ID)
+			}
+		}
+	}
+	return sent, err
+}
+
+func (rs *replySession) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	return rs.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content})
+}
+
+func (rs *replySession) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	sent, err := rs.ChannelMessageSendSplit(channelID, data)
+	if len(sent) == 0 {
+		return nil, err
+	}
+	return sent[0], err
+}
+
+func (rs *replySession) ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error) {
+	return rs.ChannelMessageSendComplex(channelID, &disc.MessageSend{Files: []*disc.File{{Name: name, Reader: r}}})
+}
@@ -0,0 +1,34 @@
+package discord
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/outbox"
+	"MelvinBot/src/selfdestruct"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// A reply long enough to be split is taken back whole, not just its first piece
+func TestTakeBackSplitReply(t *testing.T) {
+	fake := session.NewFake("bot")
+	l := newReplyLog(selfdestruct.New())
+	m := &disc.MessageCreate{Message: &disc.Message{ID: "100", ChannelID: "20", Content: "!long"}}
+
+	s := l.track(outbox.New(fake, nil), m)
+	_, err := s.ChannelMessageSend("20", strings.Repeat("line\n", outbox.MaxLength/5*2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := len(fake.Sent()); sent != 3 {
+		t.Fatalf("sent %d messages, want it split in 3", sent)
+	}
+
+	l.takeBack(context.Background(), fake, m.ID)
+	if deleted := len(fake.Deleted()); deleted != 3 {
+		t.Errorf("deleted %d messages, want all 3", deleted)
+	}
+}
@@ -0,0 +1,55 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+	"MelvinBot/src/report"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// How many failures !errors lists when it isn't asked for one in particular
+const errorsListed = 5
+
+// isOwner is whether m is from whoever gets the error DMs, they look after the bot as a whole rather than one server
+func isOwner(m *disc.MessageCreate, reporter *report.Reporter) bool {
+	return reporter.Owner() != "" && m.Author.ID == reporter.Owner()
+}
+
+// errorsCommand lists recent failures in this guild. Whoever gets the error DMs sees every guild's
+func errorsCommand(s session.Session, m *disc.MessageCreate, args module.Args, reporter *report.Reporter, deletions selfdestruct.Queue) {
+	guildID := m.GuildID
+	if isOwner(m, reporter) {
+		guildID = ""
+	}
+
+	if args.Has("number") {
+		n := args.Int("number")
+		failures := reporter.Recent(guildID, n)
+		if n < 1 || n > len(failures) {
+			util.SendSelfDestructingMessage(s, deletions, m.ChannelID, fmt.Sprintf("There's no error number %d", n), 10*time.Second)
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, report.Format(failures[n-1], true))
+		return
+	}
+
+	failures := reporter.Recent(guildID, errorsListed)
+	if len(failures) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Nothing has gone wrong lately")
+		return
+	}
+	var list strings.Builder
+	list.WriteString("**Recent errors**, newest first")
+	for i, failure := range failures {
+		list.WriteString(fmt.Sprintf("\n`%d` %s **%s** `%s`", i+1, failure.Time.Format(time.RFC3339), failure.Source, failure.Err))
+	}
+	list.WriteString(fmt.Sprintf("\nUse `%serrors <number>` for the full stack", commandPrefix))
+	s.ChannelMessageSend(m.ChannelID, list.String())
+}
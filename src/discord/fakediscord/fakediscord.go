@@ -0,0 +1,554 @@
+// Package fakediscord is an in process stand in for the Discord REST API and gateway.
+// Point discordgo at it with Install, start the real bot, then drive it with PostMessage and React
+package fakediscord
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/interactions"
+	"MelvinBot/src/logging"
+
+	disc "github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+var logger = logging.For("fakediscord")
+
+var apiPrefix = "/api/v" + disc.APIVersion + "/"
+
+// Server keeps all of its state in a session.Fake, so the same assertions work for unit and end to end tests
+type Server struct {
+	*session.Fake
+	// Interactions are signed with this, give the bot PublicKey() to check them against
+	ApplicationID string
+	privateKey    ed25519.PrivateKey
+
+	http     *httptest.Server
+	upgrader websocket.Upgrader
+
+	lock         sync.Mutex
+	sequence     int64
+	conns        []*gatewayConn
+	ready        chan struct{}
+	commands     map[string][]interactions.ApplicationCommand // Guild ID, or "" for global, to registered commands
+	interactions map[string]*interactionState                 // Interaction token to where its replies go
+}
+
+type interactionState struct {
+	channelID  string
+	originalID string // Empty until the placeholder has been filled in
+}
+
+type gatewayConn struct {
+	conn  *websocket.Conn
+	write sync.Mutex
+}
+
+type gatewayPayload struct {
+	Op       int    `json:"op"`
+	Sequence int64  `json:"s,omitempty"`
+	Type     string `json:"t,omitempty"`
+	Data     any    `json:"d"`
+}
+
+func New(botID string) *Server {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		logging.Fatal(logger, "could not generate interaction key", "err", err)
+	}
+	srv := &Server{
+		Fake:          session.NewFake(botID),
+		ApplicationID: "4000",
+		privateKey:    privateKey,
+		ready:         make(chan struct{}),
+		commands:      map[string][]interactions.ApplicationCommand{},
+		interactions:  map[string]*interactionState{},
+	}
+	srv.http = httptest.NewServer(http.HandlerFunc(srv.serveHTTP))
+	return srv
+}
+
+func (srv *Server) URL() string {
+	return srv.http.URL
+}
+
+// Install points every discordgo endpoint at this server, the returned func points them back
+func (srv *Server) Install() func() {
+	oldDiscord, oldAPI := disc.EndpointDiscord, disc.EndpointAPI
+	oldGuilds, oldChannels, oldUsers := disc.EndpointGuilds, disc.EndpointChannels, disc.EndpointUsers
+	oldGateway, oldGatewayBot := disc.EndpointGateway, disc.EndpointGatewayBot
+	oldWebhooks := disc.EndpointWebhooks
+
+	disc.EndpointDiscord = srv.URL() + "/"
+	disc.EndpointAPI = srv.URL() + apiPrefix
+	disc.EndpointGuilds = disc.EndpointAPI + "guilds/"
+	disc.EndpointChannels = disc.EndpointAPI + "channels/"
+	disc.EndpointUsers = disc.EndpointAPI + "users/"
+	disc.EndpointGateway = disc.EndpointAPI + "gateway"
+	disc.EndpointGatewayBot = disc.EndpointGateway + "/bot"
+	disc.EndpointWebhooks = disc.EndpointAPI + "webhooks/"
+
+	return func() {
+		disc.EndpointDiscord, disc.EndpointAPI = oldDiscord, oldAPI
+		disc.EndpointGuilds, disc.EndpointChannels, disc.EndpointUsers = oldGuilds, oldChannels, oldUsers
+		disc.EndpointGateway, disc.EndpointGatewayBot = oldGateway, oldGatewayBot
+		disc.EndpointWebhooks = oldWebhooks
+	}
+}
+
+func (srv *Server) Close() {
+	srv.lock.Lock()
+	for _, c := range srv.conns {
+		c.conn.Close()
+	}
+	srv.conns = nil
+	srv.lock.Unlock()
+	srv.http.Close()
+}
+
+// WaitReady blocks until a bot has identified on the gateway
+func (srv *Server) WaitReady(timeout time.Duration) error {
+	select {
+	case <-srv.ready:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no bot connected to the gateway within %s", timeout)
+	}
+}
+
+// WaitFor polls until done is true, bot handlers run in their own goroutines so tests can't just check straight away
+func (srv *Server) WaitFor(timeout time.Duration, done func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if done() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done()
+}
+
+// PostMessage is a user posting in a channel, the bot sees it as a MESSAGE_CREATE
+func (srv *Server) PostMessage(guildID, channelID string, author *disc.User, content string) *disc.Message {
+	msg := srv.AddMessage(&disc.Message{
+		ChannelID: channelID,
+		GuildID:   guildID,
+		Author:    author,
+		Content:   content,
+		Timestamp: disc.Timestamp(time.Now().Format(time.RFC3339)),
+	})
+	srv.Dispatch("MESSAGE_CREATE", msg)
+	return msg
+}
+
+// EditMessage is the author editing a message, the bot sees it as a MESSAGE_UPDATE
+func (srv *Server) EditMessage(channelID, messageID, content string) error {
+	msg, err := srv.Fake.EditMessage(channelID, messageID, content)
+	if err != nil {
+		return err
+	}
+	srv.Dispatch("MESSAGE_UPDATE", msg)
+	return nil
+}
+
+// DeleteMessage is the author deleting a message, the bot sees it as a MESSAGE_DELETE with nothing but the IDs
+func (srv *Server) DeleteMessage(guildID, channelID, messageID string) error {
+	err := srv.RemoveMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	srv.Dispatch("MESSAGE_DELETE", &disc.Message{ID: messageID, ChannelID: channelID, GuildID: guildID})
+	return nil
+}
+
+// React is a user adding a reaction, the bot sees it as a MESSAGE_REACTION_ADD
+func (srv *Server) React(guildID, channelID, messageID, userID, emoji string) error {
+	err := srv.AddReaction(channelID, messageID, emoji)
+	if err != nil {
+		return err
+	}
+	srv.Dispatch("MESSAGE_REACTION_ADD", reactionData(guildID, channelID, messageID, userID, emoji))
+	return nil
+}
+
+func (srv *Server) Unreact(guildID, channelID, messageID, userID, emoji string) error {
+	err := srv.RemoveReaction(channelID, messageID, emoji)
+	if err != nil {
+		return err
+	}
+	srv.Dispatch("MESSAGE_REACTION_REMOVE", reactionData(guildID, channelID, messageID, userID, emoji))
+	return nil
+}
+
+func reactionData(guildID, channelID, messageID, userID, emoji string) *disc.MessageReaction {
+	return &disc.MessageReaction{
+		UserID:    userID,
+		MessageID: messageID,
+		Emoji:     disc.Emoji{Name: emoji},
+		ChannelID: channelID,
+		GuildID:   guildID,
+	}
+}
+
+// PublicKey is the hex key the bot should verify interactions with, like the one in the developer portal
+func (srv *Server) PublicKey() string {
+	return hex.EncodeToString(srv.privateKey.Public().(ed25519.PublicKey))
+}
+
+// Commands is what the bot last registered, pass an empty guildID for global commands
+func (srv *Server) Commands(guildID string) []interactions.ApplicationCommand {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.commands[guildID]
+}
+
+// Interact is a user running a slash command. It's signed and posted to the bot's interactions endpoint like
+// Discord would, whatever the bot replies with shows up in the channel as a normal message
+func (srv *Server) Interact(endpoint, guildID, channelID string, user *disc.User, name string, options map[string]any) (*http.Response, error) {
+	srv.lock.Lock()
+	srv.sequence++
+	id := strconv.FormatInt(9000+srv.sequence, 10)
+	token := "token" + id
+	srv.interactions[token] = &interactionState{channelID: channelID}
+	srv.lock.Unlock()
+
+	interaction := map[string]any{
+		"id":             id,
+		"application_id": srv.ApplicationID,
+		"type":           interactions.InteractionApplicationCommand,
+		"channel_id":     channelID,
+		"token":          token,
+		"data":           map[string]any{"name": name, "options": []map[string]any{}},
+	}
+	if guildID != "" {
+		interaction["guild_id"] = guildID
+		interaction["member"] = disc.Member{User: user}
+	} else {
+		interaction["user"] = user
+	}
+	for optionName, value := range options {
+		data := interaction["data"].(map[string]any)
+		data["options"] = append(data["options"].([]map[string]any), map[string]any{"name": optionName, "value": value})
+	}
+
+	body, err := json.Marshal(interaction)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := ed25519.Sign(srv.privateKey, append([]byte(timestamp), body...))
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(signature))
+	return http.DefaultClient.Do(req)
+}
+
+// Dispatch sends an op 0 event to every connected bot
+func (srv *Server) Dispatch(eventType string, data any) {
+	srv.lock.Lock()
+	srv.sequence++
+	payload := gatewayPayload{Op: 0, Sequence: srv.sequence, Type: eventType, Data: data}
+	conns := append([]*gatewayConn{}, srv.conns...)
+	srv.lock.Unlock()
+
+	for _, c := range conns {
+		c.send(payload)
+	}
+}
+
+func (c *gatewayConn) send(payload gatewayPayload) {
+	c.write.Lock()
+	defer c.write.Unlock()
+	err := c.conn.WriteJSON(payload)
+	if err != nil {
+		logger.Error("error writing to gateway", "err", err)
+	}
+}
+
+func (srv *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("could not upgrade gateway", "err", err)
+		return
+	}
+	c := &gatewayConn{conn: conn}
+	c.send(gatewayPayload{Op: 10, Data: map[string]int{"heartbeat_interval": 45000}})
+
+	for {
+		var payload struct {
+			Op int `json:"op"`
+		}
+		err := conn.ReadJSON(&payload)
+		if err != nil {
+			return
+		}
+
+		switch payload.Op {
+		case 1: // Heartbeat
+			c.send(gatewayPayload{Op: 11})
+		case 2: // Identify
+			srv.lock.Lock()
+			srv.sequence++
+			ready := gatewayPayload{Op: 0, Sequence: srv.sequence, Type: "READY", Data: map[string]any{
+				"v":          8,
+				"session_id": "fakediscord",
+				"user":       disc.User{ID: srv.BotID, Username: "Melvin", Bot: true},
+				"guilds":     []any{},
+			}}
+			srv.conns = append(srv.conns, c)
+			srv.lock.Unlock()
+
+			c.send(ready)
+			select {
+			case <-srv.ready:
+			default:
+				close(srv.ready)
+			}
+		}
+	}
+}
+
+func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/gateway/" || r.URL.Path == "/gateway" {
+		srv.serveGateway(w, r)
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	if path[0] == "webhooks" && len(path) >= 3 {
+		srv.serveInteractionWebhook(w, r, path)
+		return
+	}
+	route := r.Method + " " + pattern(path)
+
+	var response any
+	var err error
+	switch route {
+	case "GET gateway", "GET gateway/bot":
+		response = map[string]string{"url": "ws" + strings.TrimPrefix(srv.URL(), "http") + "/gateway"}
+	case "GET users/:id":
+		response, err = srv.User(path[1])
+	case "POST users/:id/channels":
+		var data struct {
+			RecipientID string `json:"recipient_id"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&data)
+		if err == nil {
+			response, err = srv.UserChannelCreate(data.RecipientID)
+		}
+	case "GET guilds/:id/members/:id":
+		response, err = srv.GuildMember(path[1], path[3])
+	case "GET channels/:id/messages":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit == 0 {
+			limit = 50
+		}
+		response, err = srv.ChannelMessages(path[1], limit, r.URL.Query().Get("before"), "", "")
+	case "GET channels/:id/messages/:id":
+		response, err = srv.ChannelMessage(path[1], path[3])
+	case "POST channels/:id/messages":
+		var data *disc.MessageSend
+		data, _, err = readMessageSend(r)
+		if err == nil {
+			response, err = srv.ChannelMessageSendComplex(path[1], data)
+		}
+	case "PUT applications/:id/commands", "PUT applications/:id/guilds/:id/commands":
+		guildID := ""
+		if len(path) == 5 {
+			guildID = path[3]
+		}
+		var commands []interactions.ApplicationCommand
+		err = json.NewDecoder(r.Body).Decode(&commands)
+		if err == nil {
+			srv.lock.Lock()
+			srv.commands[guildID] = commands
+			srv.lock.Unlock()
+			response = commands
+		}
+	case "DELETE channels/:id/messages/:id":
+		err = srv.ChannelMessageDelete(path[1], path[3])
+	case "POST channels/:id/messages/bulk-delete":
+		var data struct {
+			Messages []string `json:"messages"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&data)
+		if err == nil {
+			err = srv.ChannelMessagesBulkDelete(path[1], data.Messages)
+		}
+	case "GET channels/:id/pins":
+		response, err = srv.ChannelMessagesPinned(path[1])
+	case "PUT channels/:id/pins/:id":
+		err = srv.ChannelMessagePin(path[1], path[3])
+	case "DELETE channels/:id/pins/:id":
+		err = srv.ChannelMessageUnpin(path[1], path[3])
+	default:
+		http.Error(w, fmt.Sprintf(`{"message": "fakediscord does not support %s", "code": 0}`, route), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q, "code": 10008}`, err.Error()), http.StatusNotFound)
+		return
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// serveInteractionWebhook answers webhooks/:app/:token[/messages/:id], which is how the bot replies to interactions
+func (srv *Server) serveInteractionWebhook(w http.ResponseWriter, r *http.Request, path []string) {
+	srv.lock.Lock()
+	state, ok := srv.interactions[path[2]]
+	srv.lock.Unlock()
+	if !ok || path[1] != srv.ApplicationID {
+		http.Error(w, `{"message": "Unknown Webhook", "code": 10015}`, http.StatusNotFound)
+		return
+	}
+
+	messageID := ""
+	if len(path) == 5 && path[3] == "messages" {
+		messageID = path[4]
+	}
+
+	var response any
+	var err error
+	switch {
+	case r.Method == http.MethodPost && len(path) == 3:
+		response, err = srv.sendInteractionMessage(state, r)
+	case r.Method == http.MethodPatch && messageID == "@original":
+		srv.lock.Lock()
+		filled := state.originalID != ""
+		srv.lock.Unlock()
+		if filled {
+			err = fmt.Errorf("fakediscord only supports filling in the original once")
+			break
+		}
+		var msg *disc.Message
+		msg, err = srv.sendInteractionMessage(state, r)
+		if err == nil {
+			srv.lock.Lock()
+			state.originalID = msg.ID
+			srv.lock.Unlock()
+			response = msg
+		}
+	case r.Method == http.MethodDelete && messageID == "@original":
+		srv.lock.Lock()
+		originalID := state.originalID
+		srv.lock.Unlock()
+		// Deleting the placeholder before it was filled in is fine, there is nothing in the channel to remove
+		if originalID != "" {
+			err = srv.ChannelMessageDelete(state.channelID, originalID)
+		}
+	case r.Method == http.MethodDelete && messageID != "":
+		err = srv.ChannelMessageDelete(state.channelID, messageID)
+	default:
+		http.Error(w, fmt.Sprintf(`{"message": "fakediscord does not support %s %s", "code": 0}`, r.Method, pattern(path)), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q, "code": 10008}`, err.Error()), http.StatusNotFound)
+		return
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (srv *Server) sendInteractionMessage(state *interactionState, r *http.Request) (*disc.Message, error) {
+	data, flags, err := readMessageSend(r)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := srv.ChannelMessageSendComplex(state.channelID, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Flags = flags
+	return msg, nil
+}
+
+// pattern turns channels/123/messages/456 into channels/:id/messages/:id
+func pattern(path []string) string {
+	parts := make([]string, len(path))
+	for i, part := range path {
+		if _, err := strconv.ParseUint(part, 10, 64); err == nil || part == "@me" {
+			parts[i] = ":id"
+		} else {
+			parts[i] = part
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// messagePayload is a MessageSend plus the flags interaction replies use for ephemeral messages
+type messagePayload struct {
+	disc.MessageSend
+	Flags disc.MessageFlags `json:"flags"`
+}
+
+// readMessageSend handles both plain JSON sends and the multipart ones discordgo uses for files
+func readMessageSend(r *http.Request) (*disc.MessageSend, disc.MessageFlags, error) {
+	payload := &messagePayload{}
+	data := &payload.MessageSend
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		err = json.NewDecoder(r.Body).Decode(payload)
+		return data, payload.Flags, err
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return data, payload.Flags, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return nil, 0, err
+		}
+		if part.FormName() == "payload_json" {
+			files := data.Files
+			err = json.Unmarshal(body, payload)
+			if err != nil {
+				return nil, 0, err
+			}
+			data.Files = files
+			continue
+		}
+		data.Files = append(data.Files, &disc.File{
+			Name:        part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Reader:      bytes.NewReader(body),
+		})
+	}
+}
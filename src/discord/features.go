@@ -0,0 +1,87 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/features"
+	"MelvinBot/src/module"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Add features here, every handler and command is gated on one of these
+var botFeatures = []features.Feature{
+	{Name: "quotes", Description: "!quote, !removequote and saving quotes with 💬", DefaultOn: true},
+	{Name: "stats", Description: "Tracking posts and !stats", DefaultOn: true},
+	{Name: "nlquotes", Description: "!nlquote", DefaultOn: true},
+	{Name: "jellyfin", Description: "!jellyfinrecent", DefaultOn: true},
+	{Name: "dota", Description: "!dota2matches", DefaultOn: true},
+	{Name: "pin", Description: "Pinning and unpinning with 📌", DefaultOn: true},
+	{Name: "monkas", Description: "Replies monkaS to monkaS", DefaultOn: true},
+	{Name: "iiwii", Description: "!iiwii", DefaultOn: true},
+	{Name: "csboring", Description: "Complains whenever someone mentions cs"},
+	{Name: "nisha.sex", Description: "did somebody say sex???"},
+	{Name: "nisha.stop", Description: "!stop"},
+	{Name: "nisha.rsbs", Description: "!rsbs"},
+	{Name: "nisha.tetazoo", Description: "TETAZOO IS NOT A HIVEMIND"},
+	{Name: "nisha.glounge", Description: "update tetazoo glounge"},
+	{Name: "nisha.cook", Description: "Let him cook"},
+	{Name: "nisha.miami", Description: "SPRING BREAK MIAMI"},
+	{Name: "nisha.killdamian", Description: "Lasers when damian replies to erik"},
+}
+
+// seedFeatures turns on the features each guild lists in the config file, only called when there is no features file yet
+func (state botState) seedFeatures(guilds map[string]config.Guild) {
+	for guildID, guild := range guilds {
+		for _, name := range guild.Features {
+			err := state.features.Set(guildID, name, true)
+			if err != nil {
+				logger.Error("could not seed features", "guild", guildID, "err", err)
+			}
+		}
+	}
+}
+
+func (state botState) featureCommand(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+	action := strings.ToLower(args.String("action"))
+	name := strings.ToLower(args.String("name"))
+
+	if action == "list" {
+		var list strings.Builder
+		list.WriteString("**Features in this server**")
+		for _, feature := range state.features.All() {
+			status := "off"
+			if state.features.Enabled(m.GuildID, feature.Name) {
+				status = "on"
+			}
+			list.WriteString(fmt.Sprintf("\n`%s` [%s] - %s", feature.Name, status, feature.Description))
+		}
+		s.ChannelMessageSend(m.ChannelID, list.String())
+		return
+	}
+
+	if action != "enable" && action != "disable" {
+		util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, "You can only list, enable or disable features", 10*time.Second)
+		return
+	}
+	if name == "" {
+		util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, fmt.Sprintf("Which feature do you want to %s?", action), 10*time.Second)
+		return
+	}
+	if !state.allowedToMessage(s, m, "feature.change", "change features") {
+		return
+	}
+
+	err := state.features.Set(m.GuildID, name, action == "enable")
+	if err != nil {
+		util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, err.Error(), 10*time.Second)
+		return
+	}
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Feature %s is now %sd", name, action))
+}
@@ -0,0 +1,233 @@
+package discord
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/cooldown"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/module"
+	"MelvinBot/src/report"
+	"MelvinBot/src/scheduler"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+var (
+	handlerRuns    = metrics.NewCounter("melvin_handler_runs_total", "Gateway event handlers run, by whether they finished or panicked.", "handler", "outcome")
+	handlerSeconds = metrics.NewHistogram("melvin_handler_seconds", "How long gateway event handlers took to run.", metrics.DefaultBuckets, "handler")
+)
+
+type gatedHandler[T any] struct {
+	feature string
+	name    string // For error reports
+	f       func(context.Context, session.Session, T)
+}
+
+// handlerName is the function's name without the module, like nisha.Tetazoo
+func handlerName(f any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// eventHandlers is every handler the bot runs, shared by the live bot and replay so they can't drift apart
+type eventHandlers struct {
+	messageCreate  []gatedHandler[*disc.MessageCreate]
+	messageUpdate  []gatedHandler[*disc.MessageUpdate]
+	messageDelete  []gatedHandler[*disc.MessageDelete]
+	reactionAdd    []gatedHandler[*disc.MessageReactionAdd]
+	reactionRemove []gatedHandler[*disc.MessageReactionRemove]
+
+	// run is how each handler gets called, live every handler is tracked work but replay runs them in order
+	run func(func(ctx context.Context)) bool
+
+	// Slash commands skip the gateway handlers and go straight to the router
+	router *Router
+	// Keyed by the feature of the message handler
+	triggers *cooldown.Limiter
+	// Every handler recovers panics and reports them here
+	reporter *report.Reporter
+}
+
+func newEventHandlers(state botState, modules []module.Module, cooldowns config.Cooldowns, reporter *report.Reporter, jobs *scheduler.Scheduler, run func(func(ctx context.Context)) bool) *eventHandlers {
+	h := &eventHandlers{run: run, triggers: cooldown.New(cooldowns.Triggers), reporter: reporter}
+
+	// Commands all go through the router
+	h.router = NewRouter(state)
+	h.router.cooldowns = cooldown.New(cooldowns.Commands)
+	h.router.reporter = reporter
+	h.router.Register(commands(state, reporter, jobs)...)
+	for _, m := range modules {
+		h.router.Register(m.Commands()...)
+	}
+	h.onMessage("", h.router.Handle)
+	h.onMessageUpdate("", h.router.HandleEdit)
+	h.onMessageDelete("", h.router.HandleDelete)
+
+	for _, m := range modules {
+		handlers := m.Handlers()
+		for _, handler := range handlers.Message {
+			h.onMessage(handler.Feature, handler.Run)
+		}
+		for _, handler := range handlers.MessageUpdate {
+			h.onMessageUpdate(handler.Feature, handler.Run)
+		}
+		for _, handler := range handlers.MessageDelete {
+			h.onMessageDelete(handler.Feature, handler.Run)
+		}
+		for _, handler := range handlers.ReactionAdd {
+			h.onReactionAdd(handler.Feature, handler.Run)
+		}
+		for _, handler := range handlers.ReactionRemove {
+			h.onReactionRemove(handler.Feature, handler.Run)
+		}
+	}
+
+	return h
+}
+
+// An empty feature always runs
+func (h *eventHandlers) onMessage(feature string, f func(context.Context, session.Session, *disc.MessageCreate)) {
+	h.messageCreate = append(h.messageCreate, gatedHandler[*disc.MessageCreate]{feature, handlerName(f), f})
+}
+
+func (h *eventHandlers) onMessageUpdate(feature string, f func(context.Context, session.Session, *disc.MessageUpdate)) {
+	h.messageUpdate = append(h.messageUpdate, gatedHandler[*disc.MessageUpdate]{feature, handlerName(f), f})
+}
+
+func (h *eventHandlers) onMessageDelete(feature string, f func(context.Context, session.Session, *disc.MessageDelete)) {
+	h.messageDelete = append(h.messageDelete, gatedHandler[*disc.MessageDelete]{feature, handlerName(f), f})
+}
+
+func (h *eventHandlers) onReactionAdd(feature string, f func(context.Context, session.Session, *disc.MessageReactionAdd)) {
+	h.reactionAdd = append(h.reactionAdd, gatedHandler[*disc.MessageReactionAdd]{feature, handlerName(f), f})
+}
+
+func (h *eventHandlers) onReactionRemove(feature string, f func(context.Context, session.Session, *disc.MessageReactionRemove)) {
+	h.reactionRemove = append(h.reactionRemove, gatedHandler[*disc.MessageReactionRemove]{feature, handlerName(f), f})
+}
+
+// dispatch checks the feature before the handler is ever called. Features are per guild so in DMs only the handlers
+// without one run. sessionFor can skip a handler by returning nil. A handler that panics is reported and the rest carry on
+func dispatch[T any](h *eventHandlers, handlers []gatedHandler[T], sessionFor func(feature string) session.Session, where report.Where, event T) {
+	for _, handler := range handlers {
+		if where.GuildID == "" && handler.feature != "" {
+			continue
+		}
+		if !h.router.features.Enabled(where.GuildID, handler.feature) {
+			continue
+		}
+		s := sessionFor(handler.feature)
+		if s == nil {
+			continue
+		}
+		handler := handler
+		h.run(func(ctx context.Context) {
+			ctx = logging.With(ctx, "handler", handler.name, "guild", where.GuildID, "channel", where.ChannelID, "user", where.UserID)
+			start := time.Now()
+			outcome := "panic" // Until it gets to the end without one
+			defer func() {
+				handlerRuns.Inc(handler.name, outcome)
+				handlerSeconds.Since(start, handler.name)
+			}()
+			defer h.reporter.Recover(handler.name, where)
+			handler.f(ctx, s, event)
+			outcome = "ok"
+		})
+	}
+}
+
+func (h *eventHandlers) MessageCreate(s session.Session, m *disc.MessageCreate) {
+	dispatch(h, h.messageCreate, func(feature string) session.Session {
+		return h.triggerSession(s, feature, m)
+	}, report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID}, m)
+}
+
+// MessageUpdate skips updates that aren't edits, Discord also sends one when a link's embed loads in
+func (h *eventHandlers) MessageUpdate(s session.Session, m *disc.MessageUpdate) {
+	if m.Message == nil || m.Author == nil || m.EditedTimestamp == "" {
+		return
+	}
+	dispatch(h, h.messageUpdate, func(string) session.Session { return s }, report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID}, m)
+}
+
+func (h *eventHandlers) MessageDelete(s session.Session, m *disc.MessageDelete) {
+	if m.Message == nil {
+		return
+	}
+	dispatch(h, h.messageDelete, func(string) session.Session { return s }, report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID}, m)
+}
+
+func (h *eventHandlers) ReactionAdd(s session.Session, m *disc.MessageReactionAdd) {
+	dispatch(h, h.reactionAdd, func(string) session.Session { return s }, report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.UserID}, m)
+}
+
+func (h *eventHandlers) ReactionRemove(s session.Session, m *disc.MessageReactionRemove) {
+	dispatch(h, h.reactionRemove, func(string) session.Session { return s }, report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.UserID}, m)
+}
+
+// triggerSession skips a trigger that's cooling down. We can't know if a trigger will fire until it does,
+// so it only uses up its cooldown once it actually sends something
+func (h *eventHandlers) triggerSession(s session.Session, feature string, m *disc.MessageCreate) session.Session {
+	if !h.triggers.Limited(feature) {
+		return s
+	}
+	if ok, _ := h.triggers.Ready(feature, m.Author.ID, m.ChannelID); !ok {
+		return nil
+	}
+	return &triggerSession{Session: s, take: func() { h.triggers.Take(feature, m.Author.ID, m.ChannelID) }}
+}
+
+type triggerSession struct {
+	session.Session
+	take func()
+	once sync.Once
+}
+
+func (ts *triggerSession) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	ts.once.Do(ts.take)
+	return ts.Session.ChannelMessageSend(channelID, content)
+}
+
+func (ts *triggerSession) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	ts.once.Do(ts.take)
+	return ts.Session.ChannelMessageSendComplex(channelID, data)
+}
+
+func (ts *triggerSession) ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error) {
+	ts.once.Do(ts.take)
+	return ts.Session.ChannelFileSend(channelID, name, r)
+}
+
+func (ts *triggerSession) ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	ts.once.Do(ts.take)
+	return session.SendSplit(ts.Session, channelID, data)
+}
+
+// addTo hooks the handlers up to a live discordgo session, they all send through api
+func (h *eventHandlers) addTo(s *disc.Session, api session.Session) {
+	s.AddHandler(func(s *disc.Session, m *disc.MessageCreate) {
+		h.MessageCreate(api, m)
+	})
+	s.AddHandler(func(s *disc.Session, m *disc.MessageUpdate) {
+		h.MessageUpdate(api, m)
+	})
+	s.AddHandler(func(s *disc.Session, m *disc.MessageDelete) {
+		h.MessageDelete(api, m)
+	})
+	s.AddHandler(func(s *disc.Session, m *disc.MessageReactionAdd) {
+		h.ReactionAdd(api, m)
+	})
+	s.AddHandler(func(s *disc.Session, m *disc.MessageReactionRemove) {
+		h.ReactionRemove(api, m)
+	})
+}
@@ -0,0 +1,90 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"MelvinBot/src/metrics"
+)
+
+// Discord asks for a heartbeat about every 41s, so missing this long means a couple have gone unanswered
+const heartbeatStale = 2 * time.Minute
+
+// A store is stale once this many syncs in a row haven't happened
+const missedSyncs = 3
+
+type health struct {
+	OK      bool                   `json:"ok"`
+	Gateway gatewayHealth          `json:"gateway"`
+	Stores  map[string]storeHealth `json:"stores"`
+}
+
+type gatewayHealth struct {
+	Connected     bool      `json:"connected"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Age           string    `json:"age"`
+}
+
+type storeHealth struct {
+	LastSync time.Time `json:"last_sync"`
+	Age      string    `json:"age"`
+}
+
+// checkHealth is healthy when the gateway is up and answering heartbeats and every store has synced recently
+func (bot *Bot) checkHealth() health {
+	now := time.Now()
+
+	bot.discord.RLock()
+	gateway := gatewayHealth{Connected: bot.discord.DataReady, LastHeartbeat: bot.discord.LastHeartbeatAck}
+	bot.discord.RUnlock()
+	gateway.Age = now.Sub(gateway.LastHeartbeat).Round(time.Second).String()
+	h := health{
+		OK:      gateway.Connected && now.Sub(gateway.LastHeartbeat) < heartbeatStale,
+		Gateway: gateway,
+		Stores:  map[string]storeHealth{},
+	}
+
+	stale := missedSyncs * bot.config.Storage.SyncInterval.Duration
+	for _, storage := range bot.stores {
+		last := storage.LastSync()
+		h.Stores[storage.name] = storeHealth{LastSync: last, Age: now.Sub(last).Round(time.Second).String()}
+		if now.Sub(last) > stale {
+			h.OK = false
+		}
+	}
+	return h
+}
+
+func (bot *Bot) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h := bot.checkHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(h)
+	if err != nil {
+		logger.Error("error writing health", "err", err)
+	}
+}
+
+// startMetrics serves /metrics and /healthz until the server is shut down
+func (bot *Bot) startMetrics() (*http.Server, error) {
+	listener, err := net.Listen("tcp", bot.config.Metrics.Listen)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", bot.serveHealth)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics endpoint stopped", "err", err)
+		}
+	}()
+	return server, nil
+}
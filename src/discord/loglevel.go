@@ -0,0 +1,33 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/module"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// logLevelCommand changes the level for the whole bot, not just this server, and only until it restarts
+func logLevelCommand(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args, deletions selfdestruct.Queue) {
+	if !args.Has("level") {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Logging at %s", strings.ToLower(logging.Level().String())))
+		return
+	}
+
+	old := logging.Level()
+	err := logging.SetLevel(args.String("level"))
+	if err != nil {
+		util.SendSelfDestructingMessage(s, deletions, m.ChannelID, err.Error(), 10*time.Second)
+		return
+	}
+	logger.WarnContext(ctx, "log level changed", "from", old, "to", logging.Level())
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Logging at %s until the next restart", strings.ToLower(logging.Level().String())))
+}
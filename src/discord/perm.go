@@ -0,0 +1,169 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/module"
+	"MelvinBot/src/permissions"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+var permissionDenials = metrics.NewCounter("melvin_permission_denials_total", "Things someone tried and wasn't allowed to do.", "action")
+
+// Add actions that aren't a whole command here, every command is already one under its own name
+var botActions = []permissions.Action{
+	{Name: "quote.all", Description: "!quote all, which sends every quote in the server", Default: permissions.Rule{Permissions: disc.PermissionManageMessages}},
+	{Name: "pin", Description: "Pinning and unpinning with 📌", Default: permissions.Rule{Everyone: true}},
+	{Name: "feature.change", Description: "!feature enable and disable", Default: permissions.Rule{Permissions: permissions.Admin}},
+}
+
+// allowed checks a user against action's rule, auditing it if they're denied. member can be nil, like on reactions
+func (state botState) allowed(s session.Session, guildID, channelID, userID string, member *disc.Member, action string) bool {
+	who := permissions.Who{UserID: userID}
+	perms, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		logger.Error("error getting permissions", "user", userID, "channel", channelID, "err", err)
+	}
+	who.Permissions = perms
+
+	if member == nil && guildID != "" {
+		member, err = s.GuildMember(guildID, userID)
+		if err != nil {
+			logger.Error("error getting roles", "guild", guildID, "user", userID, "err", err)
+		}
+	}
+	if member != nil {
+		who.Roles = member.Roles
+	}
+
+	if state.permissions.Allowed(guildID, action, who) {
+		return true
+	}
+	state.permissions.Audit(guildID, permissions.Denial{Time: time.Now(), Action: action, UserID: userID, ChannelID: channelID})
+	permissionDenials.Inc(action)
+	logger.Warn("permission denied", "action", action, "guild", guildID, "channel", channelID, "user", userID)
+	return false
+}
+
+// allowedToMessage is allowed for whoever sent m, telling them if they aren't
+func (state botState) allowedToMessage(s session.Session, m *disc.MessageCreate, action string, what string) bool {
+	if state.allowed(s, m.GuildID, m.ChannelID, m.Author.ID, m.Member, action) {
+		return true
+	}
+	util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, fmt.Sprintf("You aren't allowed to %s here", what), 10*time.Second)
+	return false
+}
+
+func (state botState) permCommand(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+	switch strings.ToLower(args.String("action")) {
+	case "list":
+		var list strings.Builder
+		list.WriteString("**Who can do what in this server**, admins can always do everything")
+		for _, action := range state.permissions.All() {
+			rule, changed := state.permissions.RuleFor(m.GuildID, action.Name)
+			note := ""
+			if changed {
+				note = " (changed)"
+			}
+			list.WriteString(fmt.Sprintf("\n`%s` %s%s", action.Name, rule, note))
+		}
+		sendQuietly(s, m.ChannelID, list.String())
+
+	case "audit":
+		denials := state.permissions.Denials(m.GuildID)
+		if len(denials) == 0 {
+			s.ChannelMessageSend(m.ChannelID, "Nobody has been denied anything lately")
+			return
+		}
+		var list strings.Builder
+		list.WriteString("**Recent denials**, newest first")
+		for _, denial := range denials {
+			list.WriteString(fmt.Sprintf("\n%s <@%s> tried `%s` in <#%s>", denial.Time.Format(time.RFC3339), denial.UserID, denial.Action, denial.ChannelID))
+		}
+		sendQuietly(s, m.ChannelID, list.String())
+
+	case "allow", "deny":
+		allow := strings.ToLower(args.String("action")) == "allow"
+		name := strings.ToLower(args.String("name"))
+		target := strings.TrimSpace(args.String("target"))
+		if name == "" || target == "" {
+			util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, fmt.Sprintf("Usage: `%sperm %s <action> <@role, @user, permission or everyone>`", commandPrefix, args.String("action")), 10*time.Second)
+			return
+		}
+		change, err := parseTarget(target, allow)
+		if err != nil {
+			util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, err.Error(), 10*time.Second)
+			return
+		}
+		rule, err := state.permissions.Change(m.GuildID, name, change)
+		if err != nil {
+			util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, err.Error(), 10*time.Second)
+			return
+		}
+		logger.InfoContext(ctx, "permission changed", "action", name, "allow", allow, "target", target)
+		sendQuietly(s, m.ChannelID, fmt.Sprintf("`%s` is now allowed for %s", name, rule))
+
+	case "reset":
+		name := strings.ToLower(args.String("name"))
+		err := state.permissions.Reset(m.GuildID, name)
+		if err != nil {
+			util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, err.Error(), 10*time.Second)
+			return
+		}
+		logger.InfoContext(ctx, "permission reset", "action", name)
+		rule, _ := state.permissions.RuleFor(m.GuildID, name)
+		sendQuietly(s, m.ChannelID, fmt.Sprintf("`%s` is back to %s", name, rule))
+
+	default:
+		util.SendSelfDestructingMessage(s, state.deletions, m.ChannelID, "You can list, allow, deny, reset or audit permissions", 10*time.Second)
+	}
+}
+
+// sendQuietly shows mentions without pinging anyone, listing a role shouldn't notify everyone in it
+func sendQuietly(s session.Session, channelID string, content string) {
+	_, err := s.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content, AllowedMentions: &disc.MessageAllowedMentions{}})
+	if err != nil {
+		logger.Error("error sending message", "channel", channelID, "err", err)
+	}
+}
+
+// parseTarget turns a role mention, user mention, permission name or everyone into a change to a rule
+func parseTarget(target string, allow bool) (func(*permissions.Rule), error) {
+	edit := func(list *[]string, id string) {
+		*list = slices.DeleteFunc(*list, func(existing string) bool { return existing == id })
+		if allow {
+			*list = append(*list, id)
+		}
+	}
+
+	switch {
+	case strings.EqualFold(strings.TrimPrefix(target, "@"), "everyone"):
+		return func(rule *permissions.Rule) { rule.Everyone = allow }, nil
+	case strings.HasPrefix(target, "<@&") && strings.HasSuffix(target, ">"):
+		role := strings.TrimSuffix(strings.TrimPrefix(target, "<@&"), ">")
+		return func(rule *permissions.Rule) { edit(&rule.Roles, role) }, nil
+	case strings.HasPrefix(target, "<@") && strings.HasSuffix(target, ">"):
+		user := strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(target, "<@"), ">"), "!")
+		return func(rule *permissions.Rule) { edit(&rule.Users, user) }, nil
+	}
+
+	permission, ok := permissions.ParsePermission(target)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a role, a user, everyone, or one of %s", target, strings.Join(permissions.PermissionNames(^0), ", "))
+	}
+	return func(rule *permissions.Rule) {
+		if allow {
+			rule.Permissions |= permission
+		} else {
+			rule.Permissions &^= permission
+		}
+	}, nil
+}
@@ -1,27 +1,45 @@
 package discord
 
 import (
+	"context"
 	"fmt"
-	"log"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
 
 	disc "github.com/bwmarrin/discordgo"
 )
 
 // Leverage admin priveleges of the bot to look for reactions and Pin things
+type pinModule struct {
+	module.Base
+	state botState
+}
+
+func (*pinModule) Name() string {
+	return "pin"
+}
+
+func (p *pinModule) Handlers() module.Handlers {
+	return module.Handlers{
+		ReactionAdd:    []module.Handler[*disc.MessageReactionAdd]{{Feature: "pin", Run: p.pinFromReaction}},
+		ReactionRemove: []module.Handler[*disc.MessageReactionRemove]{{Feature: "pin", Run: p.unpinFromReaction}},
+	}
+}
 
-func pinFromReaction(s *disc.Session, m *disc.MessageReactionAdd) {
-	if m.MessageReaction.Emoji.Name != "📌" {
+func (p *pinModule) pinFromReaction(ctx context.Context, s session.Session, m *disc.MessageReactionAdd) {
+	if m.MessageReaction.Emoji.Name != "📌" || !p.state.allowed(s, m.GuildID, m.ChannelID, m.UserID, nil, "pin") {
 		return
 	}
 
 	err := s.ChannelMessagePin(m.ChannelID, m.MessageID)
 	if err != nil {
-		log.Printf("error pinning: %v", err)
+		logger.ErrorContext(ctx, "error pinning", "message", m.MessageID, "err", err)
 	}
 }
 
-func unpinFromReaction(s *disc.Session, m *disc.MessageReactionRemove) {
-	if m.MessageReaction.Emoji.Name != "📌" {
+func (p *pinModule) unpinFromReaction(ctx context.Context, s session.Session, m *disc.MessageReactionRemove) {
+	if m.MessageReaction.Emoji.Name != "📌" || !p.state.allowed(s, m.GuildID, m.ChannelID, m.UserID, nil, "pin") {
 		return
 	}
 
@@ -39,7 +57,7 @@ func unpinFromReaction(s *disc.Session, m *disc.MessageReactionRemove) {
 	var found bool
 	msgs, err := s.ChannelMessagesPinned(m.ChannelID)
 	if err != nil {
-		log.Printf("error checking pinned msgs: %v", err)
+		logger.ErrorContext(ctx, "error checking pinned msgs", "err", err)
 	}
 	for _, pin := range msgs {
 		if pin.ID == msg.ID {
@@ -54,12 +72,12 @@ func unpinFromReaction(s *disc.Session, m *disc.MessageReactionRemove) {
 
 	err = s.ChannelMessageUnpin(m.ChannelID, m.MessageID)
 	if err != nil {
-		log.Printf("error unpinning: %v", err)
+		logger.ErrorContext(ctx, "error unpinning", "message", m.MessageID, "err", err)
 	}
 	if err == nil {
 		_, err = s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unpinning post: %s: %s", msg.Author.Username, msg.Content))
 		if err != nil {
-			log.Printf("error sending unpin message: %v", err)
+			logger.ErrorContext(ctx, "error sending unpin message", "err", err)
 		}
 	}
 }
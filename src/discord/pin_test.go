@@ -0,0 +1,68 @@
+package discord
+
+import (
+	"context"
+	"testing"
+
+	"MelvinBot/src/discord/session"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+func TestUnpinFromReaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		pins     int    // 📌 reactions before one is taken off, counting it if it's a 📌
+		pinned   bool   // Whether the message starts pinned
+		emoji    string // The reaction taken off
+		deleted  bool   // The message is gone by the time we look it up
+		unpinned bool
+	}{
+		{name: "last pin taken off", pins: 1, pinned: true, emoji: "📌", unpinned: true},
+		{name: "another pin left", pins: 2, pinned: true, emoji: "📌"},
+		{name: "never pinned", pins: 1, emoji: "📌"},
+		{name: "other emoji", pins: 1, pinned: true, emoji: "👍"},
+		{name: "message deleted", pins: 1, pinned: true, emoji: "📌", deleted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewFake("bot")
+			msg := s.AddMessage(&disc.Message{ChannelID: "c", GuildID: "g", Content: "hello", Author: &disc.User{ID: "alice", Username: "alice"}})
+			for i := 0; i < test.pins; i++ {
+				s.AddReaction("c", msg.ID, "📌")
+			}
+			if test.emoji != "📌" {
+				s.AddReaction("c", msg.ID, test.emoji)
+			}
+			if test.pinned {
+				s.ChannelMessagePin("c", msg.ID)
+			}
+			s.RemoveReaction("c", msg.ID, test.emoji)
+			if test.deleted {
+				s.RemoveMessage("c", msg.ID)
+			}
+
+			p := &pinModule{state: newBotState()}
+			reaction := &disc.MessageReactionRemove{MessageReaction: &disc.MessageReaction{
+				UserID: "bob", MessageID: msg.ID, ChannelID: "c", GuildID: "g", Emoji: disc.Emoji{Name: test.emoji},
+			}}
+			p.unpinFromReaction(context.Background(), s, reaction)
+
+			stillPinned := len(s.Pins("c")) == 1
+			if test.pinned && stillPinned == test.unpinned {
+				t.Errorf("still pinned = %v, want %v", stillPinned, !test.unpinned)
+			}
+			sent := s.Sent()
+			if !test.unpinned {
+				if len(sent) != 0 {
+					t.Errorf("sent %q, want nothing", sent[0].Content)
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].Content != "Unpinning post: alice: hello" {
+				t.Errorf("sent %v, want the unpin notice", sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package discord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/outbox"
+	"MelvinBot/src/report"
+	"MelvinBot/src/selfdestruct"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+const (
+	eventMessageCreate  = "MESSAGE_CREATE"
+	eventMessageUpdate  = "MESSAGE_UPDATE"
+	eventMessageDelete  = "MESSAGE_DELETE"
+	eventReactionAdd    = "MESSAGE_REACTION_ADD"
+	eventReactionRemove = "MESSAGE_REACTION_REMOVE"
+)
+
+// recordedEvent is one line of a recording
+type recordedEvent struct {
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`
+	Self string          `json:"self"` // The bot user ID at the time, so replay knows which messages were ours
+	Data json.RawMessage `json:"data"`
+}
+
+// RecordEvents appends every event the handlers see to a JSONL file that Replay can read back
+func (bot *Bot) RecordEvents(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open recording file: %w", err)
+	}
+
+	lock := &sync.Mutex{}
+	record := func(s *disc.Session, eventType string, data any) {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			logger.Error("could not record event", "type", eventType, "err", err)
+			return
+		}
+		line, err := json.Marshal(recordedEvent{
+			Time: time.Now(),
+			Type: eventType,
+			Self: session.Wrap(s).BotUserID(),
+			Data: raw,
+		})
+		if err != nil {
+			logger.Error("could not record event", "type", eventType, "err", err)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		_, err = file.Write(append(line, '\n'))
+		if err != nil {
+			logger.Error("could not record event", "type", eventType, "err", err)
+		}
+	}
+
+	bot.discord.AddHandler(func(s *disc.Session, m *disc.MessageCreate) { record(s, eventMessageCreate, m) })
+	bot.discord.AddHandler(func(s *disc.Session, m *disc.MessageUpdate) { record(s, eventMessageUpdate, m) })
+	bot.discord.AddHandler(func(s *disc.Session, m *disc.MessageDelete) { record(s, eventMessageDelete, m) })
+	bot.discord.AddHandler(func(s *disc.Session, m *disc.MessageReactionAdd) { record(s, eventReactionAdd, m) })
+	bot.discord.AddHandler(func(s *disc.Session, m *disc.MessageReactionRemove) { record(s, eventReactionRemove, m) })
+	return nil
+}
+
+// replaySession prints what the bot would have done and keeps the result in the fake so later events can see it
+type replaySession struct {
+	*session.Fake
+	out  io.Writer
+	lock *sync.Mutex
+}
+
+func (r replaySession) print(format string, args ...any) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fmt.Fprintf(r.out, "  -> "+format+"\n", args...)
+}
+
+func (r replaySession) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	return r.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content})
+}
+
+func (r replaySession) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	msg, err := r.Fake.ChannelMessageSendComplex(channelID, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range data.Files {
+		r.print("file   #%s [%s] %s", channelID, msg.ID, file.Name)
+	}
+	if data.Content != "" {
+		r.print("send   #%s [%s] %q", channelID, msg.ID, data.Content)
+	}
+	return msg, nil
+}
+
+func (r replaySession) ChannelFileSend(channelID, name string, reader io.Reader) (*disc.Message, error) {
+	return r.ChannelMessageSendComplex(channelID, &disc.MessageSend{Files: []*disc.File{{Name: name, Reader: reader}}})
+}
+
+func (r replaySession) ChannelMessageDelete(channelID, messageID string) error {
+	r.print("delete #%s [%s]", channelID, messageID)
+	return r.Fake.ChannelMessageDelete(channelID, messageID)
+}
+
+func (r replaySession) ChannelMessagesBulkDelete(channelID string, messages []string) error {
+	r.print("delete #%s %v", channelID, messages)
+	return r.Fake.ChannelMessagesBulkDelete(channelID, messages)
+}
+
+func (r replaySession) ChannelMessagePin(channelID, messageID string) error {
+	r.print("pin    #%s [%s]", channelID, messageID)
+	return r.Fake.ChannelMessagePin(channelID, messageID)
+}
+
+func (r replaySession) ChannelMessageUnpin(channelID, messageID string) error {
+	r.print("unpin  #%s [%s]", channelID, messageID)
+	return r.Fake.ChannelMessageUnpin(channelID, messageID)
+}
+
+// Replay feeds a recording through the same handlers as the live bot without ever talking to Discord.
+// Storage goes to a temp dir so the real stats and quotes are never touched
+func Replay(cfg config.Config, path string, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open recording: %w", err)
+	}
+	defer file.Close()
+
+	tempDir, err := os.MkdirTemp("", "melvinreplay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg.Storage.StatsFile = filepath.Join(tempDir, "stats")
+	cfg.Storage.QuotesFile = filepath.Join(tempDir, "quotes")
+	cfg.Storage.FeaturesFile = filepath.Join(tempDir, "features")
+	cfg.Storage.PermissionsFile = filepath.Join(tempDir, "permissions")
+	cfg.Storage.ScheduleFile = filepath.Join(tempDir, "schedule")
+	cfg.Storage.SelfDestructFile = filepath.Join(tempDir, "selfdestruct")
+	cfg.Storage.DatabaseFile = filepath.Join(tempDir, "melvin.db")
+	bot := NewBot(&cfg)
+	defer bot.closeStorage()
+	fake := replaySession{Fake: session.NewFake(""), out: out, lock: &sync.Mutex{}}
+	// Long messages get split like they would be, the fake never fails so nothing waits to retry
+	api := outbox.New(fake, nil)
+	// Only what's in the recording runs, no jobs or reminders
+	err = bot.initModules(api, func(string, func(context.Context)) bool { return false })
+	if err != nil {
+		return err
+	}
+	err = bot.loadStorage()
+	if err != nil {
+		return err
+	}
+
+	// Reports are printed like anything else the bot sends
+	handlers := newEventHandlers(bot.botState, bot.modules, cfg.Cooldowns, report.New(fake, cfg.Errors), bot.jobs, func(f func(ctx context.Context)) bool {
+		f(context.Background())
+		return true
+	})
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event recordedEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		fake.BotID = event.Self
+		// Cooldowns go by when things happened, not how fast we can replay them
+		eventTime := event.Time
+		handlers.triggers.Clock = func() time.Time { return eventTime }
+		handlers.router.cooldowns.Clock = handlers.triggers.Clock
+		handlers.router.replies.Clock = handlers.triggers.Clock
+		// Self destructs go off in between events, at the time they would have
+		deleteDue(bot.deletions, fake, eventTime, out)
+		bot.deletions.SetClock(handlers.triggers.Clock)
+
+		err = replayEvent(handlers, fake, api, event, out)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	deleteDue(bot.deletions, fake, time.Time{}, out)
+	return nil
+}
+
+// deleteDue runs every self destruct due by until at the time it comes due, so its deletes are printed in order with
+// everything else. A zero until runs everything still waiting, as if the bot had kept going after the recording
+func deleteDue(deletions selfdestruct.Queue, fake replaySession, until time.Time, out io.Writer) {
+	for {
+		next, ok := deletions.Next()
+		if !ok || (!until.IsZero() && next.After(until)) {
+			return
+		}
+		deletions.SetClock(func() time.Time { return next })
+		fmt.Fprintf(out, "%s self destruct\n", next.Format(time.RFC3339))
+		deletions.DeleteDue(context.Background(), fake)
+	}
+}
+
+func replayEvent(handlers *eventHandlers, fake replaySession, api session.Session, event recordedEvent, out io.Writer) error {
+	switch event.Type {
+	case eventMessageCreate:
+		var m disc.MessageCreate
+		err := json.Unmarshal(event.Data, &m)
+		if err != nil {
+			return err
+		}
+		if m.Message == nil || m.Author == nil {
+			return fmt.Errorf("%s has no message or author", event.Type)
+		}
+		// Our own messages are replayed as the handlers send them again
+		if m.Author.ID == event.Self {
+			return nil
+		}
+		fmt.Fprintf(out, "%s %s #%s %s: %q\n", event.Time.Format(time.RFC3339), event.Type, m.ChannelID, m.Author.Username, m.Content)
+		fake.AddMessage(m.Message)
+		handlers.MessageCreate(api, &m)
+
+	case eventMessageUpdate:
+		var m disc.MessageUpdate
+		err := json.Unmarshal(event.Data, &m)
+		if err != nil {
+			return err
+		}
+		if m.Message == nil {
+			return fmt.Errorf("%s has no message", event.Type)
+		}
+		if m.Author == nil || m.Author.ID == event.Self {
+			return nil
+		}
+		fmt.Fprintf(out, "%s %s #%s [%s] %s: %q\n", event.Time.Format(time.RFC3339), event.Type, m.ChannelID, m.ID, m.Author.Username, m.Content)
+		fake.Fake.EditMessage(m.ChannelID, m.ID, m.Content)
+		handlers.MessageUpdate(api, &m)
+
+	case eventMessageDelete:
+		var m disc.MessageDelete
+		err := json.Unmarshal(event.Data, &m)
+		if err != nil {
+			return err
+		}
+		if m.Message == nil {
+			return fmt.Errorf("%s has no message", event.Type)
+		}
+		fmt.Fprintf(out, "%s %s #%s [%s]\n", event.Time.Format(time.RFC3339), event.Type, m.ChannelID, m.ID)
+		fake.RemoveMessage(m.ChannelID, m.ID)
+		handlers.MessageDelete(api, &m)
+
+	case eventReactionAdd:
+		var m disc.MessageReactionAdd
+		err := json.Unmarshal(event.Data, &m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s %s #%s [%s] %s by %s\n", event.Time.Format(time.RFC3339), event.Type, m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+		err = fake.AddReaction(m.ChannelID, m.MessageID, m.Emoji.Name)
+		if err != nil {
+			fmt.Fprintf(out, "  (reaction to a message from before the recording: %v)\n", err)
+		}
+		handlers.ReactionAdd(api, &m)
+
+	case eventReactionRemove:
+		var m disc.MessageReactionRemove
+		err := json.Unmarshal(event.Data, &m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s %s #%s [%s] %s by %s\n", event.Time.Format(time.RFC3339), event.Type, m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+		fake.RemoveReaction(m.ChannelID, m.MessageID, m.Emoji.Name)
+		handlers.ReactionRemove(api, &m)
+
+	default:
+		fmt.Fprintf(out, "skipping unknown event %s\n", event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"MelvinBot/src/cooldown"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/module"
+	"MelvinBot/src/permissions"
+	"MelvinBot/src/report"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+const commandPrefix = module.Prefix
+
+var (
+	commandRuns    = metrics.NewCounter("melvin_command_runs_total", "Commands run, by how they were invoked and whether they finished or panicked.", "command", "outcome")
+	commandSeconds = metrics.NewHistogram("melvin_command_seconds", "How long commands took to run.", metrics.DefaultBuckets, "command")
+)
+
+type Router struct {
+	botState
+	commands  []*module.Command
+	byName    map[string]*module.Command
+	cooldowns *cooldown.Limiter // Keyed by command name, nil means nothing is limited
+	reporter  *report.Reporter
+	replies   *replyLog
+}
+
+func NewRouter(state botState) *Router {
+	r := &Router{botState: state, byName: map[string]*module.Command{}, replies: newReplyLog(state.deletions)}
+	r.Register(&module.Command{
+		Name:        "help",
+		Args:        []module.Arg{{Name: "command", Kind: module.ArgString, Optional: true}},
+		Description: "Lists every command, or shows how to use a single one",
+		DM:          true,
+		Run:         r.help,
+	})
+	return r
+}
+
+func (r *Router) Register(cmds ...*module.Command) {
+	for _, cmd := range cmds {
+		for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+			name = strings.ToLower(name)
+			if _, ok := r.byName[name]; ok {
+				logging.Fatal(logger, "command name registered twice", "command", name)
+			}
+			r.byName[name] = cmd
+		}
+		r.commands = append(r.commands, cmd)
+
+		// Every command can be restricted with !perm under its name
+		action := permissions.Action{Name: cmd.Name, Description: cmd.Usage(), Default: permissions.Rule{Everyone: true}}
+		if cmd.Permission != 0 {
+			action.Default = permissions.Rule{Permissions: cmd.Permission}
+		}
+		r.permissions.Register(action)
+	}
+}
+
+// Lookup finds a command by name or alias, with or without the prefix
+func (r *Router) Lookup(name string) (*module.Command, bool) {
+	cmd, ok := r.byName[strings.ToLower(strings.TrimPrefix(name, commandPrefix))]
+	return cmd, ok
+}
+
+// Handle is the single MessageCreate handler for every registered command
+func (r *Router) Handle(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
+		return // it me
+	}
+
+	if !strings.HasPrefix(m.Content, commandPrefix) {
+		return
+	}
+
+	name, input := util.CutWord(strings.TrimPrefix(m.Content, commandPrefix))
+	cmd, ok := r.Lookup(name)
+	if !ok {
+		return
+	}
+	if m.GuildID == "" {
+		r.handleDM(ctx, s, m, name, cmd, input)
+		return
+	}
+	if !r.features.Enabled(m.GuildID, cmd.Feature) {
+		return
+	}
+	// Everything from here on is a reply, including errors, so editing the command can replace it
+	s = r.replies.track(s, m)
+	if cmd.DMOnly {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("%s only works in DMs, message it to me instead", commandPrefix+name), 10*time.Second)
+		return
+	}
+
+	args, err := cmd.ParseArgs(input)
+	if err != nil {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("%v, usage: `%s`", err, cmd.Usage()), 10*time.Second)
+		return
+	}
+
+	if !r.permitted(s, m, cmd, commandPrefix+name) || !r.cooledDown(s, m, cmd, commandPrefix+name) {
+		return
+	}
+	r.run(ctx, s, m, cmd, args, commandPrefix+cmd.Name)
+}
+
+// run reports a command that panics under its own name, and lets whoever ran it know it broke
+func (r *Router) run(ctx context.Context, s session.Session, m *disc.MessageCreate, cmd *module.Command, args module.Args, source string) {
+	ctx = logging.With(ctx, "command", source)
+	start := time.Now()
+	outcome := "panic"
+	defer func() {
+		commandRuns.Inc(source, outcome)
+		commandSeconds.Since(start, source)
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			r.reporter.Report(report.Failure{
+				Time:   time.Now(),
+				Source: source,
+				Where:  report.Where{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID},
+				Err:    fmt.Sprintf("panic: %v", recovered),
+				Stack:  string(debug.Stack()),
+			})
+			util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("Something went wrong running %s, it's been reported", source), 10*time.Second)
+		}
+	}()
+	cmd.Run(ctx, s, m, args)
+	outcome = "ok"
+}
+
+// permitted checks whether the author can run cmd here, whoever the error reports go to can always run anything
+func (r *Router) permitted(s session.Session, m *disc.MessageCreate, cmd *module.Command, invoked string) bool {
+	return isOwner(m, r.reporter) || r.allowedToMessage(s, m, cmd.Name, "use "+invoked)
+}
+
+// cooledDown uses up one of cmd's cooldown for the author and channel, telling them to slow down if it's out
+func (r *Router) cooledDown(s session.Session, m *disc.MessageCreate, cmd *module.Command, invoked string) bool {
+	ok, wait := r.cooldowns.Allow(cmd.Name, m.Author.ID, m.ChannelID)
+	if !ok && r.cooldowns.Reply(cmd.Name) {
+		util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("Slow down, you can use %s again in %s", invoked, wait.Round(time.Second)), 5*time.Second)
+	}
+	return ok
+}
+
+func (r *Router) help(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+	if args.Has("command") {
+		cmd, ok := r.Lookup(args.String("command"))
+		if !ok || !r.listed(s, m, cmd) {
+			util.SendSelfDestructingMessage(s, r.deletions, m.ChannelID, fmt.Sprintf("I don't know a command called %s", args.String("command")), 10*time.Second)
+			return
+		}
+
+		var help strings.Builder
+		help.WriteString(fmt.Sprintf("`%s`\n%s", cmd.Usage(), cmd.Description))
+		if len(cmd.Aliases) > 0 {
+			help.WriteString(fmt.Sprintf("\nAliases: %s", strings.Join(cmd.Aliases, ", ")))
+		}
+		s.ChannelMessageSend(m.ChannelID, help.String())
+		return
+	}
+
+	available := []*module.Command{}
+	for _, cmd := range r.commands {
+		if r.listed(s, m, cmd) {
+			available = append(available, cmd)
+		}
+	}
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].Name < available[j].Name
+	})
+
+	var help strings.Builder
+	help.WriteString("**Melvin Commands**")
+	for _, cmd := range available {
+		note := ""
+		if cmd.DMOnly && !inDM(s) {
+			note = " (DM me)"
+		}
+		help.WriteString(fmt.Sprintf("\n`%s` - %s%s", cmd.Usage(), cmd.Description, note))
+	}
+	help.WriteString(fmt.Sprintf("\nUse `%shelp <command>` for more on a single command", commandPrefix))
+	if inDM(s) {
+		help.WriteString(", and add `--guild <server>` to pick a server if we're in more than one together")
+	}
+	s.ChannelMessageSend(m.ChannelID, help.String())
+}
+
+// listed is whether help should show cmd, in DMs that's only the commands that work there
+func (r *Router) listed(s session.Session, m *disc.MessageCreate, cmd *module.Command) bool {
+	if inDM(s) && !cmd.DM && !cmd.DMOnly {
+		return false
+	}
+	return r.features.Enabled(m.GuildID, cmd.Feature)
+}
@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/cooldown"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+func TestRouterCooldowns(t *testing.T) {
+	type run struct {
+		at       time.Duration // Since the first run
+		userID   string
+		ran      bool
+		slowDown string // Start of the reply if it didn't run, empty for none
+	}
+	tests := []struct {
+		name  string
+		limit config.Cooldown
+		runs  []run
+	}{
+		{
+			name:  "per user with a reply",
+			limit: config.Cooldown{User: config.Rate{Count: 1, Per: 30 * time.Second}, Reply: true},
+			runs: []run{
+				{at: 0, userID: "1", ran: true},
+				{at: 10 * time.Second, userID: "1", slowDown: "Slow down, you can use !echo again in 20s"},
+				{at: 10 * time.Second, userID: "2", ran: true},
+				{at: 30 * time.Second, userID: "1", ran: true},
+			},
+		},
+		{
+			name:  "per channel without a reply",
+			limit: config.Cooldown{Channel: config.Rate{Count: 2, Per: time.Minute}},
+			runs: []run{
+				{at: 0, userID: "1", ran: true},
+				{at: 0, userID: "2", ran: true},
+				{at: 0, userID: "3"},
+				{at: 30 * time.Second, userID: "3", ran: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ran := 0
+			r := NewRouter(newBotState())
+			r.Register(&module.Command{Name: "echo", Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				ran++
+			}})
+			start := time.Now()
+			now := start
+			r.cooldowns = cooldown.New(map[string]config.Cooldown{"echo": test.limit})
+			r.cooldowns.Clock = func() time.Time { return now }
+
+			for i, run := range test.runs {
+				now = start.Add(run.at)
+				s := session.NewFake("bot")
+				before := ran
+				m := &disc.MessageCreate{Message: &disc.Message{ID: "100", GuildID: "10", ChannelID: "20", Content: "!echo", Author: &disc.User{ID: run.userID}}}
+				r.Handle(context.Background(), s, m)
+
+				if got := ran > before; got != run.ran {
+					t.Errorf("run %d by %s at %s ran = %v, want %v", i, run.userID, run.at, got, run.ran)
+				}
+				sent := s.Sent()
+				switch {
+				case run.slowDown == "" && len(sent) != 0:
+					t.Errorf("run %d by %s at %s sent %q, want nothing", i, run.userID, run.at, sent[0].Content)
+				case run.slowDown != "" && (len(sent) != 1 || !strings.HasPrefix(sent[0].Content, run.slowDown)):
+					t.Errorf("run %d by %s at %s sent %v, want %q", i, run.userID, run.at, sent, run.slowDown)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+	"MelvinBot/src/scheduler"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// scheduleCommand looks after the bot's jobs, which run for every server so any admin sees and changes all of them
+func scheduleCommand(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args, jobs *scheduler.Scheduler, deletions selfdestruct.Queue) {
+	action := strings.ToLower(args.String("action"))
+	name := strings.TrimSpace(args.String("job"))
+	if action == "" || action == "list" {
+		s.ChannelMessageSend(m.ChannelID, formatJobs(jobs.List(true)))
+		return
+	}
+	if name == "" {
+		util.SendSelfDestructingMessage(s, deletions, m.ChannelID, fmt.Sprintf("Usage: `%sschedule %s <job>`", commandPrefix, action), 10*time.Second)
+		return
+	}
+
+	var err error
+	var done string
+	switch action {
+	case "pause":
+		err, done = jobs.Pause(name, true), "is paused, it can still be run by hand"
+	case "resume":
+		err, done = jobs.Pause(name, false), "is back on schedule"
+	case "run":
+		err, done = jobs.Trigger(name), "is running"
+	case "delete":
+		err, done = jobs.Delete(name, true), fmt.Sprintf("won't run again, even after a restart, unless it's restored with `%sschedule restore`", commandPrefix)
+	case "restore":
+		err, done = jobs.Delete(name, false), "is back"
+	default:
+		util.SendSelfDestructingMessage(s, deletions, m.ChannelID, "You can list, pause, resume, run, delete or restore jobs", 10*time.Second)
+		return
+	}
+	if err != nil {
+		util.SendSelfDestructingMessage(s, deletions, m.ChannelID, err.Error(), 10*time.Second)
+		return
+	}
+	logger.InfoContext(ctx, "scheduled job changed", "job", name, "action", action)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("`%s` %s", name, done))
+}
+
+func formatJobs(infos []scheduler.Info) string {
+	if len(infos) == 0 {
+		return "Nothing is scheduled"
+	}
+
+	var list strings.Builder
+	list.WriteString("**Scheduled jobs**")
+	for _, info := range infos {
+		list.WriteString(fmt.Sprintf("\n`%s` `%s` %s", info.Name, info.Spec, info.Timezone))
+		switch {
+		case info.Deleted:
+			list.WriteString(", deleted")
+		case info.Paused:
+			list.WriteString(", paused")
+		default:
+			// Discord shows the relative part in everyone's own time
+			list.WriteString(fmt.Sprintf(", next %s (<t:%d:R>)", info.Next.Format("Mon 2 Jan 15:04 MST"), info.Next.Unix()))
+		}
+		if !info.LastRun.IsZero() {
+			list.WriteString(fmt.Sprintf(", last ran <t:%d:R>", info.LastRun.Unix()))
+		}
+	}
+	return list.String()
+}
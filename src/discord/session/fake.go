@@ -0,0 +1,354 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Fake is an in memory Session for tests. Seed it with AddMessage and AddUser, then check Sent, Deleted and Pins
+type Fake struct {
+	BotID string
+	// Permissions per user ID, returned for every channel
+	Permissions map[string]int64
+	// Role IDs per user ID, the same in every guild
+	Roles map[string][]string
+	// Guilds the bot is in. One with Members only has those users, GuildMember fails for anyone else
+	Guilds []*disc.Guild
+
+	lock     sync.Mutex
+	nextID   int
+	users    map[string]*disc.User
+	channels map[string][]*disc.Message // oldest first, like a channel
+	sent     []*disc.Message
+	deleted  []*disc.Message
+	pins     map[string][]string // channel ID -> pinned message IDs
+	dms      map[string]string   // user ID -> DM channel ID
+}
+
+func NewFake(botID string) *Fake {
+	return &Fake{
+		BotID:       botID,
+		Permissions: map[string]int64{},
+		Roles:       map[string][]string{},
+		nextID:      1000,
+		users:       map[string]*disc.User{botID: {ID: botID, Username: "Melvin", Bot: true}},
+		channels:    map[string][]*disc.Message{},
+		pins:        map[string][]string{},
+		dms:         map[string]string{},
+	}
+}
+
+func (f *Fake) newID() string {
+	f.nextID++
+	return strconv.Itoa(f.nextID)
+}
+
+func (f *Fake) AddUser(user *disc.User) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.users[user.ID] = user
+}
+
+// AddMessage puts a message in its channel as if someone else posted it, an empty ID gets filled in
+func (f *Fake) AddMessage(msg *disc.Message) *disc.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if msg.ID == "" {
+		msg.ID = f.newID()
+	}
+	if msg.Author != nil {
+		if _, ok := f.users[msg.Author.ID]; !ok {
+			f.users[msg.Author.ID] = msg.Author
+		}
+	}
+	f.channels[msg.ChannelID] = append(f.channels[msg.ChannelID], msg)
+	return msg
+}
+
+// EditMessage changes a message as if its author edited it, returning a copy to send as the update
+func (f *Fake) EditMessage(channelID, messageID, content string) (*disc.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, msg, err := f.find(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	msg.Content = content
+	msg.EditedTimestamp = disc.Timestamp(time.Now().Format(time.RFC3339))
+	edited := *msg
+	return &edited, nil
+}
+
+// RemoveMessage is someone else deleting their message, unlike ChannelMessageDelete it isn't counted in Deleted
+func (f *Fake) RemoveMessage(channelID, messageID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	i, _, err := f.find(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	f.channels[channelID] = slices.Delete(f.channels[channelID], i, i+1)
+	return nil
+}
+
+// AddReaction counts a reaction on a message the same way Discord would before telling us about it
+func (f *Fake) AddReaction(channelID, messageID, emoji string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, msg, err := f.find(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	for _, reaction := range msg.Reactions {
+		if reaction.Emoji.Name == emoji {
+			reaction.Count++
+			return nil
+		}
+	}
+	msg.Reactions = append(msg.Reactions, &disc.MessageReactions{Count: 1, Emoji: &disc.Emoji{Name: emoji}})
+	return nil
+}
+
+func (f *Fake) RemoveReaction(channelID, messageID, emoji string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, msg, err := f.find(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	for i, reaction := range msg.Reactions {
+		if reaction.Emoji.Name == emoji {
+			reaction.Count--
+			if reaction.Count <= 0 {
+				msg.Reactions = slices.Delete(msg.Reactions, i, i+1)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Sent is everything the bot has sent, in order, including messages it later deleted
+func (f *Fake) Sent() []*disc.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.sent)
+}
+
+func (f *Fake) Deleted() []*disc.Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.deleted)
+}
+
+func (f *Fake) Pins(channelID string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.pins[channelID])
+}
+
+func (f *Fake) find(channelID, messageID string) (int, *disc.Message, error) {
+	for i, msg := range f.channels[channelID] {
+		if msg.ID == messageID {
+			return i, msg, nil
+		}
+	}
+	return -1, nil, fmt.Errorf("unknown message %s in channel %s", messageID, channelID)
+}
+
+func (f *Fake) BotUserID() string {
+	return f.BotID
+}
+
+func (f *Fake) BotGuilds() []*disc.Guild {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.Guilds)
+}
+
+func (f *Fake) User(userID string) (*disc.User, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("unknown user %s", userID)
+	}
+	return user, nil
+}
+
+func (f *Fake) UserChannelPermissions(userID, channelID string) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.Permissions[userID], nil
+}
+
+func (f *Fake) GuildMember(guildID, userID string) (*disc.Member, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, guild := range f.Guilds {
+		if guild.ID == guildID && len(guild.Members) > 0 && !slices.ContainsFunc(guild.Members, func(member *disc.Member) bool { return member.User.ID == userID }) {
+			return nil, fmt.Errorf("user %s isn't in guild %s", userID, guildID)
+		}
+	}
+	user, ok := f.users[userID]
+	if !ok {
+		user = &disc.User{ID: userID}
+	}
+	return &disc.Member{GuildID: guildID, User: user, Roles: slices.Clone(f.Roles[userID])}, nil
+}
+
+// DM channels get a fresh ID per user, messages sent to them show up in Sent like any other
+func (f *Fake) UserChannelCreate(userID string) (*disc.Channel, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	channelID, ok := f.dms[userID]
+	if !ok {
+		channelID = f.newID()
+		f.dms[userID] = channelID
+	}
+	channel := &disc.Channel{ID: channelID, Type: disc.ChannelTypeDM}
+	if user, ok := f.users[userID]; ok {
+		channel.Recipients = []*disc.User{user}
+	}
+	return channel, nil
+}
+
+func (f *Fake) ChannelMessage(channelID, messageID string) (*disc.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, msg, err := f.find(channelID, messageID)
+	return msg, err
+}
+
+// ChannelMessages only supports beforeID, which is all we use. Like Discord it returns newest first
+func (f *Fake) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) ([]*disc.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	msgs := f.channels[channelID]
+	if beforeID != "" {
+		i, _, err := f.find(channelID, beforeID)
+		if err != nil {
+			return nil, err
+		}
+		msgs = msgs[:i]
+	}
+
+	newestFirst := []*disc.Message{}
+	for i := len(msgs) - 1; i >= 0 && len(newestFirst) < limit; i-- {
+		newestFirst = append(newestFirst, msgs[i])
+	}
+	return newestFirst, nil
+}
+
+func (f *Fake) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	return f.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content})
+}
+
+// Sent files are turned into attachments named after the file
+func (f *Fake) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	msg := &disc.Message{
+		ID:        f.newID(),
+		ChannelID: channelID,
+		Content:   data.Content,
+		Author:    f.users[f.BotID],
+	}
+	for _, file := range data.Files {
+		msg.Attachments = append(msg.Attachments, &disc.MessageAttachment{ID: f.newID(), Filename: file.Name})
+	}
+
+	f.channels[channelID] = append(f.channels[channelID], msg)
+	f.sent = append(f.sent, msg)
+	return msg, nil
+}
+
+func (f *Fake) ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error) {
+	return f.ChannelMessageSendComplex(channelID, &disc.MessageSend{Files: []*disc.File{{Name: name, Reader: r}}})
+}
+
+func (f *Fake) ChannelMessageDelete(channelID, messageID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	i, msg, err := f.find(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	f.channels[channelID] = slices.Delete(f.channels[channelID], i, i+1)
+	f.deleted = append(f.deleted, msg)
+	return nil
+}
+
+// ChannelMessagesBulkDelete fails without deleting anything if any message isn't there, like Discord
+func (f *Fake) ChannelMessagesBulkDelete(channelID string, messages []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, messageID := range messages {
+		if _, _, err := f.find(channelID, messageID); err != nil {
+			return err
+		}
+	}
+	for _, messageID := range messages {
+		i, msg, _ := f.find(channelID, messageID)
+		f.channels[channelID] = slices.Delete(f.channels[channelID], i, i+1)
+		f.deleted = append(f.deleted, msg)
+	}
+	return nil
+}
+
+func (f *Fake) ChannelMessagePin(channelID, messageID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, _, err := f.find(channelID, messageID); err != nil {
+		return err
+	}
+	if !slices.Contains(f.pins[channelID], messageID) {
+		f.pins[channelID] = append(f.pins[channelID], messageID)
+	}
+	return nil
+}
+
+func (f *Fake) ChannelMessageUnpin(channelID, messageID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	i := slices.Index(f.pins[channelID], messageID)
+	if i == -1 {
+		return fmt.Errorf("message %s is not pinned", messageID)
+	}
+	f.pins[channelID] = slices.Delete(f.pins[channelID], i, i+1)
+	return nil
+}
+
+func (f *Fake) ChannelMessagesPinned(channelID string) ([]*disc.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	pinned := []*disc.Message{}
+	for _, messageID := range f.pins[channelID] {
+		if _, msg, err := f.find(channelID, messageID); err == nil {
+			pinned = append(pinned, msg)
+		}
+	}
+	return pinned, nil
+}
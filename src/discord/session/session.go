@@ -0,0 +1,97 @@
+package session
+
+import (
+	"io"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Session is every Discord call Melvin makes, handlers take this instead of *disc.Session so they can run against the Fake
+type Session interface {
+	// BotUserID is who we are logged in as, mostly so handlers can ignore their own messages
+	BotUserID() string
+	// BotGuilds is every guild we're in, from the gateway's cache so only the ID and name are sure to be set
+	BotGuilds() []*disc.Guild
+
+	User(userID string) (*disc.User, error)
+	UserChannelPermissions(userID, channelID string) (int64, error)
+	// UserChannelCreate gets the DM channel with a user, making it if we've never talked before
+	UserChannelCreate(userID string) (*disc.Channel, error)
+	// GuildMember is mostly for roles, reaction events don't come with them
+	GuildMember(guildID, userID string) (*disc.Member, error)
+
+	ChannelMessage(channelID, messageID string) (*disc.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) ([]*disc.Message, error)
+	ChannelMessageSend(channelID string, content string) (*disc.Message, error)
+	ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error)
+	ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	// ChannelMessagesBulkDelete takes 2 to 100 messages no older than two weeks, one is deleted on its own
+	ChannelMessagesBulkDelete(channelID string, messages []string) error
+
+	ChannelMessagePin(channelID, messageID string) error
+	ChannelMessageUnpin(channelID, messageID string) error
+	ChannelMessagesPinned(channelID string) ([]*disc.Message, error)
+}
+
+// Ephemeral is implemented by sessions answering a slash command, where a message can be shown to just the invoker
+type Ephemeral interface {
+	ChannelMessageSendEphemeral(channelID string, content string) (*disc.Message, error)
+}
+
+// Splitting is implemented by sessions that might send one message as several, like the outbox does with long ones.
+// Everything that was sent comes back in order, even when a later piece failed
+type Splitting interface {
+	ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error)
+}
+
+// SendSplit is every message it took to send data, so whoever wants to delete it later can get all of it
+func SendSplit(s Session, channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	if splitting, ok := s.(Splitting); ok {
+		return splitting.ChannelMessageSendSplit(channelID, data)
+	}
+	msg, err := s.ChannelMessageSendComplex(channelID, data)
+	if err != nil {
+		return nil, err
+	}
+	return []*disc.Message{msg}, nil
+}
+
+type live struct {
+	*disc.Session
+}
+
+// Wrap is how the real discordgo session gets handed to handlers
+func Wrap(s *disc.Session) Session {
+	return live{s}
+}
+
+// GuildMember tries the gateway's cache before asking the API
+func (l live) GuildMember(guildID, userID string) (*disc.Member, error) {
+	if l.State != nil {
+		if member, err := l.State.Member(guildID, userID); err == nil {
+			return member, nil
+		}
+	}
+	return l.Session.GuildMember(guildID, userID)
+}
+
+func (l live) BotUserID() string {
+	if l.State == nil || l.State.User == nil {
+		return ""
+	}
+	return l.State.User.ID
+}
+
+func (l live) BotGuilds() []*disc.Guild {
+	if l.State == nil {
+		return nil
+	}
+	l.State.RLock()
+	defer l.State.RUnlock()
+	guilds := make([]*disc.Guild, 0, len(l.State.Guilds))
+	for _, guild := range l.State.Guilds {
+		guilds = append(guilds, &disc.Guild{ID: guild.ID, Name: guild.Name})
+	}
+	return guilds
+}
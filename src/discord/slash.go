@@ -0,0 +1,170 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/interactions"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/module"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// slashCommands is the definition of every command marked Slash, in the shape Discord wants to register
+func (r *Router) slashCommands() []interactions.ApplicationCommand {
+	defs := []interactions.ApplicationCommand{}
+	for _, cmd := range r.commands {
+		if !cmd.Slash {
+			continue
+		}
+		def := interactions.ApplicationCommand{Name: cmd.Name, Description: cmd.Description}
+		for _, arg := range cmd.Args {
+			option := interactions.ApplicationCommandOption{
+				Type:        interactions.OptionString,
+				Name:        arg.Name,
+				Description: arg.Description,
+				Required:    !arg.Optional,
+			}
+			if arg.Kind == module.ArgInt {
+				option.Type = interactions.OptionInteger
+			}
+			if option.Description == "" {
+				option.Description = arg.Name
+			}
+			def.Options = append(def.Options, option)
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// HandleInteraction runs a slash command through the same Run as its ! version, with a session that turns
+// everything sent to the channel into the interaction's reply
+func (r *Router) HandleInteraction(ctx context.Context, s session.Session, i *interactions.Interaction, reply *interactions.Reply) {
+	ctx = logging.With(ctx, "guild", i.GuildID, "channel", i.ChannelID)
+	slash := &interactionSession{Session: s, channelID: i.ChannelID, reply: reply, sent: map[string]bool{}}
+
+	invoker := i.Invoker()
+	cmd, ok := r.Lookup(i.Data.Name)
+	if invoker == nil || !ok || !cmd.Slash {
+		slash.ChannelMessageSendEphemeral(i.ChannelID, fmt.Sprintf("I don't know a command called /%s", i.Data.Name))
+		return
+	}
+	if !r.features.Enabled(i.GuildID, cmd.Feature) {
+		slash.ChannelMessageSendEphemeral(i.ChannelID, fmt.Sprintf("/%s is turned off in this server", cmd.Name))
+		return
+	}
+
+	// Discord has already checked the options against what we registered
+	args := module.Args{}
+	content := "/" + cmd.Name
+	for _, option := range i.Data.Options {
+		args[option.Name] = option.String()
+		content += " " + option.String()
+	}
+
+	m := &disc.MessageCreate{Message: &disc.Message{
+		ID:        i.ID,
+		ChannelID: i.ChannelID,
+		GuildID:   i.GuildID,
+		Author:    invoker,
+		Member:    i.Member,
+		Content:   content,
+	}}
+	if !r.permitted(slash, m, cmd, "/"+cmd.Name) || !r.cooledDown(slash, m, cmd, "/"+cmd.Name) {
+		return
+	}
+	r.run(ctx, slash, m, cmd, args, "/"+cmd.Name)
+}
+
+// interactionSession sends to the interaction's channel through its reply and passes everything else through
+type interactionSession struct {
+	session.Session
+	channelID string
+	reply     *interactions.Reply
+
+	lock sync.Mutex
+	sent map[string]bool // Message IDs that came from the reply, these have to be deleted through it too
+}
+
+func (is *interactionSession) send(data *disc.MessageSend, ephemeral bool) (*disc.Message, error) {
+	msg, err := is.reply.Send(data, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("discord did not send back the interaction reply")
+	}
+	is.lock.Lock()
+	is.sent[msg.ID] = true
+	is.lock.Unlock()
+	return msg, nil
+}
+
+func (is *interactionSession) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	return is.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content})
+}
+
+func (is *interactionSession) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	if channelID != is.channelID {
+		return is.Session.ChannelMessageSendComplex(channelID, data)
+	}
+	return is.send(data, false)
+}
+
+// ChannelMessageSendSplit only splits messages to other channels, the reply is always one message
+func (is *interactionSession) ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	if channelID != is.channelID {
+		return session.SendSplit(is.Session, channelID, data)
+	}
+	msg, err := is.send(data, false)
+	if err != nil {
+		return nil, err
+	}
+	return []*disc.Message{msg}, nil
+}
+
+func (is *interactionSession) ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error) {
+	return is.ChannelMessageSendComplex(channelID, &disc.MessageSend{Files: []*disc.File{{Name: name, Reader: r}}})
+}
+
+func (is *interactionSession) ChannelMessageSendEphemeral(channelID string, content string) (*disc.Message, error) {
+	if channelID != is.channelID {
+		return is.Session.ChannelMessageSend(channelID, content)
+	}
+	return is.send(&disc.MessageSend{Content: content}, true)
+}
+
+func (is *interactionSession) ChannelMessageDelete(channelID, messageID string) error {
+	is.lock.Lock()
+	fromReply := is.sent[messageID]
+	is.lock.Unlock()
+	if !fromReply {
+		return is.Session.ChannelMessageDelete(channelID, messageID)
+	}
+	return is.reply.Delete(messageID)
+}
+
+// registerSlashCommands overwrites our slash commands with Discord, per guild if any are configured
+func registerSlashCommands(s *disc.Session, applicationID string, guildIDs []string, defs []interactions.ApplicationCommand) error {
+	if len(guildIDs) == 0 {
+		return interactions.RegisterCommands(s, applicationID, "", defs)
+	}
+	failed := []string{}
+	for _, guildID := range guildIDs {
+		err := interactions.RegisterCommands(s, applicationID, guildID, defs)
+		if err != nil {
+			logger.Error("could not register slash commands", "guild", guildID, "err", err)
+			failed = append(failed, guildID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not register slash commands in guilds %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package dota2matchreminder
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Module reminds the reminder channel 30 minutes before a tracked team plays, and answers !dota2matches
+type Module struct {
+	module.Base
+	session session.Session
+	logger  *slog.Logger
+	// Set from the config file in Init
+	reminderChannelID string
+	errorChannelID    string
+	// run is how reminders get started, so shutdown knows about them
+	run func(f func(ctx context.Context)) bool
+
+	// lock covers the matches and reminders, the job and !dota2matches can both refresh them at once
+	lock          sync.Mutex
+	cachedMatches []Match
+	reminderMap   map[string]map[time.Time]OpponentAndTimer // Map [ team name ] -> time as string to dedupe reminders (against opponent)
+	lastRequest   time.Time
+}
+
+func (*Module) Name() string {
+	return "dota2matchreminder"
+}
+
+func (d *Module) Init(deps module.Deps) error {
+	d.logger = deps.Logger
+	d.reminderChannelID = deps.Config.Dota.ReminderChannelID
+	d.errorChannelID = deps.Config.Dota.ErrorChannelID
+	d.run = func(f func(ctx context.Context)) bool {
+		return deps.Go("dota reminder", f)
+	}
+	d.session = deps.Session
+
+	if d.reminderChannelID != "" {
+		d.run(func(ctx context.Context) { d.refresh(ctx) })
+	}
+	return nil
+}
+
+func (d *Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "dota2matches",
+			Aliases:     []string{"dota"},
+			Description: "Lists upcoming pro matches for the tracked Dota 2 teams",
+			Feature:     "dota",
+			Slash:       true,
+			DM:          true,
+			Run: func(ctx context.Context, s session.Session, m *discordgo.MessageCreate, args module.Args) {
+				d.HandleDota2Matches(ctx, s, m)
+			},
+		},
+	}
+}
+
+// ScheduledJobs polls everyday at noon and midnight UTC
+func (d *Module) ScheduledJobs() []module.Job {
+	if d.reminderChannelID == "" {
+		return nil
+	}
+	return []module.Job{{
+		Name:     "dota reminder",
+		Spec:     "0 0 0,12 * * *",
+		Timezone: "UTC",
+		Run:      func(ctx context.Context) { d.refresh(ctx) },
+	}}
+}
+
+func (d *Module) Shutdown() {
+	d.stopReminders()
+}
@@ -1,20 +1,21 @@
 package dota2matchreminder
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
 
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/metrics"
+
 	"github.com/bwmarrin/discordgo"
-	cron "github.com/robfig/cron"
 )
 
-const doNotCookieMeGeneralChannel string = "1180043669009604700"
-const doNotCookieMeIdiotBotChannel string = "1180047689044463666"
+var client = metrics.Client("dota")
 
 const myBestFriendsWebsite string = "https://dota.haglund.dev/v1/matches"
 
@@ -25,12 +26,6 @@ const (
 
 var trackedTeams []string = []string{TeamFalcons, TeamNigma}
 
-var cachedMatches []Match = []Match{}
-
-var reminderMap map[string]map[time.Time]OpponentAndTimer = map[string]map[time.Time]OpponentAndTimer{} // Map [ team name ] -> time as string to dedupe reminders (against opponent)
-
-var lastRequest time.Time = time.Time{}
-
 type OpponentAndTimer struct {
 	opponent string
 	timer    *time.Timer
@@ -51,56 +46,44 @@ type Match struct {
 	StreamUrl  *string `json:"streamUrl"`
 }
 
-func StartDota2MatchReminder(disc *discordgo.Session) error {
-	// Startup and then
-	err := GetAndCacheMatchesAndSetUpReminders(disc)
+// refresh gets the latest matches, telling the error channel if it can't
+func (d *Module) refresh(ctx context.Context) {
+	err := d.GetAndCacheMatchesAndSetUpReminders(ctx)
 	if err != nil {
-		_, err := disc.ChannelMessageSend(doNotCookieMeIdiotBotChannel, err.Error())
+		d.logger.ErrorContext(ctx, "failed to refresh matches", "err", err)
+		_, err := d.session.ChannelMessageSend(d.errorChannelID, err.Error())
 		if err != nil {
-			log.Println("Failed to send a message to discord in dota 2 reminder routine")
+			d.logger.ErrorContext(ctx, "failed to send refresh error to discord", "channel", d.errorChannelID, "err", err)
 		}
 	}
-	// Start the cached updater to poll everyday at noon and midnight
+}
 
-	c := cron.New()
+// stopReminders drops every reminder that hasn't gone off yet
+func (d *Module) stopReminders() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
 
-	err = c.AddFunc("0 0 * * *", func() {
-		err := GetAndCacheMatchesAndSetUpReminders(disc)
-		if err != nil {
-			_, err := disc.ChannelMessageSend(doNotCookieMeIdiotBotChannel, err.Error())
-			if err != nil {
-				log.Println("Failed to send a message to discord in dota 2 reminder routine")
+	for _, reminders := range d.reminderMap {
+		for _, reminder := range reminders {
+			if reminder.timer != nil {
+				reminder.timer.Stop()
 			}
 		}
-	})
-	if err != nil {
-		return err
 	}
-
-	err = c.AddFunc("0 12 * * *", func() {
-		err := GetAndCacheMatchesAndSetUpReminders(disc)
-		if err != nil {
-			_, err := disc.ChannelMessageSend(doNotCookieMeIdiotBotChannel, err.Error())
-			if err != nil {
-				log.Println("Failed to send a message to discord in dota 2 reminder routine")
-			}
-		}
-	})
-	if err != nil {
-		return err
-	}
-
-	c.Start()
-	return nil
 }
 
-func FetchFromMatchesSite() error {
+// FetchFromMatchesSite needs the lock held
+func (d *Module) FetchFromMatchesSite(ctx context.Context) error {
 
-	if time.Since(lastRequest) < 10*time.Minute {
+	if time.Since(d.lastRequest) < 10*time.Minute {
 		return nil
 	}
 
-	resp, err := http.Get(myBestFriendsWebsite)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, myBestFriendsWebsite, nil)
+	if err != nil {
+		return fmt.Errorf("failed to grab from dota 2 tournament api: %v", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to grab from dota 2 tournament api: %v", err)
 	}
@@ -116,27 +99,36 @@ func FetchFromMatchesSite() error {
 		return fmt.Errorf("failed to deserialize from dota 2 tournament api: %v", err)
 	}
 
-	cachedMatches = matches
-	lastRequest = time.Now()
+	d.cachedMatches = matches
+	d.lastRequest = time.Now()
 	return nil
 }
 
-func GetAndCacheMatchesAndSetUpReminders(disc *discordgo.Session) error {
-	err := FetchFromMatchesSite()
+// GetAndCacheMatchesAndSetUpReminders always reminds through the module's own session, the one a command came in on
+// might be gone by the time a match starts
+func (d *Module) GetAndCacheMatchesAndSetUpReminders(ctx context.Context) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	err := d.FetchFromMatchesSite(ctx)
 	if err != nil {
 		return err
 	}
 
 	for _, team := range trackedTeams {
-		CheckMatchesForTeamAndCreateReminderTimers(disc, team)
+		d.CheckMatchesForTeamAndCreateReminderTimers(team)
 	}
 
 	return nil
 }
 
-func CheckMatchesForTeamAndCreateReminderTimers(disc *discordgo.Session, team string) {
+// CheckMatchesForTeamAndCreateReminderTimers needs the lock held
+func (d *Module) CheckMatchesForTeamAndCreateReminderTimers(team string) {
 	tbd := "TBD"
-	for _, match := range cachedMatches {
+	if d.reminderMap == nil {
+		d.reminderMap = map[string]map[time.Time]OpponentAndTimer{}
+	}
+	for _, match := range d.cachedMatches {
 
 		if match.Teams[0].Name == nil {
 			match.Teams[0].Name = &tbd
@@ -155,7 +147,7 @@ func CheckMatchesForTeamAndCreateReminderTimers(disc *discordgo.Session, team st
 		// Parse time
 		matchTime, err := time.Parse(time.RFC3339, *match.StartsAt)
 		if err != nil {
-			log.Printf("failed to parse match time from %+v \n", *match.StartsAt)
+			d.logger.Error("failed to parse match time", "team", team, "startsAt", *match.StartsAt, "err", err)
 		}
 
 		var opponent string
@@ -165,12 +157,12 @@ func CheckMatchesForTeamAndCreateReminderTimers(disc *discordgo.Session, team st
 			opponent = *match.Teams[0].Name
 		}
 
-		_, ok := reminderMap[team]
+		_, ok := d.reminderMap[team]
 		if !ok {
-			reminderMap[team] = map[time.Time]OpponentAndTimer{}
+			d.reminderMap[team] = map[time.Time]OpponentAndTimer{}
 		}
 
-		oppTimer, ok := reminderMap[team][matchTime]
+		oppTimer, ok := d.reminderMap[team][matchTime]
 		if ok {
 			if oppTimer.opponent != tbd {
 				continue
@@ -179,86 +171,84 @@ func CheckMatchesForTeamAndCreateReminderTimers(disc *discordgo.Session, team st
 		var timer *time.Timer
 
 		if time.Now().Before(matchTime.Add(-30 * time.Minute)) {
-			timer = time.AfterFunc(time.Until(matchTime.Add(-30*time.Minute)), ClosureForMatchSend(match, disc))
+			timer = time.AfterFunc(time.Until(matchTime.Add(-30*time.Minute)), d.ClosureForMatchSend(match))
 			if oppTimer.timer != nil {
 				oppTimer.timer.Stop()
 			}
 		}
-		reminderMap[team][matchTime] = OpponentAndTimer{opponent: opponent, timer: timer}
+		d.reminderMap[team][matchTime] = OpponentAndTimer{opponent: opponent, timer: timer}
 	}
 }
 
-func ClosureForMatchSend(match Match, disc *discordgo.Session) func() {
+func (d *Module) ClosureForMatchSend(match Match) func() {
 	return func() {
-		content := fmt.Sprintf(
-			`Dota 2 Tournament Match in 30 minutes: %s
+		d.run(func(ctx context.Context) {
+			content := fmt.Sprintf(
+				`Dota 2 Tournament Match in 30 minutes: %s
 				**%s vs %s**`, *match.LeagueName, *match.Teams[0].Name, *match.Teams[1].Name)
-		_, err := disc.ChannelMessageSend(doNotCookieMeGeneralChannel, content)
-		if err != nil {
-			log.Printf("failed to send discord message %+v \n", err)
-		}
+			_, err := d.session.ChannelMessageSend(d.reminderChannelID, content)
+			if err != nil {
+				d.logger.ErrorContext(ctx, "failed to send match reminder", "channel", d.reminderChannelID, "err", err)
+			}
+		})
 	}
 }
 
 // Handlers
-func HandleDota2Matches(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
+func (d *Module) HandleDota2Matches(ctx context.Context, s session.Session, m *discordgo.MessageCreate) {
 	// for sorting
 	type opponentTime struct {
 		opponent  string
 		matchTime time.Time
 	}
 
-	if strings.Contains(strings.ToLower(m.Message.Content), "!dota2matches") {
-		err := GetAndCacheMatchesAndSetUpReminders(s)
-		if err != nil {
-			log.Println("Failed to refresh matches")
+	err := d.GetAndCacheMatchesAndSetUpReminders(ctx)
+	if err != nil {
+		d.logger.ErrorContext(ctx, "failed to refresh matches", "err", err)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	PacificTime, _ := time.LoadLocation("America/Los_Angeles")
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("Upcoming Dota 2 Promatches for %v \n", trackedTeams))
+
+	numTeams := len(d.reminderMap)
+	teamsWithNoGames := 0
+	for team, matchTimeMap := range d.reminderMap {
+		if len(matchTimeMap) == 0 {
+			teamsWithNoGames++
+			continue
 		}
-		PacificTime, _ := time.LoadLocation("America/Los_Angeles")
-		var content strings.Builder
-		content.WriteString(fmt.Sprintf("Upcoming Dota 2 Promatches for %v \n", trackedTeams))
-
-		numTeams := len(reminderMap)
-		teamsWithNoGames := 0
-		for team, matchTimeMap := range reminderMap {
-			if len(matchTimeMap) == 0 {
-				teamsWithNoGames++
-				continue
-			}
-			content.WriteString("\n")
-			content.WriteString(fmt.Sprintf("**%s** is playing: \n", team))
-			sortable := []opponentTime{}
-			for matchTime, opponent := range matchTimeMap {
-				sortable = append(sortable, opponentTime{
-					opponent:  opponent.opponent,
-					matchTime: matchTime,
-				})
-			}
-
-			// Sort by time
-			sort.Slice(sortable, func(i int, j int) bool {
-				return sortable[i].matchTime.Before(sortable[j].matchTime)
+		content.WriteString("\n")
+		content.WriteString(fmt.Sprintf("**%s** is playing: \n", team))
+		sortable := []opponentTime{}
+		for matchTime, opponent := range matchTimeMap {
+			sortable = append(sortable, opponentTime{
+				opponent:  opponent.opponent,
+				matchTime: matchTime,
 			})
+		}
+
+		// Sort by time
+		sort.Slice(sortable, func(i int, j int) bool {
+			return sortable[i].matchTime.Before(sortable[j].matchTime)
+		})
 
-			for _, oppTime := range sortable {
-				if time.Now().Add(-2*time.Hour).Before(oppTime.matchTime) && time.Now().After(oppTime.matchTime) {
-					content.WriteString(fmt.Sprintf("[**Possibly Live**] against **%s** at %s (%s ago) \n", oppTime.opponent, oppTime.matchTime.In(PacificTime).Format(time.RFC1123), fmtDuration(time.Until(oppTime.matchTime))))
-				}
-				if time.Now().Before(oppTime.matchTime) {
-					content.WriteString(fmt.Sprintf("against **%s** at %s (in %s) \n", oppTime.opponent, oppTime.matchTime.In(PacificTime).Format(time.RFC1123), fmtDuration(time.Until(oppTime.matchTime))))
-				}
+		for _, oppTime := range sortable {
+			if time.Now().Add(-2*time.Hour).Before(oppTime.matchTime) && time.Now().After(oppTime.matchTime) {
+				content.WriteString(fmt.Sprintf("[**Possibly Live**] against **%s** at %s (%s ago) \n", oppTime.opponent, oppTime.matchTime.In(PacificTime).Format(time.RFC1123), fmtDuration(time.Until(oppTime.matchTime))))
+			}
+			if time.Now().Before(oppTime.matchTime) {
+				content.WriteString(fmt.Sprintf("against **%s** at %s (in %s) \n", oppTime.opponent, oppTime.matchTime.In(PacificTime).Format(time.RFC1123), fmtDuration(time.Until(oppTime.matchTime))))
 			}
 		}
-		if teamsWithNoGames == numTeams {
-			content.WriteString("\n")
-			content.WriteString("No games tracked")
-		}
-
-		s.ChannelMessageSend(m.ChannelID, content.String())
 	}
+	if teamsWithNoGames == numTeams {
+		content.WriteString("\n")
+		content.WriteString("No games tracked")
+	}
+
+	s.ChannelMessageSend(m.ChannelID, content.String())
 }
 
 func fmtDuration(d time.Duration) string {
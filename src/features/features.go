@@ -0,0 +1,112 @@
+package features
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"MelvinBot/src/store"
+)
+
+// A Feature is anything that can be switched on or off per guild, a whole module like quotes or a single trigger like nisha.cook
+type Feature struct {
+	Name        string
+	Description string
+	DefaultOn   bool
+}
+
+// GuildFeatures only stores what an admin has explicitly set, everything else falls back to the feature default
+type GuildFeatures struct {
+	Overrides map[string]bool
+}
+
+// Overrides is every guild's GuildFeatures keyed by guild ID, in a store so the bot can keep it in a file. The
+// features they can be set for go along with them but are never saved
+type Overrides struct {
+	*store.Store[map[string]*GuildFeatures]
+	known *registry
+}
+
+// registry is keyed by feature name
+type registry struct {
+	lock   sync.RWMutex
+	byName map[string]Feature
+}
+
+func New() Overrides {
+	return Overrides{store.New(map[string]*GuildFeatures{}), &registry{byName: map[string]Feature{}}}
+}
+
+func (o Overrides) Register(features ...Feature) {
+	o.known.lock.Lock()
+	defer o.known.lock.Unlock()
+	for _, feature := range features {
+		o.known.byName[feature.Name] = feature
+	}
+}
+
+func (o Overrides) Lookup(name string) (Feature, bool) {
+	o.known.lock.RLock()
+	defer o.known.lock.RUnlock()
+	feature, ok := o.known.byName[name]
+	return feature, ok
+}
+
+// All returns every registered feature sorted by name
+func (o Overrides) All() []Feature {
+	o.known.lock.RLock()
+	all := []Feature{}
+	for _, feature := range o.known.byName {
+		all = append(all, feature)
+	}
+	o.known.lock.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// Enabled is always true for the empty feature name, so things that can't be turned off don't need one
+func (o Overrides) Enabled(guildID string, name string) bool {
+	if name == "" {
+		return true
+	}
+
+	feature, ok := o.Lookup(name)
+	if !ok {
+		return false
+	}
+
+	on, ok := false, false
+	o.View(func(guilds map[string]*GuildFeatures) {
+		if guild, found := guilds[guildID]; found {
+			on, ok = guild.Overrides[name]
+		}
+	})
+	if !ok {
+		return feature.DefaultOn
+	}
+	return on
+}
+
+func (o Overrides) Set(guildID string, name string, on bool) error {
+	if _, ok := o.Lookup(name); !ok {
+		return fmt.Errorf("there is no feature called %s", name)
+	}
+
+	return o.Update(func(guilds *map[string]*GuildFeatures) error {
+		if *guilds == nil {
+			*guilds = map[string]*GuildFeatures{}
+		}
+		guild, ok := (*guilds)[guildID]
+		if !ok {
+			guild = &GuildFeatures{}
+			(*guilds)[guildID] = guild
+		}
+		if guild.Overrides == nil {
+			guild.Overrides = map[string]bool{}
+		}
+		guild.Overrides[name] = on
+		return nil
+	})
+}
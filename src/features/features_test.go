@@ -0,0 +1,31 @@
+package features
+
+import "testing"
+
+// Every bot knows only the features registered with it, and can set them while others are registering
+func TestRegisterPerOverrides(t *testing.T) {
+	o, other := New(), New()
+	o.Register(Feature{Name: "quotes", DefaultOn: true})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			other.Register(Feature{Name: "nisha"})
+		}
+	}()
+	if err := o.Set("g", "quotes", false); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if o.Enabled("g", "quotes") || !o.Enabled("h", "quotes") {
+		t.Error("quotes isn't only off in g")
+	}
+	if _, ok := other.Lookup("quotes"); ok {
+		t.Error("a feature registered with one bot showed up in another")
+	}
+	if err := o.Set("g", "nisha", true); err == nil {
+		t.Error("set a feature only the other bot has")
+	}
+}
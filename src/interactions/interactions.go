@@ -0,0 +1,215 @@
+// Package interactions is the HTTP side of Discord slash commands. The discordgo we vendor only knows how to verify
+// signatures, so the wire types, command registration and replies all live here
+package interactions
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"MelvinBot/src/logging"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+var logger = logging.For("interactions")
+
+type InteractionType int
+
+const (
+	InteractionPing               InteractionType = 1
+	InteractionApplicationCommand InteractionType = 2
+)
+
+type ResponseType int
+
+const (
+	ResponsePong                          ResponseType = 1
+	ResponseChannelMessageWithSource      ResponseType = 4
+	ResponseDeferredChannelMessageWithSrc ResponseType = 5
+)
+
+type OptionType int
+
+const (
+	OptionString  OptionType = 3
+	OptionInteger OptionType = 4
+)
+
+// FlagEphemeral makes a reply only visible to whoever ran the command
+const FlagEphemeral = 1 << 6
+
+// maxBody is well past any interaction Discord sends, even one carrying resolved users and attachments
+const maxBody = 1 << 20
+
+type Interaction struct {
+	ID            string          `json:"id"`
+	ApplicationID string          `json:"application_id"`
+	Type          InteractionType `json:"type"`
+	Data          CommandData     `json:"data"`
+	GuildID       string          `json:"guild_id"`
+	ChannelID     string          `json:"channel_id"`
+	Member        *disc.Member    `json:"member"`
+	User          *disc.User      `json:"user"` // Only set in DMs, in guilds the user is on Member
+	Token         string          `json:"token"`
+}
+
+// Invoker is whoever ran the command, wherever they ran it
+func (i *Interaction) Invoker() *disc.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+type CommandData struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name"`
+	Options []CommandOption `json:"options"`
+}
+
+type CommandOption struct {
+	Name  string          `json:"name"`
+	Type  OptionType      `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// String gives every option value as text, which is all the router wants anyway
+func (o CommandOption) String() string {
+	var str string
+	if json.Unmarshal(o.Value, &str) == nil {
+		return str
+	}
+	var num json.Number
+	if json.Unmarshal(o.Value, &num) == nil {
+		return num.String()
+	}
+	return string(o.Value)
+}
+
+// ApplicationCommand is a slash command definition as we register it with Discord
+type ApplicationCommand struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Options     []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+type ApplicationCommandOption struct {
+	Type        OptionType `json:"type"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Required    bool       `json:"required"`
+}
+
+type response struct {
+	Type ResponseType `json:"type"`
+}
+
+// Server verifies and acks every interaction, then hands commands to handle with a Reply to answer through
+type Server struct {
+	publicKey ed25519.PublicKey
+	client    *http.Client
+	run       func(func(ctx context.Context)) bool
+	handle    func(context.Context, *Interaction, *Reply)
+}
+
+// The client is what replies are sent with, usually the bot session's so they share its timeouts.
+// Every command is handled through run, which can refuse it if we're shutting down
+func NewServer(publicKey ed25519.PublicKey, client *http.Client, run func(func(ctx context.Context)) bool, handle func(context.Context, *Interaction, *Reply)) *Server {
+	return &Server{
+		publicKey: publicKey,
+		client:    client,
+		run:       run,
+		handle:    handle,
+	}
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Anyone can post here, so don't read more than an interaction could be before the signature's been checked
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	// Discord sends bad signatures on purpose to check we reject them
+	if !disc.VerifyInteraction(r, srv.publicKey) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction Interaction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		http.Error(w, "could not parse interaction", http.StatusBadRequest)
+		return
+	}
+
+	switch interaction.Type {
+	case InteractionPing:
+		writeResponse(w, response{Type: ResponsePong})
+	case InteractionApplicationCommand:
+		reply := &Reply{client: srv.client, applicationID: interaction.ApplicationID, token: interaction.Token}
+		started := srv.run(func(ctx context.Context) {
+			srv.handle(ctx, &interaction, reply)
+			reply.Finish()
+		})
+		if !started {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		// We only get 3 seconds to answer, so always defer and let the handler fill the reply in
+		writeResponse(w, response{Type: ResponseDeferredChannelMessageWithSrc})
+	default:
+		http.Error(w, fmt.Sprintf("unsupported interaction type %d", interaction.Type), http.StatusBadRequest)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.Error("error writing interaction response", "err", err)
+	}
+}
+
+// RegisterCommands overwrites every slash command we have with Discord. Guild commands show up straight away,
+// global ones (empty guildID) can take up to an hour
+func RegisterCommands(s *disc.Session, applicationID string, guildID string, commands []ApplicationCommand) error {
+	endpoint := disc.EndpointAPI + "applications/" + applicationID + "/commands"
+	if guildID != "" {
+		endpoint = disc.EndpointAPI + "applications/" + applicationID + "/guilds/" + guildID + "/commands"
+	}
+	_, err := s.RequestWithBucketID(http.MethodPut, endpoint, commands, endpoint)
+	if err != nil {
+		return fmt.Errorf("could not register %d slash commands: %w", len(commands), err)
+	}
+	return nil
+}
+
+// ParsePublicKey reads the hex public key from the developer portal
+func ParsePublicKey(hexKey string) (ed25519.PublicKey, error) {
+	if len(hexKey) != ed25519.PublicKeySize*2 {
+		return nil, fmt.Errorf("public key should be %d hex characters, got %d", ed25519.PublicKeySize*2, len(hexKey))
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not hex: %w", err)
+	}
+	return ed25519.PublicKey(key), nil
+}
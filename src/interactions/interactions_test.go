@@ -0,0 +1,54 @@
+package interactions
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping := []byte(`{"type":1}`)
+	tests := []struct {
+		name   string
+		body   []byte
+		signed bool
+		status int
+	}{
+		{name: "signed ping", body: ping, signed: true, status: http.StatusOK},
+		{name: "bad signature", body: ping, status: http.StatusUnauthorized},
+		// Turned away before the signature's checked, so it doesn't matter that it's signed
+		{name: "too big", body: bytes.Repeat([]byte(" "), maxBody+1), signed: true, status: http.StatusRequestEntityTooLarge},
+	}
+
+	srv := NewServer(public, http.DefaultClient, func(func(ctx context.Context)) bool { return false }, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			timestamp := "1700000000"
+			signature := make([]byte, ed25519.SignatureSize)
+			if test.signed {
+				signature = ed25519.Sign(private, append([]byte(timestamp), test.body...))
+			}
+			r.Header.Set("X-Signature-Timestamp", timestamp)
+			r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(signature))
+
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body, test.status)
+			}
+			if test.status == http.StatusOK && !strings.Contains(w.Body.String(), `"type":1`) {
+				t.Errorf("answered a ping with %q", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package interactions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sync"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Reply answers one deferred interaction. The first message fills in the "Melvin is thinking..." placeholder and
+// everything after it is a followup, Discord lets us do both for 15 minutes with the interaction token
+type Reply struct {
+	client        *http.Client
+	applicationID string
+	token         string
+
+	lock sync.Mutex
+	used bool // Whether the placeholder has been filled in or deleted
+}
+
+type webhookMessage struct {
+	Content string               `json:"content"`
+	Embeds  []*disc.MessageEmbed `json:"embeds,omitempty"`
+	Flags   int                  `json:"flags,omitempty"`
+}
+
+// Send posts a message as the reply. Ephemeral messages can't replace the placeholder, so if one comes first the
+// placeholder is deleted and the message goes out as a followup only the invoker can see
+func (r *Reply) Send(data *disc.MessageSend, ephemeral bool) (*disc.Message, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	msg := webhookMessage{Content: data.Content}
+	if data.Embed != nil {
+		msg.Embeds = []*disc.MessageEmbed{data.Embed}
+	}
+	if ephemeral {
+		msg.Flags = FlagEphemeral
+	}
+
+	if !r.used {
+		r.used = true
+		if !ephemeral {
+			return r.request(http.MethodPatch, r.messageEndpoint("@original"), msg, data.Files)
+		}
+		_, err := r.request(http.MethodDelete, r.messageEndpoint("@original"), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.request(http.MethodPost, r.webhookEndpoint()+"?wait=true", msg, data.Files)
+}
+
+// Delete removes a message we sent through this reply
+func (r *Reply) Delete(messageID string) error {
+	_, err := r.request(http.MethodDelete, r.messageEndpoint(messageID), nil, nil)
+	return err
+}
+
+// Finish cleans up the placeholder if the command never said anything, otherwise it would think forever
+func (r *Reply) Finish() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.used {
+		return
+	}
+	r.used = true
+	_, err := r.request(http.MethodDelete, r.messageEndpoint("@original"), nil, nil)
+	if err != nil {
+		logger.Error("could not delete unused interaction placeholder", "err", err)
+	}
+}
+
+func (r *Reply) webhookEndpoint() string {
+	return disc.EndpointWebhookToken(r.applicationID, r.token)
+}
+
+func (r *Reply) messageEndpoint(messageID string) string {
+	return r.webhookEndpoint() + "/messages/" + messageID
+}
+
+// request does its own HTTP as discordgo's webhook helpers can't PATCH @original or send files.
+// Interaction webhooks are authed by the token in the URL so no bot token is needed
+func (r *Reply) request(method, endpoint string, msg any, files []*disc.File) (*disc.Message, error) {
+	var body io.Reader
+	contentType := ""
+	if msg != nil {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			body, contentType = bytes.NewReader(payload), "application/json"
+		} else {
+			body, contentType, err = multipartBody(payload, files)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		// The endpoint has the interaction token in it, so keep it out of the error
+		return nil, fmt.Errorf("interaction %s failed: %s %s", method, resp.Status, respBody)
+	}
+	if resp.StatusCode == http.StatusNoContent || len(respBody) == 0 {
+		return nil, nil
+	}
+
+	var sent disc.Message
+	err = json.Unmarshal(respBody, &sent)
+	if err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// multipartBody is the same layout discordgo uses for ChannelMessageSendComplex with files
+func multipartBody(payload []byte, files []*disc.File) (io.Reader, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="payload_json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = part.Write(payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file%d"; filename="%s"`, i, file.Name))
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.Copy(part, file.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return body, writer.FormDataContentType(), nil
+}
@@ -1,27 +1,34 @@
 package jellyfin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/metrics"
+
 	"github.com/bwmarrin/discordgo"
 )
 
-var JellyfinUpdateChannels = []string{"1203552160177061989", "1203541512542093383"}
+// jellyuserid and jellyapikey are secrets so they come from the env file, everything else is in the config file
 
-// jellyuserid and jellyapikey should be loaded into the .env file in the main routine
+var client = metrics.Client("jellyfin")
 
 type JellyUpdater struct {
+	baseURL        string
 	userID         string
 	apiKey         string
-	discordSession *discordgo.Session
+	channels       []string
+	discordSession session.Session
+	logger         *slog.Logger
 }
 
 type JellyMedia struct {
@@ -39,53 +46,55 @@ type TVEpisodes struct {
 	Series string
 }
 
-func NewJellyUpdater(disc *discordgo.Session) *JellyUpdater {
-	userID := os.Getenv("jellyuserid")
-	apiKey := os.Getenv("jellyapikey")
-
+func NewJellyUpdater(disc session.Session, cfg config.Jellyfin, secrets config.Secrets, logger *slog.Logger) *JellyUpdater {
 	JellyUpdater := &JellyUpdater{
-		userID:         userID,
-		apiKey:         apiKey,
+		baseURL:        strings.TrimSuffix(cfg.URL, "/"),
+		userID:         secrets.JellyfinUserID,
+		apiKey:         secrets.JellyfinAPIKey,
+		channels:       cfg.UpdateChannels,
 		discordSession: disc,
+		logger:         logger,
 	}
 
 	return JellyUpdater
 }
 
-// We absolutely assume jellyfin is running locally at http://localhost:8096/jelly btw
-func (j *JellyUpdater) GetRecentMediaSince(timeSince time.Time) ([]JellyMedia, []TVEpisodes, error) {
-	recentMediaEndpoint := fmt.Sprintf("http://localhost:8096/jelly/Users/%s/Items/Latest?fields=DateLastMediaAdded,DateCreated&enableImages=false&enableUserData=false&limit=100", j.userID)
+func (j *JellyUpdater) Channels() []string {
+	return j.channels
+}
+
+func (j *JellyUpdater) GetRecentMediaSince(ctx context.Context, timeSince time.Time) ([]JellyMedia, []TVEpisodes, error) {
+	recentMediaEndpoint := fmt.Sprintf("%s/Users/%s/Items/Latest?fields=DateLastMediaAdded,DateCreated&enableImages=false&enableUserData=false&limit=100", j.baseURL, j.userID)
 
 	url, err := url.Parse(recentMediaEndpoint)
 	if err != nil {
-		log.Println("failed to parse jellyfin recent media url", err)
+		j.logger.ErrorContext(ctx, "failed to parse jellyfin recent media url", "err", err)
 		return nil, nil, err
 	}
 
 	var AuthorizationHeader []string = []string{fmt.Sprintf("MediaBrowser Client=\"Jellyfin Web\", Device=\"Firefox\", DeviceId=\"abcdefg\", Version=\"10.7.6\", Token=\"%s\"", j.apiKey)}
 
-	client := http.Client{}
 	resp, err := client.Do(
-		&http.Request{
+		(&http.Request{
 			Method: http.MethodGet,
 			URL:    url,
 			Header: http.Header{
 				"Authorization": AuthorizationHeader,
 			},
-		},
+		}).WithContext(ctx),
 	)
 	if err != nil {
-		log.Println("failed to make http req", err)
+		j.logger.ErrorContext(ctx, "failed to make http req", "err", err)
 		return nil, nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		log.Println("failed to make http req, got invalid status code", resp.StatusCode)
+		j.logger.ErrorContext(ctx, "failed to make http req, got invalid status code", "status", resp.StatusCode)
 		return nil, nil, err
 	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("could not read response body", err)
+		j.logger.ErrorContext(ctx, "could not read response body", "err", err)
 		return nil, nil, err
 	}
 
@@ -105,25 +114,25 @@ func (j *JellyUpdater) GetRecentMediaSince(timeSince time.Time) ([]JellyMedia, [
 				movies = append(movies, media)
 			}
 		case "Series":
-			tvshows = append(tvshows, j.GetTVSeriesWithEpisodes(media.Name, media.Id, timeSince))
+			tvshows = append(tvshows, j.GetTVSeriesWithEpisodes(ctx, media.Name, media.Id, timeSince))
 		}
 	}
 
 	return movies, tvshows, nil
 }
 
-func (j *JellyUpdater) SendUpdateMessage(channelID string) {
+func (j *JellyUpdater) SendUpdateMessage(ctx context.Context, channelID string) {
 	if j == nil || j.discordSession == nil {
 		return
 	}
 
-	log.Printf("Posting to channel %s", channelID)
+	j.logger.InfoContext(ctx, "posting recent media", "channel", channelID)
 
 	MovieString := "**Movies**\n"
 	TVString := "**TV Shows**\n"
-	movies, tvshows, err := j.GetRecentMediaSince(time.Now().Add(-1 * 24 * time.Hour)) // Daily
+	movies, tvshows, err := j.GetRecentMediaSince(ctx, time.Now().Add(-1*24*time.Hour)) // Daily
 	if err != nil {
-		log.Println("failed to get recent media")
+		j.logger.ErrorContext(ctx, "failed to get recent media", "err", err)
 		return
 	}
 
@@ -159,48 +168,47 @@ func (j *JellyUpdater) SendUpdateMessage(channelID string) {
 	`, MovieString, TVString)
 	_, err = j.discordSession.ChannelMessageSend(channelID, StringTemplate)
 	if err != nil {
-		log.Println("err sending jellyfin update message", err)
+		j.logger.ErrorContext(ctx, "err sending jellyfin update message", "channel", channelID, "err", err)
 	}
 }
 
-func (j *JellyUpdater) GetTVSeriesWithEpisodes(seriesName string, seriesID string, since time.Time) TVEpisodes {
-	EpisodesEndpoint := fmt.Sprintf("http://localhost:8096/jelly/Shows/%s/Episodes?fields=DateCreated", seriesID)
+func (j *JellyUpdater) GetTVSeriesWithEpisodes(ctx context.Context, seriesName string, seriesID string, since time.Time) TVEpisodes {
+	EpisodesEndpoint := fmt.Sprintf("%s/Shows/%s/Episodes?fields=DateCreated", j.baseURL, seriesID)
 	url, err := url.Parse(EpisodesEndpoint)
 	if err != nil {
-		log.Println("couldnt parse url", EpisodesEndpoint)
+		j.logger.ErrorContext(ctx, "couldnt parse url", "url", EpisodesEndpoint, "err", err)
 		return TVEpisodes{}
 	}
 
 	var AuthorizationHeader []string = []string{fmt.Sprintf("MediaBrowser Client=\"Jellyfin Web\", Device=\"Firefox\", DeviceId=\"abcdefg\", Version=\"10.7.6\", Token=\"%s\"", j.apiKey)}
 
-	client := &http.Client{}
-	resp, err := client.Do(&http.Request{
+	resp, err := client.Do((&http.Request{
 		Method: http.MethodGet,
 		URL:    url,
 		Header: http.Header{
 			"Authorization": AuthorizationHeader,
 		},
-	})
+	}).WithContext(ctx))
 
 	if err != nil {
-		log.Println("err getting episodes", err)
+		j.logger.ErrorContext(ctx, "err getting episodes", "series", seriesID, "err", err)
 		return TVEpisodes{}
 	}
 	if resp.StatusCode != http.StatusOK {
-		log.Println("err with request for episodes of series id", seriesID)
+		j.logger.ErrorContext(ctx, "err with request for episodes", "series", seriesID, "status", resp.StatusCode)
 		return TVEpisodes{}
 	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("err reading resp when grabbing episodes", err)
+		j.logger.ErrorContext(ctx, "err reading resp when grabbing episodes", "series", seriesID, "err", err)
 		return TVEpisodes{}
 	}
 	seriesAndEpisodes := TVEpisodes{Series: seriesName}
 
 	err = json.Unmarshal(b, &seriesAndEpisodes)
 	if err != nil {
-		log.Println("err unmarshaling episodes", err)
+		j.logger.ErrorContext(ctx, "err unmarshaling episodes", "series", seriesID, "err", err)
 		return TVEpisodes{}
 	}
 
@@ -216,18 +224,10 @@ func (j *JellyUpdater) GetTVSeriesWithEpisodes(seriesName string, seriesID strin
 	return seriesAndEpisodes
 }
 
-func (j *JellyUpdater) RecentHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
-	if strings.ToLower(m.Content) != "!jellyfinrecent" {
-		return
-	}
-
+func (j *JellyUpdater) RecentHandler(ctx context.Context, s session.Session, m *discordgo.MessageCreate) {
 	// Only certain channels can invoke this command
 	keepGoing := false
-	for _, allowedChannel := range JellyfinUpdateChannels {
+	for _, allowedChannel := range j.channels {
 		if m.ChannelID == allowedChannel {
 			keepGoing = true
 		}
@@ -236,10 +236,5 @@ func (j *JellyUpdater) RecentHandler(s *discordgo.Session, m *discordgo.MessageC
 		return
 	}
 
-	j.SendUpdateMessage(m.ChannelID)
-}
-
-// Im not smart enough to understand why we need this closure
-func (j *JellyUpdater) SendUpdateMessageToChannel(channelID string) func() {
-	return func() { j.SendUpdateMessage(channelID) }
+	j.SendUpdateMessage(ctx, m.ChannelID)
 }
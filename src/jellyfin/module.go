@@ -0,0 +1,57 @@
+package jellyfin
+
+import (
+	"context"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Module posts what was added to Jellyfin every day and answers !jellyfinrecent
+type Module struct {
+	module.Base
+	updater *JellyUpdater
+}
+
+func (*Module) Name() string {
+	return "jellyfin"
+}
+
+func (j *Module) Init(deps module.Deps) error {
+	j.updater = NewJellyUpdater(deps.Session, deps.Config.Jellyfin, deps.Config.Secrets, deps.Logger)
+	return nil
+}
+
+func (j *Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "jellyfinrecent",
+			Description: "Lists what was added to Jellyfin in the last day",
+			Feature:     "jellyfin",
+			Slash:       true,
+			Run: func(ctx context.Context, s session.Session, m *discordgo.MessageCreate, args module.Args) {
+				j.updater.RecentHandler(ctx, s, m)
+			},
+		},
+	}
+}
+
+// ScheduledJobs posts what's new to every channel at 4AM UTC
+func (j *Module) ScheduledJobs() []module.Job {
+	channels := j.updater.Channels()
+	if len(channels) == 0 {
+		return nil
+	}
+	return []module.Job{{
+		Name:     "jellyfin update",
+		Spec:     "0 0 4 * * *",
+		Timezone: "UTC",
+		Run: func(ctx context.Context) {
+			for _, channel := range channels {
+				j.updater.SendUpdateMessage(ctx, channel)
+			}
+		},
+	}}
+}
@@ -0,0 +1,94 @@
+// Package lifecycle tracks everything the bot has running so shutdown can wait for it instead of cutting it off
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lifecycle has two kinds of goroutine. Work is a handler or a job that finishes on its own, and shutdown gives it
+// until the deadline before cancelling its context. Services loop forever, like storage syncing, and are cancelled
+// as soon as shutdown starts
+type Lifecycle struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	services       context.Context
+	cancelServices context.CancelFunc
+
+	lock     sync.Mutex
+	stopping bool
+	work     sync.WaitGroup
+	running  sync.WaitGroup // services
+}
+
+func New() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	services, cancelServices := context.WithCancel(ctx)
+	return &Lifecycle{
+		ctx:            ctx,
+		cancel:         cancel,
+		services:       services,
+		cancelServices: cancelServices,
+	}
+}
+
+// Context is cancelled once shutdown gives up waiting on work
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Go runs f as tracked work. Once shutdown has started nothing new is run and Go returns false
+func (l *Lifecycle) Go(f func(ctx context.Context)) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.stopping {
+		return false
+	}
+	l.work.Add(1)
+	go func() {
+		defer l.work.Done()
+		f(l.ctx)
+	}()
+	return true
+}
+
+// Service runs f until shutdown starts, f should return soon after its context is done
+func (l *Lifecycle) Service(f func(ctx context.Context)) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.stopping {
+		return false
+	}
+	l.running.Add(1)
+	go func() {
+		defer l.running.Done()
+		f(l.services)
+	}()
+	return true
+}
+
+// Shutdown stops taking new work, stops services and waits for in flight work until the deadline.
+// Anything still running after that has its context cancelled and is left behind
+func (l *Lifecycle) Shutdown(deadline time.Duration) error {
+	l.lock.Lock()
+	l.stopping = true
+	l.lock.Unlock()
+	l.cancelServices()
+
+	done := make(chan struct{})
+	go func() {
+		l.work.Wait()
+		l.running.Wait()
+		close(done)
+	}()
+
+	defer l.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(deadline):
+		return fmt.Errorf("gave up waiting for handlers after %s", deadline)
+	}
+}
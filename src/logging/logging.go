@@ -0,0 +1,161 @@
+// Package logging is the bot's one slog setup. Each package logs through its own logger from For, and anything
+// handling an event puts the guild, channel and so on into its context with With so every line it logs carries them
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"MelvinBot/src/config"
+)
+
+var (
+	level = &slog.LevelVar{}
+
+	lock sync.RWMutex
+	base slog.Handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	file *rotatingFile
+)
+
+// Until Setup runs everything logs as text to stdout, including anything still using the log package
+func init() {
+	slog.SetDefault(slog.New(&handler{}))
+}
+
+// Setup points every logger at cfg's format and file. Loggers made with For before now pick this up too
+func Setup(cfg config.Logging) error {
+	err := SetLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	var rotating *rotatingFile
+	if cfg.File != "" {
+		rotating, err = openRotating(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.Keep)
+		if err != nil {
+			return err
+		}
+		out = io.MultiWriter(os.Stdout, rotating)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(out, options)
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(out, options)
+	}
+
+	lock.Lock()
+	old := file
+	base, file = h, rotating
+	lock.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close closes the log file, anything logged after this only goes to stdout
+func Close() error {
+	lock.Lock()
+	old := file
+	base, file = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}), nil
+	lock.Unlock()
+	if old == nil {
+		return nil
+	}
+	return old.Close()
+}
+
+// SetLevel takes debug, info, warn or error and applies straight away everywhere
+func SetLevel(name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("%q is not a log level, try debug, info, warn or error", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+// For is the logger for a module, every line it logs is tagged with module=name
+func For(module string) *slog.Logger {
+	return slog.New(&handler{}).With("module", module)
+}
+
+type contextKey struct{}
+
+// With adds fields like guild or command to everything logged with ctx, on top of any it already has
+func With(ctx context.Context, args ...any) context.Context {
+	var r slog.Record
+	r.Add(args...)
+	attrs := append([]slog.Attr{}, fields(ctx)...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+func fields(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return attrs
+}
+
+// handler hands every record to whatever base is when it's logged, so Setup can swap base out from under
+// loggers that already exist. ops are the With and WithGroup calls made on it, replayed onto base in order
+type handler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) current() slog.Handler {
+	lock.RLock()
+	current := base
+	lock.RUnlock()
+	for _, op := range h.ops {
+		current = op(current)
+	}
+	return current
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := fields(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.current().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := append([]func(slog.Handler) slog.Handler{}, h.ops...)
+	return &handler{ops: append(ops, op)}
+}
+
+// Fatal is log.Fatal for a module logger, for the few places the bot can't carry on from
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	Close()
+	os.Exit(1)
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// rotatingFile appends to path until the next write would take it past maxSize, then moves it to path.1,
+// path.1 to path.2 and so on, dropping whatever would end up past path.keep
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotating(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// slog writes each record in one call, so a record never gets split across two files
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return 0, fs.ErrClosed
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			// Keep writing to the big file rather than lose logs
+			fmt.Fprintf(os.Stderr, "could not rotate %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if f.keep == 0 {
+		err = os.Remove(f.path)
+	} else {
+		for i := f.keep - 1; i >= 1; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			err = nil
+		}
+		if err == nil {
+			err = os.Rename(f.path, f.path+".1")
+		}
+	}
+
+	// Whatever happened we need somewhere to write
+	openErr := f.open()
+	if openErr != nil {
+		f.file = nil
+		return openErr
+	}
+	return err
+}
+
+func (f *rotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
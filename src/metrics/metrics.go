@@ -0,0 +1,230 @@
+// Package metrics is just enough of Prometheus to count what the bot does. Each package declares its own metrics
+// as package vars and Handler serves all of them in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are in seconds, from a quick in memory command up to a slow upstream
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type family interface {
+	write(w io.Writer)
+}
+
+var (
+	lock     sync.Mutex
+	families = []family{}
+	names    = map[string]bool{}
+)
+
+func register(name string, f family) {
+	lock.Lock()
+	defer lock.Unlock()
+	if names[name] {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	names[name] = true
+	families = append(families, f)
+}
+
+// Handler serves every metric in the order they were declared
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		lock.Lock()
+		all := append([]family{}, families...)
+		lock.Unlock()
+		for _, f := range all {
+			f.write(w)
+		}
+	})
+}
+
+// vec is what every kind of metric has in common, one value per distinct set of label values
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]*T // Keyed by the label values joined with a byte that can't be in one
+	init   func() *T
+}
+
+func (v *vec[T]) get(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	value, ok := v.values[key]
+	if !ok {
+		value = v.init()
+		v.values[key] = value
+	}
+	return value
+}
+
+// each goes through the values in a stable order so scrapes are easy to diff
+func (v *vec[T]) each(w io.Writer, f func(labels string, value *T)) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labels []string
+		if len(v.labels) > 0 {
+			labels = strings.Split(key, "\xff")
+		}
+		f(formatLabels(v.labels, labels), v.values[key])
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf("%s=%q", name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds one more label onto an already formatted set, for histogram buckets
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf("%s=%q", name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + pair + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter only goes up, like how many times a command has run
+type Counter struct {
+	vec[float64]
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec[float64]{name: name, help: help, kind: "counter", labels: labels, values: map[string]*float64{}, init: func() *float64 { return new(float64) }}}
+	register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(n float64, labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	*c.get(labelValues) += n
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.each(w, func(labels string, value *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatFloat(*value))
+	})
+}
+
+// Gauge is set to whatever it currently is, like when something last happened
+type Gauge struct {
+	vec[float64]
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec[float64]{name: name, help: help, kind: "gauge", labels: labels, values: map[string]*float64{}, init: func() *float64 { return new(float64) }}}
+	register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	*g.get(labelValues) = value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.each(w, func(labels string, value *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatFloat(*value))
+	})
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative, they're added up when written
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations into buckets, it's how we keep latencies
+type Histogram struct {
+	vec[histogram]
+	buckets []float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{buckets: buckets}
+	h.vec = vec[histogram]{name: name, help: help, kind: "histogram", labels: labels, values: map[string]*histogram{}, init: func() *histogram {
+		return &histogram{counts: make([]uint64, len(buckets))}
+	}}
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist := h.get(labelValues)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.sum += value
+	hist.count++
+}
+
+// Since observes how many seconds it's been since start
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.each(w, func(labels string, hist *histogram) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+	})
+}
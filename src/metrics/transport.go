@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	upstreamSeconds = NewHistogram("melvin_upstream_request_seconds", "How long requests to the APIs we depend on took, failed ones included.", DefaultBuckets, "upstream")
+	upstreamErrors  = NewCounter("melvin_upstream_errors_total", "Requests to the APIs we depend on that failed, by status or error if there was no response.", "upstream", "status")
+)
+
+// Transport times every request that goes through next under upstream, and counts any that fail
+func Transport(upstream string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper{upstream, next}
+}
+
+type roundTripper struct {
+	upstream string
+	next     http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	upstreamSeconds.Since(start, rt.upstream)
+	switch {
+	case err != nil:
+		upstreamErrors.Inc(rt.upstream, "error")
+	case resp.StatusCode >= 400:
+		upstreamErrors.Inc(rt.upstream, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// Client is an http.Client whose requests are all counted under upstream
+func Client(upstream string) *http.Client {
+	return &http.Client{Transport: Transport(upstream, nil)}
+}
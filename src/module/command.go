@@ -0,0 +1,119 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+const Prefix = "!"
+
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota // a single word
+	ArgInt                   // a single word that must parse as a number
+	ArgRest                  // everything left on the line, must be the last arg
+)
+
+type Arg struct {
+	Name        string
+	Kind        ArgKind
+	Optional    bool
+	Description string // Only shown in the slash command picker
+}
+
+// Args holds the parsed arguments of a command invocation, keyed by Arg.Name
+type Args map[string]string
+
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a Args) String(name string) string {
+	return a[name]
+}
+
+// Int is only safe to call on ArgInt args, the router has already validated them
+func (a Args) Int(name string) int {
+	i, _ := strconv.Atoi(a[name])
+	return i
+}
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Args        []Arg
+	Description string
+	Feature     string // If empty the command can't be turned off
+	// Who can run it until an admin changes that with !perm, anyone with one of these Discord permissions. Zero is everyone
+	Permission int64
+	Slash      bool // Also register it as a /command, its name and arg names must be lowercase with no spaces
+	// DM lets it be run in DMs too, against a guild the user shares with us picked with --guild. m.GuildID is that
+	// guild when it runs, so it should only read from it and reply to m.ChannelID
+	DM bool
+	// DMOnly is for things people shouldn't have to do in front of everyone, it picks a guild like DM
+	DMOnly bool
+	Run    func(ctx context.Context, s session.Session, m *disc.MessageCreate, args Args)
+}
+
+// Usage renders the command like !quote [query...]
+func (c *Command) Usage() string {
+	var usage strings.Builder
+	usage.WriteString(Prefix + c.Name)
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Kind == ArgRest {
+			name += "..."
+		}
+		if arg.Optional {
+			usage.WriteString(fmt.Sprintf(" [%s]", name))
+		} else {
+			usage.WriteString(fmt.Sprintf(" <%s>", name))
+		}
+	}
+	return usage.String()
+}
+
+// ParseArgs splits everything after the command name into its args
+func (c *Command) ParseArgs(input string) (Args, error) {
+	args := Args{}
+	for i, arg := range c.Args {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			if arg.Optional {
+				return args, nil
+			}
+			return nil, fmt.Errorf("missing argument %s", arg.Name)
+		}
+
+		if arg.Kind == ArgRest {
+			if i != len(c.Args)-1 {
+				return nil, fmt.Errorf("argument %s takes the rest of the line so it must be last", arg.Name)
+			}
+			args[arg.Name] = input
+			return args, nil
+		}
+
+		var token string
+		token, input = util.CutWord(input)
+		if arg.Kind == ArgInt {
+			if _, err := strconv.Atoi(token); err != nil {
+				return nil, fmt.Errorf("%s must be a number, got %q", arg.Name, token)
+			}
+		}
+		args[arg.Name] = token
+	}
+
+	if strings.TrimSpace(input) != "" {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	return args, nil
+}
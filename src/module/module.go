@@ -0,0 +1,86 @@
+// Package module is how a feature plugs into the bot. A module hands over its commands, gateway handlers and
+// scheduled jobs, and the bot runs them gated on features, permissions and cooldowns like everything else
+package module
+
+import (
+	"context"
+	"log/slog"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/store"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Module is one feature of the bot. Commands and Handlers are also asked for without Init to check the config,
+// so they can't depend on anything Init sets up until they're actually run. They're asked for again after Init
+// whenever they will be
+type Module interface {
+	// Name is used for its logger and error reports
+	Name() string
+	// Init is called once before the bot connects, an error stops the bot from starting
+	Init(deps Deps) error
+	Handlers() Handlers
+	// ScheduledJobs is called after Init
+	ScheduledJobs() []Job
+	Commands() []*Command
+	// Shutdown is called once nothing new is being started, before the bot waits for work that's still running
+	Shutdown()
+}
+
+// Deps is everything the bot gives a module in Init
+type Deps struct {
+	Config *config.Config
+	// Session isn't from any event, it's for jobs and anything else the module starts itself
+	Session session.Session
+	Logger  *slog.Logger
+	// Go runs f as tracked work under name, so shutdown waits for it and a panic is reported. It returns false
+	// once the bot is shutting down, and during a replay where nothing runs on its own
+	Go func(name string, f func(ctx context.Context)) bool
+	// Storage keeps data in file. The bot loads it before connecting, syncs it and saves it on shutdown
+	Storage func(name string, data store.Persistent, file string) (store.Storage, error)
+	// Allowed checks whoever sent m can do action against the bot's permissions, telling them they aren't allowed to
+	// do what if not
+	Allowed func(s session.Session, m *disc.MessageCreate, action string, what string) bool
+	// Deletions is the bot's queue of messages to delete, for util.SendSelfDestructingMessage
+	Deletions selfdestruct.Queue
+}
+
+// Handlers are gateway event handlers, each one only runs in guilds where its feature is enabled
+type Handlers struct {
+	Message        []Handler[*disc.MessageCreate]
+	MessageUpdate  []Handler[*disc.MessageUpdate] // Only real edits, not embeds loading in
+	MessageDelete  []Handler[*disc.MessageDelete] // Only the IDs are set, Discord doesn't say what was deleted
+	ReactionAdd    []Handler[*disc.MessageReactionAdd]
+	ReactionRemove []Handler[*disc.MessageReactionRemove]
+}
+
+type Handler[T any] struct {
+	Feature string // If empty it always runs
+	Run     func(ctx context.Context, s session.Session, event T)
+}
+
+// Job runs on a cron schedule with seconds, like "0 0 8 * * *" for 8AM every day in Timezone
+type Job struct {
+	// Name has to be unique across every module, it's how admins pick the job in !schedule
+	Name string
+	Spec string
+	// Timezone is an IANA name like America/Los_Angeles, there's no default so nobody gets the server's by accident
+	Timezone string
+	Run      func(ctx context.Context)
+}
+
+// Base does nothing, embed it to only write the parts a module needs
+type Base struct{}
+
+func (Base) Init(deps Deps) error { return nil }
+
+func (Base) Handlers() Handlers { return Handlers{} }
+
+func (Base) ScheduledJobs() []Job { return nil }
+
+func (Base) Commands() []*Command { return nil }
+
+func (Base) Shutdown() {}
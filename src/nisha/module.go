@@ -0,0 +1,61 @@
+package nisha
+
+import (
+	"context"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Module is everything for the wolfcord server, all of it off unless a guild turns it on
+type Module struct {
+	module.Base
+}
+
+func (*Module) Name() string {
+	return "nisha"
+}
+
+func (*Module) Handlers() module.Handlers {
+	return module.Handlers{
+		Message: []module.Handler[*disc.MessageCreate]{
+			{Feature: "nisha.sex", Run: DidSomebodySaySex},
+			{Feature: "nisha.tetazoo", Run: Tetazoo},
+			{Feature: "nisha.glounge", Run: Glounge},
+			{Feature: "nisha.cook", Run: Lethimcook},
+			{Feature: "nisha.miami", Run: Miami},
+			{Feature: "nisha.killdamian", Run: KillDamian},
+		},
+	}
+}
+
+func (*Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "iiwii",
+			Description: "It is what it is",
+			Feature:     "iiwii",
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				Iiwii(ctx, s, m)
+			},
+		},
+		{
+			Name:        "stop",
+			Description: "This is NOT a DVD",
+			Feature:     "nisha.stop",
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				ThisIsNotADvd(ctx, s, m)
+			},
+		},
+		{
+			Name:        "rsbs",
+			Description: "George Carlin",
+			Feature:     "nisha.rsbs",
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				GeorgeCarlin(ctx, s, m)
+			},
+		},
+	}
+}
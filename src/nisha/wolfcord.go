@@ -1,124 +1,76 @@
 package nisha
 
 import (
-	"MelvinBot/src/util"
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
+	"MelvinBot/src/discord/session"
+
 	disc "github.com/bwmarrin/discordgo"
 )
 
-func DidSomebodySaySex(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func DidSomebodySaySex(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	if strings.Contains(strings.ToLower(m.Message.Content), "sex") {
 		s.ChannelMessageSend(m.ChannelID, "did somebody say sex???")
 	}
 }
 
-func ThisIsNotADvd(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
-	if m.Content != "!stop" {
-		return
-	}
+// Commands, the router takes care of matching these and only running them where they are enabled
 
+func ThisIsNotADvd(ctx context.Context, s session.Session, m *disc.MessageCreate) {
 	s.ChannelMessageSend(m.ChannelID, "STOP! STOP! STOP! This is NOT a DVD. This is NOT A DVD. THIS IS NOT A DVD. This is a BACKER CARD. It's a CARD for COLLECTORS. This is a MOVIE CARD. THIS IS NOT A DVD. STOP! READ. READ THE DESCRIPTION.")
 
 }
 
-func GeorgeCarlin(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
-	if m.Content != "!rsbs" {
-		return
-	}
-
+func GeorgeCarlin(ctx context.Context, s session.Session, m *disc.MessageCreate) {
 	s.ChannelMessageSend(m.ChannelID, "RATSHIT BATSHIT DIRTY OLD TWAT 69 ASSHOLES TIED IN A KNOT HOORAY LIZARD SHIT FUCK")
 }
 
-func Tetazoo(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func Tetazoo(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	if strings.Contains(strings.ToLower(m.Message.Content), "tetazoo") {
 		s.ChannelMessageSend(m.ChannelID, "TETAZOO IS NOT A HIVEMIND")
 	}
 }
 
-func Glounge(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func Glounge(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	if strings.Contains(strings.ToLower(m.Message.Content), "where are you") {
 		s.ChannelMessageSend(m.ChannelID, "update tetazoo glounge")
 	}
 }
 
-func Iiwii(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
-	if m.Content != "!iiwii" {
-		return
-	}
-
+func Iiwii(ctx context.Context, s session.Session, m *disc.MessageCreate) {
 	s.ChannelMessageSend(m.ChannelID, "it EEEEEEES what it eees")
 }
 
-func Lethimcook(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func Lethimcook(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	if strings.Contains(strings.ToLower(m.Message.Content), "cook") {
 		s.ChannelMessageSend(m.ChannelID, "https://i.kym-cdn.com/entries/icons/original/000/041/943/1aa1blank.png")
 	}
 }
 
-func Miami(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func Miami(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	if strings.Contains(strings.ToLower(m.Message.Content), "miami") || strings.Contains(strings.ToLower(m.Message.Content), "spring break") {
 		year := []rune(fmt.Sprint(time.Now().Year()))
 		year[1] = 'k'
@@ -126,15 +78,11 @@ func Miami(s *disc.Session, m *disc.MessageCreate) {
 	}
 }
 
-func KillDamian(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+func KillDamian(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.GuildID != util.Wolfcord_GuildID {
-		return // only for nisha's discord
-	}
-
 	// if damian replies to erik specifically
 	if m.Message.Reference() != nil {
 		referencedMsg, err := s.ChannelMessage(m.ChannelID, m.Message.Reference().MessageID)
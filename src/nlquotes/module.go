@@ -0,0 +1,44 @@
+package nlquotes
+
+import (
+	"context"
+	"log/slog"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+	"MelvinBot/src/selfdestruct"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Module is !nlquote, backed by nlquotes.com
+type Module struct {
+	module.Base
+	deletions selfdestruct.Queue
+	logger    *slog.Logger
+}
+
+func (*Module) Name() string {
+	return "nlquotes"
+}
+
+func (nl *Module) Init(deps module.Deps) error {
+	nl.deletions = deps.Deletions
+	nl.logger = deps.Logger
+	return nil
+}
+
+func (nl *Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "nlquote",
+			Args:        []module.Arg{{Name: "search", Kind: module.ArgRest, Optional: true, Description: "Only quotes matching this"}},
+			Description: "Sends a random Northernlion quote from nlquotes.com, optionally matching a search",
+			Feature:     "nlquotes",
+			Slash:       true,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				nl.HandleNLQuote(ctx, s, m, args.String("search"))
+			},
+		},
+	}
+}
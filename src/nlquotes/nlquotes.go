@@ -1,7 +1,10 @@
 package nlquotes
 
 import (
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/metrics"
 	"MelvinBot/src/util"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -19,6 +22,8 @@ const (
 	EntriesPerPage int = 10
 )
 
+var client = metrics.Client("nlquotes")
+
 type NLQuote struct {
 	Text           string `json:"text"`
 	TimestampStart string `json:"timestamp_start"`
@@ -43,8 +48,8 @@ func convertBoldToMarkdown(input string) string {
 	})
 }
 
-func queryNLAPI(endpoint string, params map[string]string, response any) error {
-	request, err := http.NewRequest("GET", fmt.Sprintf("https://nlquotes.com/api/%s", endpoint), nil)
+func queryNLAPI(ctx context.Context, endpoint string, params map[string]string, response any) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://nlquotes.com/api/%s", endpoint), nil)
 	if err != nil {
 		return fmt.Errorf("Failed to parse URL")
 	}
@@ -55,7 +60,7 @@ func queryNLAPI(endpoint string, params map[string]string, response any) error {
 	}
 	request.URL.RawQuery = query.Encode()
 
-	httpResponse, err := http.DefaultClient.Do(request)
+	httpResponse, err := client.Do(request)
 	if err != nil {
 		return fmt.Errorf("failed to fetch from API: %w", err)
 	}
@@ -121,7 +126,7 @@ func formatRandomNLQuote(entry NLEntry) (string, error) {
 	return message, nil
 }
 
-func FetchNLQuote(search string) (string, error) {
+func FetchNLQuote(ctx context.Context, search string) (string, error) {
 	// So the API returns a series of entries, each of which can have multiple
 	// quotes. 10 entries are returned per page.
 	//
@@ -157,7 +162,7 @@ func FetchNLQuote(search string) (string, error) {
 		"game":         "all",
 	}
 
-	err := queryNLAPI("", headers, &apiResp)
+	err := queryNLAPI(ctx, "", headers, &apiResp)
 
 	if err != nil {
 		return "", err
@@ -169,7 +174,7 @@ func FetchNLQuote(search string) (string, error) {
 		page := entryIndex/EntriesPerPage + 1
 		entryIndex = entryIndex % EntriesPerPage
 		headers["page"] = strconv.Itoa(page)
-		err = queryNLAPI("", headers, &apiResp)
+		err = queryNLAPI(ctx, "", headers, &apiResp)
 
 		if err != nil {
 			return "", err
@@ -183,13 +188,13 @@ func FetchNLQuote(search string) (string, error) {
 	return formatRandomNLQuote(apiResp.Data[entryIndex])
 }
 
-func RandomNLQuote() (string, error) {
+func RandomNLQuote(ctx context.Context) (string, error) {
 	// The API returns a top-level "quotes" array
 	var apiResp struct {
 		Quotes []NLEntry `json:"quotes"`
 	}
 
-	err := queryNLAPI("random", map[string]string{}, &apiResp)
+	err := queryNLAPI(ctx, "random", map[string]string{}, &apiResp)
 
 	if err != nil {
 		return "", err
@@ -202,39 +207,34 @@ func RandomNLQuote() (string, error) {
 	return formatRandomNLEntry(apiResp.Quotes)
 }
 
-func HandleNLQuote(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-	if !strings.HasPrefix(m.Content, "!nlquote") {
-		return
-	}
-
-	// Remove the command prefix and trim whitespace
-	searchTerm := strings.TrimSpace(strings.TrimPrefix(m.Content, "!nlquote"))
-
+func (nl *Module) HandleNLQuote(ctx context.Context, s session.Session, m *disc.MessageCreate, searchTerm string) {
 	var quote string
 	var err error
 
 	if searchTerm == "" {
 		// Case 1: No search term, fetch a completely random quote
-		quote, err = RandomNLQuote()
+		quote, err = RandomNLQuote(ctx)
 		if err != nil {
-			util.SendSelfDestructingMessage(s, m.ChannelID, "couldn't pull a random quote sorry, maybe the API is down?", 5*time.Second)
+			nl.logger.ErrorContext(ctx, "failed to get a random quote", "err", err)
+			util.SendSelfDestructingMessage(s, nl.deletions, m.ChannelID, "couldn't pull a random quote sorry, maybe the API is down?", 5*time.Second)
 			return
 		}
 	} else {
 		// Case 2: Search term provided, fetch matching quotes
-		quote, err = FetchNLQuote(searchTerm)
+		quote, err = FetchNLQuote(ctx, searchTerm)
 		if quote == "" && err == nil { // special case where the response array had length 0
 			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("shockingly NL has never said '%s'", searchTerm))
 		}
 		if err != nil {
-			util.SendSelfDestructingMessage(s, m.ChannelID, "sorry got an error trying that", 5*time.Second)
+			nl.logger.ErrorContext(ctx, "failed to search quotes", "search", searchTerm, "err", err)
+			util.SendSelfDestructingMessage(s, nl.deletions, m.ChannelID, "sorry got an error trying that", 5*time.Second)
 			return
 		}
 	}
 
 	// Send the quote to the channel
-	s.ChannelMessageSend(m.ChannelID, quote)
+	_, err = s.ChannelMessageSend(m.ChannelID, quote)
+	if err != nil {
+		nl.logger.ErrorContext(ctx, "failed to send quote", "err", err)
+	}
 }
@@ -0,0 +1,241 @@
+// Package outbox is how everything the bot says gets to Discord. Sends to a channel go out one at a time in order,
+// anything too long is split up or attached as a file, and errors that might go away are retried.
+// discordgo already waits out 429s inside its rate limit buckets, so this only has to keep each channel in order
+package outbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+var logger = logging.For("outbox")
+
+var sends = metrics.NewCounter("melvin_outbox_sends_total", "Attempts to send a message, by whether it went, is being retried or failed for good.", "outcome")
+
+const (
+	// MaxLength is the most Discord takes in one message. We count bytes, which is never less than what Discord counts
+	MaxLength = 2000
+	// Anything that would take more messages than this goes as a file instead
+	maxChunks = 5
+	// How many times a send is tried before giving up, waiting firstBackoff then twice as long each time
+	attempts     = 4
+	firstBackoff = 500 * time.Millisecond
+	fence        = "```"
+)
+
+type Outbox struct {
+	session.Session
+	// Sleep waits between retries, it's time.Sleep unless something can't wait
+	Sleep func(d time.Duration)
+
+	failed   func(channelID string, err error)
+	lock     sync.Mutex
+	channels map[string]*sync.Mutex // Waiting on a channel's lock is its queue
+}
+
+// New sends through s, failed hears about anything that couldn't be sent even after retrying and can be nil
+func New(s session.Session, failed func(channelID string, err error)) *Outbox {
+	return &Outbox{
+		Session:  s,
+		Sleep:    time.Sleep,
+		failed:   failed,
+		channels: map[string]*sync.Mutex{},
+	}
+}
+
+func (o *Outbox) channel(channelID string) *sync.Mutex {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	lock, ok := o.channels[channelID]
+	if !ok {
+		lock = &sync.Mutex{}
+		o.channels[channelID] = lock
+	}
+	return lock
+}
+
+func (o *Outbox) ChannelMessageSend(channelID string, content string) (*disc.Message, error) {
+	return o.ChannelMessageSendComplex(channelID, &disc.MessageSend{Content: content})
+}
+
+func (o *Outbox) ChannelFileSend(channelID, name string, r io.Reader) (*disc.Message, error) {
+	return o.ChannelMessageSendComplex(channelID, &disc.MessageSend{Files: []*disc.File{{Name: name, Reader: r}}})
+}
+
+// ChannelMessageSendComplex returns the first message when content had to be split, ChannelMessageSendSplit has the rest
+func (o *Outbox) ChannelMessageSendComplex(channelID string, data *disc.MessageSend) (*disc.Message, error) {
+	sent, err := o.ChannelMessageSendSplit(channelID, data)
+	if len(sent) == 0 {
+		return nil, err
+	}
+	return sent[0], err
+}
+
+// ChannelMessageSendSplit is every message content was split into, files and embeds go on the last
+func (o *Outbox) ChannelMessageSendSplit(channelID string, data *disc.MessageSend) ([]*disc.Message, error) {
+	// Files are read up front so a retry can send them again
+	type file struct {
+		name, contentType string
+		data              []byte
+	}
+	// A new slice, appending data.File to data.Files could write into the caller's array
+	sources := slices.Clone(data.Files)
+	if data.File != nil {
+		sources = append(sources, data.File)
+	}
+	files := []file{}
+	for _, f := range sources {
+		if f == nil {
+			continue
+		}
+		b, err := io.ReadAll(f.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s to send it: %w", f.Name, err)
+		}
+		files = append(files, file{f.Name, f.ContentType, b})
+	}
+
+	chunks := split(data.Content, MaxLength)
+	if len(chunks) > maxChunks {
+		files = append(files, file{"message.txt", "text/plain", []byte(data.Content)})
+		chunks = []string{"That's too long for messages, so here it is as a file"}
+	}
+
+	lock := o.channel(channelID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	all := []*disc.Message{}
+	for i, chunk := range chunks {
+		last := i == len(chunks)-1
+		sent, err := o.attempt(channelID, func() (*disc.Message, error) {
+			msg := &disc.MessageSend{Content: chunk, AllowedMentions: data.AllowedMentions}
+			if i == 0 {
+				msg.Reference = data.Reference
+			}
+			if last {
+				msg.Embed = data.Embed
+				msg.TTS = data.TTS
+				for _, f := range files {
+					msg.Files = append(msg.Files, &disc.File{Name: f.name, ContentType: f.contentType, Reader: bytes.NewReader(f.data)})
+				}
+			}
+			return o.Session.ChannelMessageSendComplex(channelID, msg)
+		})
+		if err != nil {
+			return all, err
+		}
+		all = append(all, sent)
+	}
+	return all, nil
+}
+
+// attempt keeps sending until it works, fails for good or runs out of attempts
+func (o *Outbox) attempt(channelID string, send func() (*disc.Message, error)) (*disc.Message, error) {
+	backoff := firstBackoff
+	for try := 1; ; try++ {
+		msg, err := send()
+		if err == nil {
+			sends.Inc("sent")
+			return msg, nil
+		}
+		if try == attempts || !retryable(err) {
+			sends.Inc("failed")
+			logger.Error("could not send message", "channel", channelID, "attempts", try, "err", err)
+			if o.failed != nil {
+				o.failed(channelID, err)
+			}
+			return nil, err
+		}
+
+		sends.Inc("retried")
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		logger.Warn("send failed, retrying", "channel", channelID, "attempt", try, "in", wait, "err", err)
+		o.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// retryable is for errors on Discord's end or on the way there, anything else will fail the same way next time. A
+// network error only counts if we never reached Discord, once the request is written it might have been posted and
+// sending it again would post it twice
+func retryable(err error) bool {
+	var rest *disc.RESTError
+	if errors.As(err, &rest) {
+		return rest.Response != nil && (rest.Response.StatusCode >= 500 || rest.Response.StatusCode == 429)
+	}
+	var dns *net.DNSError
+	if errors.As(err, &dns) {
+		return true
+	}
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// split cuts content into pieces no longer than limit, at line breaks where it can. A code block that gets cut is
+// closed at the end of one piece and opened again at the start of the next so both halves still render
+func split(content string, limit int) []string {
+	if len(content) <= limit {
+		return []string{content}
+	}
+	closing := "\n" + fence
+	opening := fence + "\n"
+	// The most of one line that fits in a piece along with opening and closing a code block
+	room := limit - len(opening) - len(closing)
+
+	pieces := []string{}
+	var piece strings.Builder
+	open := false // Whether piece leaves a code block open
+	for _, line := range strings.SplitAfter(content, "\n") {
+		for line != "" {
+			take := line
+			if len(take) > room {
+				take = line[:cut(line, room)]
+			}
+			if piece.Len()+len(take) > limit-len(closing) && piece.Len() > 0 {
+				text := strings.TrimRight(piece.String(), "\n")
+				piece.Reset()
+				if open {
+					text += closing
+					piece.WriteString(opening)
+				}
+				pieces = append(pieces, text)
+			}
+			piece.WriteString(take)
+			if strings.Count(take, fence)%2 == 1 {
+				open = !open
+			}
+			line = line[len(take):]
+		}
+	}
+	if strings.TrimSpace(piece.String()) != "" {
+		pieces = append(pieces, strings.TrimRight(piece.String(), "\n"))
+	}
+	return pieces
+}
+
+// cut is where to cut s so it's at most n bytes, after a space if there's one late enough and never inside a character
+func cut(s string, n int) int {
+	if space := strings.LastIndex(s[:n], " "); space > n/2 {
+		return space + 1
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
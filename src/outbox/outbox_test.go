@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"MelvinBot/src/discord/session"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		want    []string // nil to only check that it's cut up sensibly
+	}{
+		{name: "fits", content: "hello", limit: 20, want: []string{"hello"}},
+		{name: "exactly fits", content: strings.Repeat("a", 20), limit: 20, want: []string{strings.Repeat("a", 20)}},
+		{name: "at line breaks", content: "first line\nsecond line\nthird line", limit: 24, want: []string{"first line", "second line", "third line"}},
+		{name: "long line at spaces", content: "the quick brown fox jumps over the lazy dog", limit: 20, want: []string{"the quick ", "brown fox ", "jumps over ", "the lazy dog"}},
+		{
+			name:    "code block reopened",
+			content: "```\nline one\nline two\nline three\n```\nafter",
+			limit:   24,
+			want:    []string{"```\nline one\n```", "```\nline two\n```", "```\nline three\n```", "after"},
+		},
+		{
+			name:    "code block closed before the cut",
+			content: "```\nshort\n```\n" + strings.Repeat("word ", 10),
+			limit:   24,
+		},
+		{name: "never inside a character", content: strings.Repeat("é", 15), limit: 16, want: []string{"éééé", "éééé", "éééé", "ééé"}},
+		{
+			name:    "message sized",
+			content: "Quotes:\n```\n" + strings.Repeat("a quote that someone said once\n", 200) + "```\nThat's all of them",
+			limit:   MaxLength,
+		},
+		{name: "one huge line in a code block", content: "```" + strings.Repeat("x", 5000) + "```", limit: MaxLength},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pieces := split(test.content, test.limit)
+			if test.want != nil && !reflect.DeepEqual(pieces, test.want) {
+				t.Errorf("got %q, want %q", pieces, test.want)
+			}
+
+			for i, piece := range pieces {
+				if len(piece) > test.limit {
+					t.Errorf("piece %d is %d bytes, over %d", i, len(piece), test.limit)
+				}
+				if strings.Count(piece, fence)%2 != 0 {
+					t.Errorf("piece %d leaves a code block open: %q", i, piece)
+				}
+				if !utf8.ValidString(piece) {
+					t.Errorf("piece %d cuts a character in half: %q", i, piece)
+				}
+			}
+			// Nothing lost, once the fences we added and the whitespace we cut at are taken out
+			if got, want := bare(strings.Join(pieces, "")), bare(test.content); got != want {
+				t.Errorf("pieces don't add back up to the content:\n%q\n%q", got, want)
+			}
+		})
+	}
+}
+
+func bare(s string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, fence, "")), "")
+}
+
+// Every piece of a long message comes back so it can all be deleted, and the caller's files are left how they were
+func TestSendSplit(t *testing.T) {
+	fake := session.NewFake("bot")
+	o := New(fake, nil)
+	files := make([]*disc.File, 1, 2)
+	files[0] = &disc.File{Name: "a.txt", Reader: strings.NewReader("a")}
+	spare := files[:2]
+	spare[1] = &disc.File{Name: "caller's"}
+
+	content := strings.Repeat("line\n", MaxLength/5*2)
+	sent, err := session.SendSplit(o, "20", &disc.MessageSend{Content: content, Files: files, File: &disc.File{Name: "b.txt", Reader: strings.NewReader("b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := fake.Sent()
+	if len(sent) != 3 || !reflect.DeepEqual(sent, all) {
+		t.Fatalf("got %d messages back, want all %d that were sent", len(sent), len(all))
+	}
+	if spare[1].Name != "caller's" {
+		t.Errorf("the caller's files were written over with %s", spare[1].Name)
+	}
+	if attached := sent[2].Attachments; len(attached) != 2 || attached[0].Filename != "a.txt" || attached[1].Filename != "b.txt" {
+		t.Errorf("last message has %v attached, want a.txt and b.txt", attached)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &disc.RESTError{Response: &http.Response{StatusCode: 502}}, want: true},
+		{name: "rate limited", err: &disc.RESTError{Response: &http.Response{StatusCode: 429}}, want: true},
+		{name: "bad request", err: &disc.RESTError{Response: &http.Response{StatusCode: 400}}},
+		{name: "couldn't connect", err: &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, want: true},
+		{name: "couldn't look up", err: &url.Error{Op: "Post", Err: &net.DNSError{Err: "no such host"}}, want: true},
+		{name: "dropped after writing", err: &url.Error{Op: "Post", Err: &net.OpError{Op: "read", Err: errors.New("connection reset")}}},
+		{name: "timed out after writing", err: &url.Error{Op: "Post", Err: context.DeadlineExceeded}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryable(test.err); got != test.want {
+				t.Errorf("retryable is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
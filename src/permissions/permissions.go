@@ -0,0 +1,280 @@
+// Package permissions decides who can do what in each guild. Every command and a few other actions, like pinning with
+// a reaction, have a rule with a default that admins can change with !perm. Server admins can always do everything
+package permissions
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MelvinBot/src/store"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Admin is enough to change how the bot is set up
+const Admin = disc.PermissionAdministrator | disc.PermissionManageServer
+
+// How many denials each guild remembers for !perm audit
+const keepDenials = 25
+
+// An Action is anything that can be restricted, a command like removequote or something finer like quote.all
+type Action struct {
+	Name        string
+	Description string
+	Default     Rule
+}
+
+// Rule allows anyone matching any part of it
+type Rule struct {
+	Everyone    bool
+	Roles       []string // Role IDs
+	Users       []string // User IDs
+	Permissions int64    // Having any one of these Discord permissions in the channel is enough
+}
+
+func (r Rule) allows(who Who) bool {
+	if r.Everyone || who.Permissions&r.Permissions != 0 || slices.Contains(r.Users, who.UserID) {
+		return true
+	}
+	for _, role := range who.Roles {
+		if slices.Contains(r.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) String() string {
+	if r.Everyone {
+		return "everyone"
+	}
+	parts := []string{}
+	for _, name := range PermissionNames(r.Permissions) {
+		parts = append(parts, "`"+name+"`")
+	}
+	for _, role := range r.Roles {
+		parts = append(parts, fmt.Sprintf("<@&%s>", role))
+	}
+	for _, user := range r.Users {
+		parts = append(parts, fmt.Sprintf("<@%s>", user))
+	}
+	if len(parts) == 0 {
+		return "admins only"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Who is everything a rule can check about whoever is trying something
+type Who struct {
+	UserID      string
+	Roles       []string
+	Permissions int64 // In the channel they're trying it in
+}
+
+// Denial is one entry in a guild's audit log
+type Denial struct {
+	Time      time.Time
+	Action    string
+	UserID    string
+	ChannelID string
+}
+
+// GuildPermissions only stores rules an admin has changed, every other action uses its default
+type GuildPermissions struct {
+	Rules map[string]Rule
+}
+
+// Rules is every guild's changed rules keyed by guild ID, in a store so the bot can keep it in a file. The actions
+// they can be set for and denials for !perm audit go along with them but are never saved
+type Rules struct {
+	*store.Store[map[string]*GuildPermissions]
+	known   *registry
+	denials *auditLog
+}
+
+// registry is keyed by action name
+type registry struct {
+	lock   sync.RWMutex
+	byName map[string]Action
+}
+
+// auditLog is keyed by guild ID and oldest first
+type auditLog struct {
+	lock    sync.Mutex
+	byGuild map[string][]Denial
+}
+
+func New() Rules {
+	return Rules{
+		Store:   store.New(map[string]*GuildPermissions{}),
+		known:   &registry{byName: map[string]Action{}},
+		denials: &auditLog{byGuild: map[string][]Denial{}},
+	}
+}
+
+func (r Rules) Register(actions ...Action) {
+	r.known.lock.Lock()
+	defer r.known.lock.Unlock()
+	for _, action := range actions {
+		r.known.byName[action.Name] = action
+	}
+}
+
+func (r Rules) Lookup(name string) (Action, bool) {
+	r.known.lock.RLock()
+	defer r.known.lock.RUnlock()
+	action, ok := r.known.byName[name]
+	return action, ok
+}
+
+// All returns every registered action sorted by name
+func (r Rules) All() []Action {
+	r.known.lock.RLock()
+	all := []Action{}
+	for _, action := range r.known.byName {
+		all = append(all, action)
+	}
+	r.known.lock.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// RuleFor is the rule action has in the guild, and whether it's been changed from the default
+func (r Rules) RuleFor(guildID string, name string) (Rule, bool) {
+	action, ok := r.Lookup(name)
+	if !ok {
+		return Rule{}, false
+	}
+
+	rule, changed := action.Default, false
+	r.View(func(guilds map[string]*GuildPermissions) {
+		if guild, ok := guilds[guildID]; ok {
+			if saved, ok := guild.Rules[name]; ok {
+				rule, changed = saved, true
+			}
+		}
+	})
+	return rule, changed
+}
+
+// guildIn gets a guild's rules to change, making them if it's the first change
+func guildIn(guilds *map[string]*GuildPermissions, guildID string) *GuildPermissions {
+	if *guilds == nil {
+		*guilds = map[string]*GuildPermissions{}
+	}
+	guild, ok := (*guilds)[guildID]
+	if !ok {
+		guild = &GuildPermissions{}
+		(*guilds)[guildID] = guild
+	}
+	if guild.Rules == nil {
+		guild.Rules = map[string]Rule{}
+	}
+	return guild
+}
+
+// Allowed is always true for the empty action name, so things anyone can do don't need one. Unknown actions are
+// admin only rather than open to everyone
+func (r Rules) Allowed(guildID string, name string, who Who) bool {
+	if name == "" || who.Permissions&disc.PermissionAdministrator != 0 {
+		return true
+	}
+	rule, _ := r.RuleFor(guildID, name)
+	return rule.allows(who)
+}
+
+// Change edits action's rule in the guild, starting from its default if it hasn't been changed yet
+func (r Rules) Change(guildID string, name string, change func(rule *Rule)) (Rule, error) {
+	action, ok := r.Lookup(name)
+	if !ok {
+		return Rule{}, fmt.Errorf("there is no action called %s", name)
+	}
+
+	var changed Rule
+	err := r.Update(func(guilds *map[string]*GuildPermissions) error {
+		guild := guildIn(guilds, guildID)
+		rule, ok := guild.Rules[name]
+		if !ok {
+			rule = action.Default
+		}
+		// Don't let changes leak back into the default, or into a copy someone got from RuleFor
+		rule.Roles = slices.Clone(rule.Roles)
+		rule.Users = slices.Clone(rule.Users)
+		change(&rule)
+		guild.Rules[name] = rule
+		changed = rule
+		return nil
+	})
+	return changed, err
+}
+
+// Reset puts action back to its default in the guild
+func (r Rules) Reset(guildID string, name string) error {
+	if _, ok := r.Lookup(name); !ok {
+		return fmt.Errorf("there is no action called %s", name)
+	}
+
+	return r.Update(func(guilds *map[string]*GuildPermissions) error {
+		if guild, ok := (*guilds)[guildID]; ok {
+			delete(guild.Rules, name)
+		}
+		return nil
+	})
+}
+
+// Audit remembers a denial so admins can see it with !perm audit
+func (r Rules) Audit(guildID string, denial Denial) {
+	r.denials.lock.Lock()
+	defer r.denials.lock.Unlock()
+
+	guild := append(r.denials.byGuild[guildID], denial)
+	if len(guild) > keepDenials {
+		guild = guild[len(guild)-keepDenials:]
+	}
+	r.denials.byGuild[guildID] = guild
+}
+
+// Denials is the guild's audit log newest first
+func (r Rules) Denials(guildID string) []Denial {
+	r.denials.lock.Lock()
+	defer r.denials.lock.Unlock()
+
+	guild := slices.Clone(r.denials.byGuild[guildID])
+	slices.Reverse(guild)
+	return guild
+}
+
+// The permissions that make sense to hand out for bot commands, by the name !perm takes
+var permissionNames = map[string]int64{
+	"administrator":    disc.PermissionAdministrator,
+	"manage_server":    disc.PermissionManageServer,
+	"manage_channels":  disc.PermissionManageChannels,
+	"manage_roles":     disc.PermissionManageRoles,
+	"manage_messages":  disc.PermissionManageMessages,
+	"kick_members":     disc.PermissionKickMembers,
+	"ban_members":      disc.PermissionBanMembers,
+	"mention_everyone": disc.PermissionMentionEveryone,
+}
+
+func ParsePermission(name string) (int64, bool) {
+	permission, ok := permissionNames[strings.ToLower(name)]
+	return permission, ok
+}
+
+// PermissionNames lists the names of every permission set in permissions, sorted
+func PermissionNames(permissions int64) []string {
+	names := []string{}
+	for name, permission := range permissionNames {
+		if permissions&permission != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package quotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"MelvinBot/src/store"
+)
+
+func init() {
+	store.Register(store.Schema{Name: "quotes", Layout: &records, Migrations: []store.Migration{
+		{Description: "fill in what quotes from before NeedsRef and the author index are missing", Run: fillQuotes},
+	}})
+}
+
+// fillQuotes works out NeedsRef for quotes saved before audio links expired on us, and rebuilds the author index and
+// graveyard since the oldest files don't have them either
+func fillQuotes(data json.RawMessage) (json.RawMessage, string, error) {
+	var guilds map[string]map[string]any
+	err := json.Unmarshal(data, &guilds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filled, unlinked := 0, 0
+	for guildID, database := range guilds {
+		if database == nil {
+			delete(guilds, guildID)
+			continue
+		}
+		quoteList, _ := database["Quotes"].([]any)
+		authors := map[string][]int{}
+		graveyard := []int{}
+		for i, q := range quoteList {
+			quote, ok := q.(map[string]any)
+			if !ok {
+				return nil, "", fmt.Errorf("guild %s quote %d isn't an object", guildID, i)
+			}
+			if text, _ := quote["Quote"].(string); text == DeletedQuoteString {
+				graveyard = append(graveyard, i)
+				continue
+			}
+			if _, ok := quote["NeedsRef"]; !ok {
+				urls, _ := quote["AttachmentURLs"].([]any)
+				needsRef := false
+				for _, url := range urls {
+					if url, ok := url.(string); ok && isAudioFile(url) {
+						needsRef = true
+					}
+				}
+				quote["NeedsRef"] = needsRef
+				filled++
+			}
+			// Nothing to fill these in from, but it's worth knowing these quotes can't mention or link back
+			if messageID, _ := quote["MessageID"].(string); messageID == "" {
+				unlinked++
+			}
+			author, _ := quote["Author"].(string)
+			authors[strings.ToLower(author)] = append(authors[strings.ToLower(author)], i)
+		}
+		if quoteList == nil {
+			quoteList = []any{}
+		}
+		database["Quotes"] = quoteList
+		database["MapFromAuthorToQuoteIndices"] = authors
+		database["QuoteGraveyard"] = graveyard
+	}
+
+	migrated, err := json.Marshal(guilds)
+	return migrated, fmt.Sprintf("reindexed %d guild(s), worked out NeedsRef on %d quote(s), %d have no message to link back to", len(guilds), filled, unlinked), err
+}
@@ -0,0 +1,61 @@
+package quotes
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFillQuotes(t *testing.T) {
+	old := `{
+		"g": {"Quotes": [
+			{"Quote": "hi", "Author": "Alice", "MessageID": "1", "AttachmentURLs": ["https://cdn.example/clip.MP3"]},
+			{"Quote": "` + DeletedQuoteString + `"},
+			{"Quote": "hey", "Author": "alice", "AttachmentURLs": ["https://cdn.example/pic.png"]},
+			{"Quote": "yo", "Author": "Bob", "MessageID": "3", "NeedsRef": false, "AttachmentURLs": ["https://cdn.example/clip.ogg"]}
+		]},
+		"empty": {},
+		"gone": null
+	}`
+	migrated, summary, err := fillQuotes(json.RawMessage(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]*QuoteDatabase
+	if err := json.Unmarshal(migrated, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := got["gone"]; ok {
+		t.Error("a null guild wasn't dropped")
+	}
+	if empty := got["empty"]; empty == nil || empty.Quotes == nil || len(empty.MapFromAuthorToQuoteIndices) != 0 {
+		t.Errorf("empty guild is %+v", empty)
+	}
+
+	database := got["g"]
+	needsRef := []bool{}
+	for _, quote := range database.Quotes {
+		needsRef = append(needsRef, quote.NeedsRef)
+	}
+	// Bob's was already worked out, so it's left how it was
+	if want := []bool{true, false, false, false}; !reflect.DeepEqual(needsRef, want) {
+		t.Errorf("NeedsRef is %v, want %v", needsRef, want)
+	}
+	if want := map[string][]int{"alice": {0, 2}, "bob": {3}}; !reflect.DeepEqual(database.MapFromAuthorToQuoteIndices, want) {
+		t.Errorf("author index is %v, want %v", database.MapFromAuthorToQuoteIndices, want)
+	}
+	if want := []int{1}; !reflect.DeepEqual(database.QuoteGraveyard, want) {
+		t.Errorf("graveyard is %v, want %v", database.QuoteGraveyard, want)
+	}
+	if want := "reindexed 2 guild(s), worked out NeedsRef on 2 quote(s), 1 have no message to link back to"; summary != want {
+		t.Errorf("summary is %q, want %q", summary, want)
+	}
+}
+
+func TestFillQuotesBadQuote(t *testing.T) {
+	_, _, err := fillQuotes(json.RawMessage(`{"g": {"Quotes": ["not an object"]}}`))
+	if err == nil {
+		t.Error("a quote that isn't an object was migrated")
+	}
+}
@@ -0,0 +1,134 @@
+package quotes
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Module saves quotes reacted to with 💬, sends them back with !quote and posts one to the board every morning
+type Module struct {
+	module.Base
+	Quotes  Quotes
+	board   config.Quotes
+	session session.Session
+	allowed func(s session.Session, m *disc.MessageCreate, action string, what string) bool
+}
+
+func (q *Module) Name() string {
+	return "quotes"
+}
+
+func (q *Module) Init(deps module.Deps) error {
+	q.board = deps.Config.Quotes
+	q.session = deps.Session
+	q.allowed = deps.Allowed
+	q.Quotes.deletions = deps.Deletions
+	q.Quotes.logger = deps.Logger
+	_, err := deps.Storage("quotes", q.Quotes, deps.Config.Storage.QuotesFile)
+	return err
+}
+
+func (q *Module) Handlers() module.Handlers {
+	return module.Handlers{
+		MessageUpdate: []module.Handler[*disc.MessageUpdate]{{Feature: "quotes", Run: q.Quotes.EditQuote}},
+		ReactionAdd:   []module.Handler[*disc.MessageReactionAdd]{{Feature: "quotes", Run: q.Quotes.AddQuote}},
+	}
+}
+
+func (q *Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "quote",
+			Aliases:     []string{"q"},
+			Args:        []module.Arg{{Name: "query", Kind: module.ArgRest, Optional: true, Description: "A quote id, an author, all, stats, or history and a quote id"}},
+			Description: "Sends a random quote, a quote by id or author, every quote, the leaderboard or a quote's edits",
+			Feature:     "quotes",
+			Slash:       true,
+			DM:          true,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				if strings.EqualFold(args.String("query"), "all") && !q.allowed(s, m, "quote.all", "get every quote") {
+					return
+				}
+				q.Quotes.HandleQuote(ctx, s, m, args.String("query"))
+			},
+		},
+		{
+			Name:        "removequote",
+			Args:        []module.Arg{{Name: "id", Kind: module.ArgInt}},
+			Description: "Deletes a quote, you cannot delete quotes of yourself",
+			Feature:     "quotes",
+			Permission:  disc.PermissionManageMessages,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				q.Quotes.RemoveQuote(ctx, s, m, args.Int("id"))
+			},
+		},
+		{
+			Name: "submitquote",
+			Args: []module.Arg{
+				{Name: "author", Kind: module.ArgString},
+				{Name: "quote", Kind: module.ArgRest},
+			},
+			Description: "Sends in a quote said somewhere I couldn't see, a mod reviews it before it's added",
+			Feature:     "quotes",
+			DMOnly:      true,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				q.Quotes.SubmitQuote(ctx, s, m, args.String("author"), args.String("quote"))
+			},
+		},
+		{
+			Name: "review",
+			Args: []module.Arg{
+				{Name: "action", Kind: module.ArgString, Optional: true},
+				{Name: "id", Kind: module.ArgInt, Optional: true},
+			},
+			Description: "Lists submitted quotes, or approves or rejects one like !review approve 3",
+			Feature:     "quotes",
+			Permission:  disc.PermissionManageMessages,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				if args.Has("action") && !args.Has("id") {
+					util.SendSelfDestructingMessage(s, q.Quotes.deletions, m.ChannelID, "Which submission? Like `!review approve 3`", 10*time.Second)
+					return
+				}
+				q.Quotes.ReviewQuotes(ctx, s, m, args.String("action"), args.Int("id"))
+			},
+		},
+	}
+}
+
+func (q *Module) ScheduledJobs() []module.Job {
+	if q.board.BoardChannelID == "" {
+		return nil
+	}
+	// Send a quote at midnight Pacific every day
+	return []module.Job{{
+		Name:     "quote board",
+		Spec:     "0 0 0 * * *",
+		Timezone: "America/Los_Angeles",
+		Run: func(ctx context.Context) {
+			q.Quotes.sendRandomQuote(ctx, q.session, q.board.BoardChannelID, q.board.BoardGuildID)
+		},
+	}}
+}
+
+func (q Quotes) sendRandomQuote(ctx context.Context, s session.Session, channelID string, guildID string) {
+	database, ok := q.Copy(guildID)
+	if !ok {
+		return
+	}
+
+	totalQuotes := len(database.Quotes)
+	if totalQuotes == 0 {
+		return
+	}
+
+	q.SendQuote(ctx, s, database, channelID, rand.Intn(totalQuotes), totalQuotes)
+}
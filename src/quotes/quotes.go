@@ -1,11 +1,19 @@
 package quotes
 
 import (
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/selfdestruct"
+	"MelvinBot/src/store"
 	"MelvinBot/src/util"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -13,20 +21,19 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	disc "github.com/bwmarrin/discordgo"
 )
 
-const Filepath string = "/home/nelly/apps/bot/melvinquotes"
 const DeletedQuoteString = "This quote has been deleted"
 
 type QuoteDatabase struct {
 	Quotes                      []Quote
 	MapFromAuthorToQuoteIndices map[string][]int
-	QuoteGraveyard              []int // The quote graveyard is a list of indexes where we have deleted quotes but do not want to reorder the array
-	Lock                        *sync.Mutex
+	QuoteGraveyard              []int        // The quote graveyard is a list of indexes where we have deleted quotes but do not want to reorder the array
+	Submissions                 []Submission // Waiting for !review, oldest first
+	LastSubmissionID            int
 }
 
 type Quote struct {
@@ -37,15 +44,84 @@ type Quote struct {
 	MessageID      string
 	ChannelID      string
 	NeedsRef       bool
+	Edits          []Edit // Oldest first
+}
+
+// Edit is what a quote said before its message was edited
+type Edit struct {
+	Quote    string
+	EditedAt time.Time
 }
 
 func (q *Quote) String() string {
 	return fmt.Sprintf("%s -@%s", q.Quote, q.Author)
 }
 
-var GuildIDToQuoteDatabase = map[string]*QuoteDatabase{}
+// Quotes is every guild's QuoteDatabase keyed by guild ID, in a store so the bot can keep it in a file
+type Quotes struct {
+	*store.Store[map[string]*QuoteDatabase]
+	// The bot's deletion queue and the module's logger, set in Init. Only replying needs the queue so it's empty
+	// offline
+	deletions selfdestruct.Queue
+	logger    *slog.Logger
+}
+
+func New() Quotes {
+	return Quotes{Store: store.New(map[string]*QuoteDatabase{}), logger: logging.For("quotes")}
+}
+
+// Guilds is every guild with quotes
+func (q Quotes) Guilds() []string {
+	guilds := []string{}
+	q.View(func(databases map[string]*QuoteDatabase) {
+		for guildID := range databases {
+			guilds = append(guilds, guildID)
+		}
+	})
+	return guilds
+}
+
+// in gets a guild's quotes for an Update, making them if it's the first time
+func in(databases *map[string]*QuoteDatabase, guildID string) *QuoteDatabase {
+	if *databases == nil {
+		*databases = map[string]*QuoteDatabase{}
+	}
+	database, ok := (*databases)[guildID]
+	if !ok {
+		database = &QuoteDatabase{
+			Quotes:                      []Quote{},
+			MapFromAuthorToQuoteIndices: map[string][]int{},
+			QuoteGraveyard:              []int{},
+		}
+		(*databases)[guildID] = database
+	}
+	if database.MapFromAuthorToQuoteIndices == nil {
+		database.MapFromAuthorToQuoteIndices = map[string][]int{}
+	}
+	return database
+}
+
+// Copy is a guild's quotes as they are now, so sending them doesn't hold everyone else up. It's false if the guild has
+// none. Quotes are only ever replaced or appended to, so sharing what's inside them is fine
+func (q Quotes) Copy(guildID string) (*QuoteDatabase, bool) {
+	var copied *QuoteDatabase
+	q.View(func(databases map[string]*QuoteDatabase) {
+		database, ok := databases[guildID]
+		if !ok {
+			return
+		}
+		copied = &QuoteDatabase{
+			Quotes:                      slices.Clone(database.Quotes),
+			MapFromAuthorToQuoteIndices: maps.Clone(database.MapFromAuthorToQuoteIndices),
+			QuoteGraveyard:              slices.Clone(database.QuoteGraveyard),
+			Submissions:                 slices.Clone(database.Submissions),
+			LastSubmissionID:            database.LastSubmissionID,
+		}
+	})
+	return copied, copied != nil
+}
 
-func AddQuote(s *disc.Session, m *disc.MessageReactionAdd) {
+func (q Quotes) AddQuote(ctx context.Context, s session.Session, m *disc.MessageReactionAdd) {
 	if m.MessageReaction.Emoji.Name != "💬" {
 		return
 	}
@@ -55,7 +131,7 @@ func AddQuote(s *disc.Session, m *disc.MessageReactionAdd) {
 		// We cant find the message that was just reacted to?
 		return
 	}
-	if message.Author.ID == s.State.User.ID {
+	if message.Author.ID == s.BotUserID() {
 		// Disallows melvinbot from saving quotes from himself
 		return
 	}
@@ -69,16 +145,6 @@ func AddQuote(s *disc.Session, m *disc.MessageReactionAdd) {
 		}
 	}
 
-	// Disallow abuse via reacting and unreacting quote over and over.. but this only checks the last quote
-	guildID := m.GuildID
-	db, ok := GuildIDToQuoteDatabase[guildID]
-	if ok {
-		lastQuote := db.Quotes[len(db.Quotes)-1]
-		if lastQuote.Quote == message.Content {
-			return
-		}
-	}
-
 	// Check for attachments
 	attachments := []string{}
 
@@ -86,7 +152,20 @@ func AddQuote(s *disc.Session, m *disc.MessageReactionAdd) {
 		attachments = append(attachments, attachment.URL)
 	}
 
-	newQuoteID := AddQuoteToDatabase(guildID, message.Content, attachments, message.Author.Username, message.Author.ID, message.ID, message.ChannelID)
+	guildID := m.GuildID
+	newQuoteID := -1
+	err = q.Update(func(databases *map[string]*QuoteDatabase) error {
+		// Disallow abuse via reacting and unreacting quote over and over.. but this only checks the last quote. It's
+		// checked in here so two reactions at once can't both get past it
+		if db, ok := (*databases)[guildID]; ok && len(db.Quotes) > 0 && db.Quotes[len(db.Quotes)-1].Quote == message.Content {
+			return errUnchanged
+		}
+		newQuoteID = in(databases, guildID).add(message.Content, attachments, message.Author.Username, message.Author.ID, message.ID, message.ChannelID)
+		return nil
+	})
+	if err != nil {
+		return
+	}
 	// Finally ack
 	maybeContainsAttachments := ""
 	if len(attachments) > 0 {
@@ -98,26 +177,55 @@ func AddQuote(s *disc.Session, m *disc.MessageReactionAdd) {
 		messageContent = fmt.Sprintf("```%s```", message.Content)
 	}
 
-	util.SendSelfDestructingMessage(s, m.ChannelID, fmt.Sprintf("Added quote [#%d]: %s %s -%s", newQuoteID, messageContent, maybeContainsAttachments, message.Author.Username), 10*time.Second)
+	util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, fmt.Sprintf("Added quote [#%d]: %s %s -%s", newQuoteID, messageContent, maybeContainsAttachments, message.Author.Username), 10*time.Second)
 
 }
 
-func AddQuoteToDatabase(guildID string, quote string, attachmentURLs []string, author string, userID string, messageID string, channelID string) int {
-	// Just in case we have never made a quote for this guild?
-	database, ok := GuildIDToQuoteDatabase[guildID]
-	if !ok {
-		newDatabase := &QuoteDatabase{
-			Quotes:                      []Quote{},
-			MapFromAuthorToQuoteIndices: map[string][]int{},
-			QuoteGraveyard:              []int{},
-			Lock:                        &sync.Mutex{},
-		}
-		GuildIDToQuoteDatabase[guildID] = newDatabase
-		database = newDatabase
+var (
+	// errUnchanged leaves the store clean when an Update turned out to have nothing to do
+	errUnchanged = errors.New("nothing changed")
+	errOwnQuote  = errors.New("can't delete a quote of yourself")
+)
+
+// EditQuote keeps quotes in step with their message, the old text goes in the quote's edit history
+func (q Quotes) EditQuote(ctx context.Context, s session.Session, m *disc.MessageUpdate) {
+	editedAt, err := m.EditedTimestamp.Parse()
+	if err != nil {
+		editedAt = time.Now()
 	}
-	database.Lock.Lock()
-	defer database.Lock.Unlock()
+	q.Update(func(databases *map[string]*QuoteDatabase) error {
+		database, ok := (*databases)[m.GuildID]
+		if !ok {
+			return errUnchanged
+		}
+		edited := false
+		for i := range database.Quotes {
+			quote := &database.Quotes[i]
+			if quote.MessageID != m.ID || quote.Quote == m.Content || quote.Quote == DeletedQuoteString {
+				continue
+			}
+			quote.Edits = append(quote.Edits, Edit{Quote: quote.Quote, EditedAt: editedAt})
+			quote.Quote = m.Content
+			edited = true
+			q.logger.InfoContext(ctx, "quote edited", "quote", i, "edits", len(quote.Edits))
+		}
+		if !edited {
+			return errUnchanged
+		}
+		return nil
+	})
+}
+
+func (q Quotes) AddQuoteToDatabase(guildID string, quote string, attachmentURLs []string, author string, userID string, messageID string, channelID string) int {
+	quoteIndex := -1
+	q.Update(func(databases *map[string]*QuoteDatabase) error {
+		quoteIndex = in(databases, guildID).add(quote, attachmentURLs, author, userID, messageID, channelID)
+		return nil
+	})
+	return quoteIndex
+}
 
+func (d *QuoteDatabase) add(quote string, attachmentURLs []string, author string, userID string, messageID string, channelID string) int {
 	needsRef := slices.ContainsFunc(attachmentURLs, isAudioFile)
 
 	newQuote := Quote{
@@ -131,74 +239,63 @@ func AddQuoteToDatabase(guildID string, quote string, attachmentURLs []string, a
 	}
 
 	quoteIndex := -1
-	if database.QuoteGraveyard == nil {
-		database.QuoteGraveyard = []int{}
+	if d.QuoteGraveyard == nil {
+		d.QuoteGraveyard = []int{}
 	}
-	if len(database.QuoteGraveyard) != 0 {
-		quoteIndex = database.QuoteGraveyard[0]
-		database.QuoteGraveyard = database.QuoteGraveyard[1:]
+	if len(d.QuoteGraveyard) != 0 {
+		quoteIndex = d.QuoteGraveyard[0]
+		d.QuoteGraveyard = d.QuoteGraveyard[1:]
 	}
 
 	if quoteIndex != -1 {
-		database.Quotes[quoteIndex] = newQuote
+		d.Quotes[quoteIndex] = newQuote
 	} else {
-		database.Quotes = append(database.Quotes, newQuote)
-		quoteIndex = len(database.Quotes) - 1
+		d.Quotes = append(d.Quotes, newQuote)
+		quoteIndex = len(d.Quotes) - 1
 	}
 
 	// Save by username as well
-	_, ok = database.MapFromAuthorToQuoteIndices[strings.ToLower(author)]
+	_, ok := d.MapFromAuthorToQuoteIndices[strings.ToLower(author)]
 	if !ok {
-		database.MapFromAuthorToQuoteIndices[strings.ToLower(author)] = []int{}
+		d.MapFromAuthorToQuoteIndices[strings.ToLower(author)] = []int{}
 	}
-	database.MapFromAuthorToQuoteIndices[strings.ToLower(author)] = append(database.MapFromAuthorToQuoteIndices[strings.ToLower(author)], quoteIndex)
+	d.MapFromAuthorToQuoteIndices[strings.ToLower(author)] = append(d.MapFromAuthorToQuoteIndices[strings.ToLower(author)], quoteIndex)
 
 	return quoteIndex
 }
 
-func RemoveQuote(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
-
-	if !strings.Contains(m.Content, "!removequote") {
-		return
-	}
-
-	split := strings.Split(m.Content, " ")
-	if len(split) != 2 {
-		// Ok they put in some true garbage
-		util.SendSelfDestructingMessage(s, m.ChannelID, "You must specify a quote id (its a number) like !quote 5", 5*time.Second)
-		return
-	}
-	quoteInt, err := strconv.Atoi(split[1])
-	if err != nil {
-		// They put in more garbage
-		util.SendSelfDestructingMessage(s, m.ChannelID, "You must specify a quote id (its a number) like !quote 5", 5*time.Second)
-		return
-	}
-
-	database, ok := GuildIDToQuoteDatabase[m.GuildID]
-	if !ok {
-		newDatabase := &QuoteDatabase{
-			Quotes:                      []Quote{},
-			MapFromAuthorToQuoteIndices: map[string][]int{},
-			QuoteGraveyard:              []int{},
-			Lock:                        &sync.Mutex{},
+func (q Quotes) RemoveQuote(ctx context.Context, s session.Session, m *disc.MessageCreate, quoteInt int) {
+	reply := ""
+	err := q.Update(func(databases *map[string]*QuoteDatabase) error {
+		database := (*databases)[m.GuildID]
+		if database == nil || quoteInt < 0 || quoteInt >= len(database.Quotes) {
+			reply = fmt.Sprintf("There's no quote %d to delete", quoteInt)
+			return errUnchanged
 		}
-		GuildIDToQuoteDatabase[m.GuildID] = newDatabase
-		database = newDatabase
-	}
-	database.Lock.Lock()
-	defer database.Lock.Unlock()
-
-	OriginalQuote := database.Quotes[quoteInt]
-	if strings.EqualFold(OriginalQuote.UserID, m.Author.ID) {
+		OriginalQuote := database.Quotes[quoteInt]
+		if OriginalQuote.Quote == DeletedQuoteString {
+			reply = fmt.Sprintf("Quote %d is already deleted", quoteInt)
+			return errUnchanged
+		}
+		if strings.EqualFold(OriginalQuote.UserID, m.Author.ID) {
+			return errOwnQuote
+		}
+		database.remove(quoteInt)
+		reply = fmt.Sprintf("Quote %d deleted successfully", quoteInt)
+		return nil
+	})
+	if errors.Is(err, errOwnQuote) {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("You cannot delete a quote you authored [Quote #%d]", quoteInt))
 		return
 	}
+	util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, reply, 5*time.Second)
+}
+
+// remove buries a quote so its ID can be reused, it has to be in an Update
+func (d *QuoteDatabase) remove(quoteInt int) {
+	OriginalQuote := d.Quotes[quoteInt]
 	// Remove from that authors history
-	AuthorIndices, ok := database.MapFromAuthorToQuoteIndices[strings.ToLower(OriginalQuote.Author)]
+	AuthorIndices, ok := d.MapFromAuthorToQuoteIndices[strings.ToLower(OriginalQuote.Author)]
 	if ok {
 		// Ok well its technically sorted but I wont rely on that, just hit the entire array
 		new := []int{}
@@ -207,108 +304,163 @@ func RemoveQuote(s *disc.Session, m *disc.MessageCreate) {
 				new = append(new, index)
 			}
 		}
-		database.MapFromAuthorToQuoteIndices[strings.ToLower(OriginalQuote.Author)] = new
+		d.MapFromAuthorToQuoteIndices[strings.ToLower(OriginalQuote.Author)] = new
+		// An author with nothing left isn't one, !quote would pick from an empty list and a database wouldn't keep them
+		if len(new) == 0 {
+			delete(d.MapFromAuthorToQuoteIndices, strings.ToLower(OriginalQuote.Author))
+		}
 	}
 
-	database.Quotes[quoteInt] = Quote{
+	d.Quotes[quoteInt] = Quote{
 		Quote: DeletedQuoteString,
 	}
 
-	if database.QuoteGraveyard == nil {
-		database.QuoteGraveyard = []int{}
+	if d.QuoteGraveyard == nil {
+		d.QuoteGraveyard = []int{}
 	}
 
-	database.QuoteGraveyard = append(database.QuoteGraveyard, quoteInt)
-	util.SendSelfDestructingMessage(s, m.ChannelID, fmt.Sprintf("Quote %d deleted successfully", quoteInt), 5*time.Second)
+	d.QuoteGraveyard = append(d.QuoteGraveyard, quoteInt)
 }
 
-func HandleQuote(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
+// Listed is a quote along with its ID
+type Listed struct {
+	ID int
+	Quote
+}
 
-	if !strings.Contains(m.Content, "!quote") {
-		return
+// Search is every quote in guildID whose text or author contains text, ignoring case. Empty text finds them all
+func (q Quotes) Search(guildID string, text string) []Listed {
+	text = strings.ToLower(text)
+	found := []Listed{}
+	q.View(func(databases map[string]*QuoteDatabase) {
+		database, ok := databases[guildID]
+		if !ok {
+			found = nil
+			return
+		}
+		for i, quote := range database.Quotes {
+			if quote.Quote == DeletedQuoteString {
+				continue
+			}
+			if strings.Contains(strings.ToLower(quote.Quote), text) || strings.Contains(strings.ToLower(quote.Author), text) {
+				found = append(found, Listed{ID: i, Quote: quote})
+			}
+		}
+	})
+	return found
+}
+
+// change runs f on a quote that exists in an Update
+func (q Quotes) change(guildID string, quoteInt int, f func(database *QuoteDatabase) error) error {
+	return q.Update(func(databases *map[string]*QuoteDatabase) error {
+		database, ok := (*databases)[guildID]
+		if !ok {
+			return fmt.Errorf("guild %s has no quotes", guildID)
+		}
+		if quoteInt < 0 || quoteInt >= len(database.Quotes) || database.Quotes[quoteInt].Quote == DeletedQuoteString {
+			return fmt.Errorf("there's no quote %d", quoteInt)
+		}
+		return f(database)
+	})
+}
+
+// SetQuote rewrites a quote by hand, the old text goes in its edit history like an edit on Discord
+func (q Quotes) SetQuote(guildID string, quoteInt int, text string) error {
+	err := q.change(guildID, quoteInt, func(database *QuoteDatabase) error {
+		quote := &database.Quotes[quoteInt]
+		if quote.Quote == text {
+			return errUnchanged
+		}
+		quote.Edits = append(quote.Edits, Edit{Quote: quote.Quote, EditedAt: time.Now()})
+		quote.Quote = text
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
 	}
+	return err
+}
+
+// DeleteQuote is !removequote without the checks on who's asking
+func (q Quotes) DeleteQuote(guildID string, quoteInt int) error {
+	return q.change(guildID, quoteInt, func(database *QuoteDatabase) error {
+		database.remove(quoteInt)
+		return nil
+	})
+}
 
+// query is everything after !quote, it can be empty for a random quote
+func (q Quotes) HandleQuote(ctx context.Context, s session.Session, m *disc.MessageCreate, query string) {
 	guildID := m.GuildID
 
-	database, ok := GuildIDToQuoteDatabase[guildID]
-	// Just in case we never have init'd quotes in this server
+	database, ok := q.Copy(guildID)
 	if !ok {
-		newDatabase := &QuoteDatabase{
-			Quotes:                      []Quote{},
-			MapFromAuthorToQuoteIndices: map[string][]int{},
-			QuoteGraveyard:              []int{},
-			Lock:                        &sync.Mutex{},
-		}
-		GuildIDToQuoteDatabase[guildID] = newDatabase
-		database = newDatabase
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "This server has no saved quotes yet!", 10*time.Second)
+		return
 	}
 
-	database.Lock.Lock()
-	defer database.Lock.Unlock()
-
 	totalQuotes := len(database.Quotes)
 	if totalQuotes == 0 {
-		util.SendSelfDestructingMessage(s, m.ChannelID, "This server has no saved quotes yet!", 10*time.Second)
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "This server has no saved quotes yet!", 10*time.Second)
 		return
 	}
 
 	// Random quote
-	if m.Content == "!quote" {
-		database.SendRandomQuote(s, m.ChannelID, totalQuotes)
+	if query == "" {
+		q.SendRandomQuote(ctx, s, database, m.ChannelID, totalQuotes)
 		return
 	}
 
-	split := strings.SplitN(m.Content, " ", 2)
-	if len(split) != 2 {
-		// Ok they put in some true garbage
-		util.SendSelfDestructingMessage(s, m.ChannelID, "You must specify a quote id (its a number) or a name like !quote 5 or !quote jesus", 5*time.Second)
-		return
-	}
-	quoteInt, err := strconv.Atoi(split[1])
+	quoteInt, err := strconv.Atoi(query)
 	if err == nil {
-		database.SendQuote(s, m.ChannelID, quoteInt, totalQuotes)
+		q.SendQuote(ctx, s, database, m.ChannelID, quoteInt, totalQuotes)
 		return
 	}
+	// What a quote said before it was edited, like history 5
+	if id, ok := strings.CutPrefix(strings.ToLower(query), "history "); ok {
+		quoteInt, err := strconv.Atoi(strings.TrimSpace(id))
+		if err == nil {
+			q.SendQuoteHistory(s, database, m.ChannelID, quoteInt, totalQuotes)
+			return
+		}
+	}
 	// Attempt to find the user?
-	authorQuoteIndices, ok := database.MapFromAuthorToQuoteIndices[strings.ToLower(split[1])]
+	authorQuoteIndices, ok := database.MapFromAuthorToQuoteIndices[strings.ToLower(query)]
 	if ok {
-		database.SendQuote(s, m.ChannelID, authorQuoteIndices[rand.Intn(len(authorQuoteIndices))], totalQuotes)
+		q.SendQuote(ctx, s, database, m.ChannelID, authorQuoteIndices[rand.Intn(len(authorQuoteIndices))], totalQuotes)
 		return
 	}
 	// Maybe its a mention?
-	userID := strings.TrimSuffix(strings.TrimPrefix(split[1], "<@"), ">")
+	userID := strings.TrimSuffix(strings.TrimPrefix(query, "<@"), ">")
 	user, err := s.User(userID)
 	if err == nil {
 		authorQuoteIndices, ok := database.MapFromAuthorToQuoteIndices[strings.ToLower(user.Username)]
 		if ok {
-			database.SendQuote(s, m.ChannelID, authorQuoteIndices[rand.Intn(len(authorQuoteIndices))], totalQuotes)
+			q.SendQuote(ctx, s, database, m.ChannelID, authorQuoteIndices[rand.Intn(len(authorQuoteIndices))], totalQuotes)
 			return
 		}
 	}
 
 	// allow getting all quotes
-	if strings.ToLower(split[1]) == "all" {
-		database.SendAllQuotesAsAttachment(s, m.ChannelID)
+	if strings.ToLower(query) == "all" {
+		q.SendAllQuotesAsAttachment(s, database, m.ChannelID)
 		return
 	}
 
 	// allow quote leaderboard.. even if the author string is weird..
-	if strings.ToLower((split[1])) == "stats" {
-		database.SendQuoteStats(s, m.ChannelID)
+	if strings.ToLower((query)) == "stats" {
+		q.SendQuoteStats(s, database, m.ChannelID)
 		return
 	}
 	// Nothing we can do
-	util.SendSelfDestructingMessage(s, m.ChannelID, "You must specify a quote id (its a number) or a name like !quote 5 or !quote jesus", 5*time.Second)
+	util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "You must specify a quote id (its a number) or a name like !quote 5 or !quote jesus", 5*time.Second)
 
 }
 
-func (d *QuoteDatabase) SendQuote(s *disc.Session, ChannelID string, index int, totalQuotes int) {
+func (q Quotes) SendQuote(ctx context.Context, s session.Session, d *QuoteDatabase, ChannelID string, index int, totalQuotes int) {
 
-	if index >= totalQuotes {
-		util.SendSelfDestructingMessage(s, ChannelID, fmt.Sprintf("Sorry we only have up to quote %d", totalQuotes-1), 5*time.Second)
+	if index < 0 || index >= totalQuotes {
+		util.SendSelfDestructingMessage(s, q.deletions, ChannelID, fmt.Sprintf("Sorry we only have up to quote %d", totalQuotes-1), 5*time.Second)
 		return
 	}
 
@@ -329,7 +481,7 @@ func (d *QuoteDatabase) SendQuote(s *disc.Session, ChannelID string, index int,
 				attachmentURLs = append(attachmentURLs, att.URL)
 			}
 		} else {
-			log.Printf("error fetching live message to refresh attachments: %v", errFetch)
+			q.logger.WarnContext(ctx, "error fetching live message to refresh attachments", "quote", index, "err", errFetch)
 		}
 	}
 
@@ -338,7 +490,7 @@ func (d *QuoteDatabase) SendQuote(s *disc.Session, ChannelID string, index int,
 
 	for _, URL := range attachmentURLs {
 		if isAudioFile(URL) {
-			data, err := downloadFile(URL)
+			data, err := downloadFile(ctx, URL)
 			if err == nil {
 				filename := getFilenameFromURL(URL)
 				files = append(files, &disc.File{
@@ -346,7 +498,7 @@ func (d *QuoteDatabase) SendQuote(s *disc.Session, ChannelID string, index int,
 					Reader: bytes.NewReader(data),
 				})
 			} else {
-				log.Printf("error downloading audio file %s: %v", URL, err)
+				q.logger.WarnContext(ctx, "error downloading audio file", "quote", index, "url", URL, "err", err)
 				nonAudioAttachmentURLs = append(nonAudioAttachmentURLs, URL)
 			}
 		} else {
@@ -370,13 +522,17 @@ func (d *QuoteDatabase) SendQuote(s *disc.Session, ChannelID string, index int,
 		body = quote.Quote
 	}
 
-	content := fmt.Sprintf("[#%d]: %s %s\n-%s", index, body, attachmentURLS.String(), author)
+	edited := ""
+	if len(quote.Edits) > 0 {
+		edited = " (edited)"
+	}
+	content := fmt.Sprintf("[#%d]: %s %s\n-%s%s", index, body, attachmentURLS.String(), author, edited)
 	_, err = s.ChannelMessageSendComplex(ChannelID, &disc.MessageSend{
 		Content: content,
 		Files:   files,
 	})
 	if err != nil {
-		log.Printf("error sending message for random quote %v", err)
+		q.logger.ErrorContext(ctx, "error sending quote", "quote", index, "err", err)
 	}
 }
 
@@ -386,13 +542,14 @@ func cleanURL(urlStr string) string {
 	return urlStr
 }
 
-func downloadFile(urlStr string) ([]byte, error) {
+func downloadFile(ctx context.Context, urlStr string) ([]byte, error) {
 	urlStr = cleanURL(urlStr)
 
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: metrics.Transport("attachments", nil),
 	}
-	req, err := http.NewRequest("GET", urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -441,7 +598,27 @@ func getFilenameFromURL(urlStr string) string {
 	return "audio.mp3"
 }
 
-func (d *QuoteDatabase) SendRandomQuote(s *disc.Session, ChannelID string, totalQuotes int) {
+func (q Quotes) SendQuoteHistory(s session.Session, d *QuoteDatabase, channelID string, index int, totalQuotes int) {
+	if index < 0 || index >= totalQuotes {
+		util.SendSelfDestructingMessage(s, q.deletions, channelID, fmt.Sprintf("Sorry we only have up to quote %d", totalQuotes-1), 5*time.Second)
+		return
+	}
+	quote := d.Quotes[index]
+	if len(quote.Edits) == 0 {
+		util.SendSelfDestructingMessage(s, q.deletions, channelID, fmt.Sprintf("Quote %d has never been edited", index), 5*time.Second)
+		return
+	}
+
+	var history strings.Builder
+	history.WriteString(fmt.Sprintf("**Quote #%d before it was edited**, oldest first", index))
+	for _, edit := range quote.Edits {
+		history.WriteString(fmt.Sprintf("\n%s: ```%s```", edit.EditedAt.Format(time.RFC3339), edit.Quote))
+	}
+	history.WriteString(fmt.Sprintf("\nNow: ```%s```", quote.Quote))
+	s.ChannelMessageSend(channelID, history.String())
+}
+
+func (q Quotes) SendRandomQuote(ctx context.Context, s session.Session, d *QuoteDatabase, ChannelID string, totalQuotes int) {
 	for i := 0; i < 10; i++ {
 		index := rand.Intn(totalQuotes)
 
@@ -451,12 +628,12 @@ func (d *QuoteDatabase) SendRandomQuote(s *disc.Session, ChannelID string, total
 			continue // Dont random a deleted quote
 		}
 
-		d.SendQuote(s, ChannelID, index, totalQuotes)
+		q.SendQuote(ctx, s, d, ChannelID, index, totalQuotes)
 		return
 	}
 }
 
-func (d *QuoteDatabase) SendAllQuotesAsAttachment(s *disc.Session, channelID string) {
+func (q Quotes) SendAllQuotesAsAttachment(s session.Session, d *QuoteDatabase, channelID string) {
 	var quoteBuffer bytes.Buffer
 
 	for i, quote := range d.Quotes {
@@ -474,21 +651,15 @@ func (d *QuoteDatabase) SendAllQuotesAsAttachment(s *disc.Session, channelID str
 	var reader io.Reader = &quoteBuffer
 	filemsg, err := s.ChannelFileSend(channelID, "quotes.txt", reader)
 	if err != nil {
-		log.Printf("error sending all quotes %v", err)
+		q.logger.Error("error sending all quotes", "channel", channelID, "err", err)
 		return
 	}
 
-	util.SendSelfDestructingMessage(s, channelID, "Deleting this file in 30 seconds", 30*time.Second)
-	go func() {
-		time.Sleep(30 * time.Second)
-		err = s.ChannelMessageDelete(channelID, filemsg.ID)
-		if err != nil {
-			log.Printf("error deleting all quotes file %v", err)
-		}
-	}()
+	util.SendSelfDestructingMessage(s, q.deletions, channelID, "Deleting this file in 30 seconds", 30*time.Second)
+	q.deletions.After(channelID, filemsg.ID, 30*time.Second)
 }
 
-func (d *QuoteDatabase) SendQuoteStats(s *disc.Session, channelID string) {
+func (q Quotes) SendQuoteStats(s session.Session, d *QuoteDatabase, channelID string) {
 	authorToCount := map[string]int{}
 
 	for _, q := range d.Quotes {
@@ -536,9 +707,9 @@ func (d *QuoteDatabase) SendQuoteStats(s *disc.Session, channelID string) {
 		if ac.author != "unknown" {
 			// Map to a username
 			user, err := s.User(ac.author)
-			// Pull out any stupid markdown marking
-			username := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(user.Username, "_", ""), "*", ""), "`", "")
 			if err == nil {
+				// Pull out any stupid markdown marking
+				username := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(user.Username, "_", ""), "*", ""), "`", "")
 				outputStr.WriteString(fmt.Sprintf("%s : %d \n", username, ac.count))
 			}
 		}
@@ -550,6 +721,6 @@ func (d *QuoteDatabase) SendQuoteStats(s *disc.Session, channelID string) {
 
 	_, err := s.ChannelMessageSend(channelID, outputStr.String())
 	if err != nil {
-		log.Printf("error sending quote stats: %v", err)
+		q.logger.Error("error sending quote stats", "channel", channelID, "err", err)
 	}
 }
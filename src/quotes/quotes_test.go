@@ -0,0 +1,100 @@
+package quotes
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/selfdestruct"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+func TestRemoveQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		guildID  string
+		authorID string // Who's asking
+		quoteInt int
+		reply    string
+		removed  bool
+	}{
+		{name: "someone else's quote", guildID: "g", authorID: "asker", quoteInt: 1, reply: "Quote 1 deleted successfully", removed: true},
+		{name: "own quote", guildID: "g", authorID: "bob", quoteInt: 1, reply: "You cannot delete a quote you authored [Quote #1]"},
+		{name: "own quote ignores case", guildID: "g", authorID: "BOB", quoteInt: 1, reply: "You cannot delete a quote you authored [Quote #1]"},
+		{name: "already deleted", guildID: "g", authorID: "asker", quoteInt: 2, reply: "Quote 2 is already deleted"},
+		{name: "past the end", guildID: "g", authorID: "asker", quoteInt: 3, reply: "There's no quote 3 to delete"},
+		{name: "negative", guildID: "g", authorID: "asker", quoteInt: -1, reply: "There's no quote -1 to delete"},
+		{name: "no quotes in the guild", guildID: "other", authorID: "asker", quoteInt: 0, reply: "There's no quote 0 to delete"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := New()
+			q.deletions = selfdestruct.New()
+			q.AddQuoteToDatabase("g", "first", nil, "Alice", "alice", "m0", "c")
+			q.AddQuoteToDatabase("g", "second", nil, "Bob", "bob", "m1", "c")
+			q.AddQuoteToDatabase("g", "third", nil, "Bob", "bob", "m2", "c")
+			q.Update(func(databases *map[string]*QuoteDatabase) error {
+				(*databases)["g"].remove(2)
+				return nil
+			})
+
+			s := session.NewFake("bot")
+			m := &disc.MessageCreate{Message: &disc.Message{GuildID: test.guildID, ChannelID: "c", Author: &disc.User{ID: test.authorID}}}
+			q.RemoveQuote(context.Background(), s, m, test.quoteInt)
+
+			sent := s.Sent()
+			if len(sent) != 1 || !strings.HasPrefix(sent[0].Content, test.reply) {
+				t.Fatalf("sent %v, want one reply starting %q", contents(sent), test.reply)
+			}
+
+			q.View(func(databases map[string]*QuoteDatabase) {
+				database := databases["g"]
+				gone := database.Quotes[1].Quote == DeletedQuoteString
+				if gone != test.removed {
+					t.Errorf("quote 1 deleted = %v, want %v", gone, test.removed)
+				}
+				if got := slices.Contains(database.QuoteGraveyard, 1); got != test.removed {
+					t.Errorf("quote 1 in the graveyard = %v, want %v", got, test.removed)
+				}
+				if got := slices.Contains(database.MapFromAuthorToQuoteIndices["bob"], 1); got == test.removed {
+					t.Errorf("quote 1 still listed under bob = %v, want %v", got, !test.removed)
+				}
+			})
+		})
+	}
+}
+
+// Once an author's last quote is removed they aren't an author any more, !quote used to pick from an empty list
+func TestQuoteRemovedAuthor(t *testing.T) {
+	q := New()
+	q.deletions = selfdestruct.New()
+	q.AddQuoteToDatabase("g", "first", nil, "Alice", "alice", "m0", "c")
+	q.AddQuoteToDatabase("g", "second", nil, "Bob", "bob", "m1", "c")
+
+	s := session.NewFake("bot")
+	asker := &disc.User{ID: "alice"}
+	q.RemoveQuote(context.Background(), s, &disc.MessageCreate{Message: &disc.Message{GuildID: "g", ChannelID: "c", Author: asker}}, 1)
+	q.View(func(databases map[string]*QuoteDatabase) {
+		if indices, ok := databases["g"].MapFromAuthorToQuoteIndices["bob"]; ok {
+			t.Errorf("bob is still an author with %v", indices)
+		}
+	})
+
+	q.HandleQuote(context.Background(), s, &disc.MessageCreate{Message: &disc.Message{GuildID: "g", ChannelID: "c", Author: asker}}, "bob")
+	sent := s.Sent()
+	if last := sent[len(sent)-1].Content; !strings.HasPrefix(last, "You must specify a quote id") {
+		t.Errorf("!quote bob replied %q", last)
+	}
+}
+
+func contents(msgs []*disc.Message) []string {
+	all := []string{}
+	for _, msg := range msgs {
+		all = append(all, msg.Content)
+	}
+	return all
+}
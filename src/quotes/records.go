@@ -0,0 +1,201 @@
+package quotes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"MelvinBot/src/store"
+)
+
+// In a database every quote is a record of its own, so saving one only writes the quotes that changed, though the whole
+// store is still marshalled to find them. Each guild's bucket has them under quotes by ID, indexes of the live ones
+// under authors and messages, and everything else in meta. The bot itself works from memory, the indexes are for
+// Find, which looks quotes up without loading the store
+const (
+	quotesBucket   = "quotes"
+	authorsBucket  = "authors"
+	messagesBucket = "messages"
+	metaKey        = "meta"
+)
+
+// maxMissing is how far past the number of quotes an ID can be before we stop believing it, each ID in between would
+// be a quote that went missing
+const maxMissing = 1000
+
+// meta is what's left of a QuoteDatabase once the quotes and the author index are taken out
+type meta struct {
+	QuoteGraveyard   []int
+	Submissions      []Submission
+	LastSubmissionID int
+}
+
+// recordID pads quote IDs to the width of the biggest int so they sort in order
+func recordID(id int) string {
+	return fmt.Sprintf("%019d", id)
+}
+
+// indexKey lets one author or message have more than one quote, everything for value starts with value and a 0 byte
+func indexKey(value string, id int) string {
+	return value + "\x00" + recordID(id)
+}
+
+var records = store.Layout{
+	Split: func(data json.RawMessage) ([]store.Record, error) {
+		var guilds map[string]*QuoteDatabase
+		err := json.Unmarshal(data, &guilds)
+		if err != nil {
+			return nil, err
+		}
+		split := []store.Record{}
+		for guildID, database := range guilds {
+			value, err := json.Marshal(meta{QuoteGraveyard: database.QuoteGraveyard, Submissions: database.Submissions, LastSubmissionID: database.LastSubmissionID})
+			if err != nil {
+				return nil, err
+			}
+			split = append(split, store.Record{Path: []string{guildID, metaKey}, Value: value})
+			for id, quote := range database.Quotes {
+				value, err := json.Marshal(quote)
+				if err != nil {
+					return nil, err
+				}
+				split = append(split, store.Record{Path: []string{guildID, quotesBucket, recordID(id)}, Value: value})
+				if quote.Quote == DeletedQuoteString {
+					continue
+				}
+				indexed := json.RawMessage(strconv.Itoa(id))
+				split = append(split, store.Record{Path: []string{guildID, authorsBucket, indexKey(strings.ToLower(quote.Author), id)}, Value: indexed})
+				if quote.MessageID != "" {
+					split = append(split, store.Record{Path: []string{guildID, messagesBucket, indexKey(quote.MessageID, id)}, Value: indexed})
+				}
+			}
+		}
+		return split, nil
+	},
+	Join: func(split []store.Record) (json.RawMessage, error) {
+		// How many quotes each guild has, so a bad key can't have us pad out to some huge ID
+		stored := map[string]int{}
+		for _, record := range split {
+			if len(record.Path) == 3 && record.Path[1] == quotesBucket {
+				stored[record.Path[0]]++
+			}
+		}
+		padded := map[string]map[int]bool{}
+
+		guilds := map[string]*QuoteDatabase{}
+		for _, record := range split {
+			guildID := record.Path[0]
+			database, ok := guilds[guildID]
+			if !ok {
+				database = &QuoteDatabase{Quotes: []Quote{}, MapFromAuthorToQuoteIndices: map[string][]int{}, QuoteGraveyard: []int{}}
+				guilds[guildID] = database
+			}
+			switch {
+			case len(record.Path) == 2 && record.Path[1] == metaKey:
+				var m meta
+				err := json.Unmarshal(record.Value, &m)
+				if err != nil {
+					return nil, fmt.Errorf("guild %s: %w", guildID, err)
+				}
+				database.QuoteGraveyard, database.Submissions, database.LastSubmissionID = m.QuoteGraveyard, m.Submissions, m.LastSubmissionID
+			case len(record.Path) == 3 && record.Path[1] == quotesBucket:
+				id, err := strconv.Atoi(record.Path[2])
+				if err != nil || id < 0 {
+					return nil, fmt.Errorf("guild %s has a quote with a bad ID %q", guildID, record.Path[2])
+				}
+				if id >= stored[guildID]+maxMissing {
+					return nil, fmt.Errorf("guild %s has quote %d but only %d quotes, too many would be missing", guildID, id, stored[guildID])
+				}
+				var quote Quote
+				err = json.Unmarshal(record.Value, &quote)
+				if err != nil {
+					return nil, fmt.Errorf("guild %s quote %d: %w", guildID, id, err)
+				}
+				// Placed by ID rather than order, so one that went missing is padded and keys from an older padding still fit
+				if padded[guildID] == nil {
+					padded[guildID] = map[int]bool{}
+				}
+				for len(database.Quotes) <= id {
+					padded[guildID][len(database.Quotes)] = true
+					database.Quotes = append(database.Quotes, Quote{Quote: DeletedQuoteString})
+				}
+				delete(padded[guildID], id)
+				database.Quotes[id] = quote
+			case len(record.Path) == 3 && record.Path[1] == authorsBucket:
+				author, _, _ := strings.Cut(record.Path[2], "\x00")
+				var id int
+				err := json.Unmarshal(record.Value, &id)
+				if err != nil {
+					return nil, fmt.Errorf("guild %s has a bad author index entry for %s: %w", guildID, author, err)
+				}
+				database.MapFromAuthorToQuoteIndices[author] = append(database.MapFromAuthorToQuoteIndices[author], id)
+			}
+		}
+		// A padded ID is as good as deleted, so it goes in the graveyard to be reused like one
+		for guildID, ids := range padded {
+			database := guilds[guildID]
+			missing := []int{}
+			for id := range ids {
+				if !slices.Contains(database.QuoteGraveyard, id) {
+					missing = append(missing, id)
+				}
+			}
+			sort.Ints(missing)
+			database.QuoteGraveyard = append(database.QuoteGraveyard, missing...)
+		}
+		return json.Marshal(guilds)
+	},
+}
+
+// Find looks quotes up in a database without loading the rest, by is id, author or message. Deleted quotes are never
+// found
+func Find(db *store.DB, guildID string, by string, value string) ([]Listed, error) {
+	ids := []int{}
+	switch by {
+	case "id":
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a quote ID", value)
+		}
+		ids = append(ids, id)
+	case "author", "message":
+		bucket, prefix := authorsBucket, strings.ToLower(value)
+		if by == "message" {
+			bucket, prefix = messagesBucket, value
+		}
+		err := db.Scan("quotes", []string{guildID, bucket}, prefix+"\x00", func(_ string, indexed json.RawMessage) error {
+			var id int
+			err := json.Unmarshal(indexed, &id)
+			ids = append(ids, id)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("can find quotes by id, author or message, not %s", by)
+	}
+
+	found := []Listed{}
+	for _, id := range ids {
+		record, ok, err := db.Lookup("quotes", guildID, quotesBucket, recordID(id))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		var quote Quote
+		err = json.Unmarshal(record, &quote)
+		if err != nil {
+			return nil, fmt.Errorf("quote %d: %w", id, err)
+		}
+		if quote.Quote != DeletedQuoteString {
+			found = append(found, Listed{ID: id, Quote: quote})
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,161 @@
+package quotes
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"MelvinBot/src/store"
+)
+
+func TestRecordID(t *testing.T) {
+	ids := []int{10, 2, 1 << 40, 0, 99}
+	keys := []string{}
+	for _, id := range ids {
+		keys = append(keys, recordID(id))
+	}
+	sort.Strings(keys)
+	want := []string{recordID(0), recordID(2), recordID(10), recordID(99), recordID(1 << 40)}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys sort as %v, want %v", keys, want)
+	}
+	if len(recordID(0)) != len(recordID(int(^uint(0)>>1))) {
+		t.Error("the biggest int is wider than the padding")
+	}
+}
+
+// withQuotes is a database holding two guilds' quotes, one of them deleted
+func withQuotes(t *testing.T) (*store.DB, Quotes) {
+	t.Helper()
+	db, err := store.OpenDB(filepath.Join(t.TempDir(), "quotes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	q := New()
+	if _, err := q.OpenIn(db, "quotes"); err != nil {
+		t.Fatal(err)
+	}
+	q.AddQuoteToDatabase("g", "first", nil, "Alice", "30", "100", "20")
+	q.AddQuoteToDatabase("g", "second", []string{"https://cdn.example/clip.mp3"}, "Bob", "31", "101", "20")
+	q.AddQuoteToDatabase("g", "third", nil, "alice", "30", "", "20")
+	q.AddQuoteToDatabase("h", "elsewhere", nil, "Alice", "30", "102", "21")
+	q.Update(func(databases *map[string]*QuoteDatabase) error {
+		(*databases)["g"].remove(1)
+		return nil
+	})
+	if err := q.Put(); err != nil {
+		t.Fatal(err)
+	}
+	return db, q
+}
+
+func TestRecordsRoundTrip(t *testing.T) {
+	db, q := withQuotes(t)
+
+	loaded := New()
+	if _, err := loaded.OpenIn(db, "quotes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Get(); err != nil {
+		t.Fatal(err)
+	}
+	for _, guildID := range []string{"g", "h"} {
+		want, _ := q.Copy(guildID)
+		got, ok := loaded.Copy(guildID)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("guild %s loaded as %+v, want %+v", guildID, got, want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	db, _ := withQuotes(t)
+	tests := []struct {
+		by    string
+		value string
+		want  []int
+		err   bool
+	}{
+		{by: "id", value: "0", want: []int{0}},
+		{by: "id", value: "1", want: []int{}}, // Deleted
+		{by: "id", value: "7", want: []int{}},
+		{by: "id", value: "first", err: true},
+		{by: "author", value: "ALICE", want: []int{0, 2}},
+		{by: "author", value: "bob", want: []int{}},
+		{by: "author", value: "ali", want: []int{}}, // Only whole names
+		{by: "message", value: "100", want: []int{0}},
+		{by: "message", value: "102", want: []int{}}, // In the other guild
+		{by: "channel", value: "20", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.by+" "+test.value, func(t *testing.T) {
+			found, err := Find(db, "g", test.by, test.value)
+			if test.err {
+				if err == nil {
+					t.Fatalf("found %v, want an error", found)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, listed := range found {
+				ids = append(ids, listed.ID)
+			}
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("found %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestJoinMissingQuotes(t *testing.T) {
+	quote := func(id string) store.Record {
+		return store.Record{Path: []string{"g", quotesBucket, id}, Value: []byte(`{"Quote":"hi","Author":"Alice"}`)}
+	}
+	graveyard := store.Record{Path: []string{"g", metaKey}, Value: []byte(`{"QuoteGraveyard":[1]}`)}
+	tests := []struct {
+		name      string
+		records   []store.Record
+		quotes    int
+		graveyard []int
+		err       bool
+	}{
+		{name: "none missing", records: []store.Record{graveyard, quote(recordID(0)), quote(recordID(1))}, quotes: 2, graveyard: []int{1}},
+		{name: "some missing", records: []store.Record{graveyard, quote(recordID(0)), quote(recordID(4))}, quotes: 5, graveyard: []int{1, 2, 3}},
+		{name: "negative", records: []store.Record{quote("-1")}, err: true},
+		{name: "far past the rest", records: []store.Record{quote(recordID(0)), quote(recordID(1 << 40))}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			joined, err := records.Join(test.records)
+			if test.err {
+				if err == nil {
+					t.Fatal("joined, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var guilds map[string]*QuoteDatabase
+			if err := json.Unmarshal(joined, &guilds); err != nil {
+				t.Fatal(err)
+			}
+			database := guilds["g"]
+			if len(database.Quotes) != test.quotes {
+				t.Errorf("got %d quotes, want %d", len(database.Quotes), test.quotes)
+			}
+			if !reflect.DeepEqual(database.QuoteGraveyard, test.graveyard) {
+				t.Errorf("graveyard is %v, want %v", database.QuoteGraveyard, test.graveyard)
+			}
+		})
+	}
+}
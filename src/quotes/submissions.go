@@ -0,0 +1,159 @@
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/util"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Someone can't have more than this many quotes waiting for review in one guild
+const maxPendingSubmissions = 5
+
+// Submission is a quote someone sent in from a DM instead of reacting to a message, it waits until a mod reviews it
+type Submission struct {
+	ID            int
+	Quote         string
+	Author        string
+	UserID        string // The author's, empty unless they were a mention
+	SubmittedBy   string
+	SubmitterName string
+	SubmittedAt   time.Time
+}
+
+func (sub *Submission) String() string {
+	return fmt.Sprintf("[#%d] %s -%s, sent in by %s", sub.ID, sub.Quote, sub.Author, sub.SubmitterName)
+}
+
+// SubmitQuote queues a quote for review. author can be a mention, then the quote links to them like a reacted one
+func (q Quotes) SubmitQuote(ctx context.Context, s session.Session, m *disc.MessageCreate, author string, quote string) {
+	userID := ""
+	if id, ok := strings.CutPrefix(strings.TrimSuffix(author, ">"), "<@"); ok {
+		user, err := s.User(strings.TrimPrefix(id, "!"))
+		if err != nil {
+			util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "I couldn't find who you mentioned, try their name instead", 10*time.Second)
+			return
+		}
+		author, userID = user.Username, user.ID
+	}
+
+	var sub Submission
+	pending := 0
+	err := q.Update(func(databases *map[string]*QuoteDatabase) error {
+		if database, ok := (*databases)[m.GuildID]; ok {
+			for _, waiting := range database.Submissions {
+				if waiting.SubmittedBy == m.Author.ID {
+					pending++
+				}
+			}
+		}
+		if pending >= maxPendingSubmissions {
+			return errUnchanged
+		}
+		database := in(databases, m.GuildID)
+		database.LastSubmissionID++
+		sub = Submission{
+			ID:            database.LastSubmissionID,
+			Quote:         quote,
+			Author:        author,
+			UserID:        userID,
+			SubmittedBy:   m.Author.ID,
+			SubmitterName: m.Author.Username,
+			SubmittedAt:   time.Now(),
+		}
+		database.Submissions = append(database.Submissions, sub)
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, fmt.Sprintf("You already have %d quotes waiting for review, give the mods a chance to catch up", pending), 10*time.Second)
+		return
+	}
+	if err != nil {
+		q.logger.ErrorContext(ctx, "could not save a quote submission", "guild", m.GuildID, "err", err)
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "I couldn't save your quote, try again in a bit", 10*time.Second)
+		return
+	}
+
+	q.logger.InfoContext(ctx, "quote submitted", "guild", m.GuildID, "submission", sub.ID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Thanks! Your quote is submission #%d, I'll let you know once a mod has reviewed it", sub.ID))
+}
+
+// ReviewQuotes lists what's waiting for review with an empty action, or approves or rejects submission id
+func (q Quotes) ReviewQuotes(ctx context.Context, s session.Session, m *disc.MessageCreate, action string, id int) {
+	if action == "" {
+		database, _ := q.Copy(m.GuildID)
+		if database == nil || len(database.Submissions) == 0 {
+			s.ChannelMessageSend(m.ChannelID, "No quotes are waiting for review")
+			return
+		}
+		var list strings.Builder
+		list.WriteString("**Quotes waiting for review**, use `!review approve <id>` or `!review reject <id>`")
+		for _, sub := range database.Submissions {
+			list.WriteString("\n" + sub.String())
+		}
+		s.ChannelMessageSend(m.ChannelID, list.String())
+		return
+	}
+
+	approve := strings.EqualFold(action, "approve")
+	if !approve && !strings.EqualFold(action, "reject") {
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, "You can approve or reject a submission, like `!review approve 3`", 10*time.Second)
+		return
+	}
+	// Taking it off the list and adding it happen together, so two mods can't approve the same one twice
+	var sub Submission
+	quoteID := -1
+	err := q.Update(func(databases *map[string]*QuoteDatabase) error {
+		database, ok := (*databases)[m.GuildID]
+		if !ok {
+			return errUnchanged
+		}
+		i := slices.IndexFunc(database.Submissions, func(waiting Submission) bool { return waiting.ID == id })
+		if i == -1 {
+			return errUnchanged
+		}
+		sub = database.Submissions[i]
+		database.Submissions = slices.Delete(database.Submissions, i, i+1)
+		if approve {
+			quoteID = database.add(sub.Quote, []string{}, sub.Author, sub.UserID, "", "")
+		}
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, fmt.Sprintf("There's no submission #%d waiting for review", id), 10*time.Second)
+		return
+	}
+	if err != nil {
+		q.logger.ErrorContext(ctx, "could not review a quote submission", "guild", m.GuildID, "submission", id, "err", err)
+		util.SendSelfDestructingMessage(s, q.deletions, m.ChannelID, fmt.Sprintf("I couldn't review submission #%d, try again in a bit", id), 10*time.Second)
+		return
+	}
+
+	if !approve {
+		q.logger.InfoContext(ctx, "quote submission rejected", "guild", m.GuildID, "submission", sub.ID)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Rejected submission #%d", sub.ID))
+		q.tellSubmitter(s, sub, fmt.Sprintf("Your quote submission #%d wasn't added, sorry: %s -%s", sub.ID, sub.Quote, sub.Author))
+		return
+	}
+	q.logger.InfoContext(ctx, "quote submission approved", "guild", m.GuildID, "submission", sub.ID, "quote", quoteID)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added submission #%d as quote #%d", sub.ID, quoteID))
+	q.tellSubmitter(s, sub, fmt.Sprintf("Your quote submission #%d was added as quote #%d!", sub.ID, quoteID))
+}
+
+// tellSubmitter DMs whoever sent sub in, they might have DMs turned off so it's fine if it doesn't get there
+func (q Quotes) tellSubmitter(s session.Session, sub Submission, content string) {
+	channel, err := s.UserChannelCreate(sub.SubmittedBy)
+	if err == nil {
+		_, err = s.ChannelMessageSend(channel.ID, content)
+	}
+	if err != nil {
+		q.logger.Warn("couldn't tell someone about their quote submission", "user", sub.SubmittedBy, "submission", sub.ID, "err", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package report catches handler panics so one bad message can't take the whole bot down, and tells someone about it
+package report
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"MelvinBot/src/config"
+	"MelvinBot/src/cooldown"
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+)
+
+var logger = logging.For("report")
+
+// Discord won't take a message longer than this
+const maxMessageLength = 2000
+
+// Where is whatever we know about where a failure happened, any of it can be empty
+type Where struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+}
+
+type Failure struct {
+	Time   time.Time
+	Source string // The handler or job, like !quote or nisha.Tetazoo
+	Where  Where
+	Err    string
+	Stack  string
+}
+
+type Reporter struct {
+	s       session.Session
+	cfg     config.Errors
+	limiter *cooldown.Limiter
+
+	lock   sync.Mutex
+	recent []Failure // Oldest first, at most cfg.Keep
+}
+
+// s is what reports are sent with, it isn't tied to any one event
+func New(s session.Session, cfg config.Errors) *Reporter {
+	return &Reporter{
+		s:       s,
+		cfg:     cfg,
+		limiter: cooldown.New(map[string]config.Cooldown{"report": {Channel: cfg.Rate}}),
+	}
+}
+
+// Recover has to be deferred directly, it stops a panic and reports it instead.
+// A nil Reporter still recovers so nothing needs checking before deferring it
+func (r *Reporter) Recover(source string, where Where) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	r.Report(Failure{
+		Time:   time.Now(),
+		Source: source,
+		Where:  where,
+		Err:    fmt.Sprintf("panic: %v", recovered),
+		Stack:  string(debug.Stack()),
+	})
+}
+
+// Report remembers a failure, logs it, and sends it on unless that source has been reported too much lately
+func (r *Reporter) Report(f Failure) {
+	logger.Error("handler failed", "source", f.Source, "guild", f.Where.GuildID, "channel", f.Where.ChannelID, "user", f.Where.UserID, "err", f.Err, "stack", f.Stack)
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	r.recent = append(r.recent, f)
+	if len(r.recent) > r.cfg.Keep {
+		r.recent = r.recent[len(r.recent)-r.cfg.Keep:]
+	}
+	r.lock.Unlock()
+
+	if r.cfg.ChannelID == "" && r.cfg.UserID == "" {
+		return
+	}
+	if ok, _ := r.limiter.Allow("report", "", f.Source); !ok {
+		return
+	}
+	go r.send(f)
+}
+
+func (r *Reporter) send(f Failure) {
+	content := Format(f, true)
+	if r.cfg.ChannelID != "" {
+		_, err := r.s.ChannelMessageSend(r.cfg.ChannelID, content)
+		if err != nil {
+			logger.Error("could not report error to channel", "channel", r.cfg.ChannelID, "err", err)
+		}
+	}
+	if r.cfg.UserID != "" {
+		dm, err := r.s.UserChannelCreate(r.cfg.UserID)
+		if err != nil {
+			logger.Error("could not DM error report", "user", r.cfg.UserID, "err", err)
+			return
+		}
+		_, err = r.s.ChannelMessageSend(dm.ID, content)
+		if err != nil {
+			logger.Error("could not DM error report", "user", r.cfg.UserID, "err", err)
+		}
+	}
+}
+
+// Recent is the last n failures newest first, only from guildID unless it's empty
+func (r *Reporter) Recent(guildID string, n int) []Failure {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	failures := []Failure{}
+	for i := len(r.recent) - 1; i >= 0 && len(failures) < n; i-- {
+		if guildID == "" || r.recent[i].Where.GuildID == guildID {
+			failures = append(failures, r.recent[i])
+		}
+	}
+	return failures
+}
+
+// Owner is whoever error reports get DM'd to
+func (r *Reporter) Owner() string {
+	if r == nil {
+		return ""
+	}
+	return r.cfg.UserID
+}
+
+// Format renders a failure for Discord, cutting the stack short so it fits in one message
+func Format(f Failure, withStack bool) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("**%s** failed at %s", f.Source, f.Time.Format(time.RFC3339)))
+	if f.Where.GuildID != "" {
+		msg.WriteString(fmt.Sprintf(" in guild %s", f.Where.GuildID))
+	}
+	if f.Where.ChannelID != "" {
+		msg.WriteString(fmt.Sprintf(" <#%s>", f.Where.ChannelID))
+	}
+	if f.Where.UserID != "" {
+		msg.WriteString(fmt.Sprintf(" for user %s", f.Where.UserID))
+	}
+	msg.WriteString(fmt.Sprintf("\n`%s`", f.Err))
+
+	if withStack && f.Stack != "" {
+		room := maxMessageLength - msg.Len() - len("\n```\n```")
+		stack := f.Stack
+		if len(stack) > room {
+			stack = stack[:max(room, 0)]
+		}
+		msg.WriteString("\n```\n" + stack + "```")
+	}
+	return msg.String()
+}
@@ -0,0 +1,259 @@
+// Package scheduler runs every scheduled job on one cron, each in its own timezone. Whether a job is paused or
+// deleted and when it last ran are saved, so they survive a restart and a run missed while we were down still happens
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // So timezones work on hosts without a zoneinfo database
+
+	"MelvinBot/src/logging"
+	"MelvinBot/src/store"
+
+	cron "github.com/robfig/cron"
+)
+
+var logger = logging.For("scheduler")
+
+// State is everything saved about a job
+type State struct {
+	Paused  bool
+	Deleted bool
+	LastRun time.Time
+}
+
+// Info is a job as it's shown to admins
+type Info struct {
+	Name     string
+	Spec     string
+	Timezone string
+	Next     time.Time // Zero if it won't run on its own
+	State
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	location *time.Location
+	run      func(ctx context.Context)
+}
+
+// inZone makes a cron schedule work in location, whatever timezone the cron itself is in
+type inZone struct {
+	cron.Schedule
+	location *time.Location
+}
+
+func (z inZone) Next(t time.Time) time.Time {
+	return z.Schedule.Next(t.In(z.location))
+}
+
+type Scheduler struct {
+	// states is saved, keyed by job name. Jobs that no longer exist keep theirs in case they come back
+	states *store.Store[map[string]State]
+	// Clock is time.Now unless something needs its own idea of now
+	Clock func() time.Time
+
+	lock sync.Mutex
+	jobs map[string]*job // Keyed by lowercase name
+	cron *cron.Cron
+	// run is how jobs get run, nil until Start
+	run func(name string, f func(ctx context.Context)) bool
+}
+
+// Store is every job's state, for the bot to keep in a file
+func (s *Scheduler) Store() store.Persistent {
+	return s.states
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		states: store.New(map[string]State{}),
+		Clock:  time.Now,
+		jobs:   map[string]*job{},
+		cron:   cron.New(),
+	}
+}
+
+// Add schedules run with a cron spec that has seconds, like "0 0 8 * * *" for 8AM every day in timezone.
+// timezone is an IANA name like America/Los_Angeles
+func (s *Scheduler) Add(name string, spec string, timezone string, run func(ctx context.Context)) error {
+	if timezone == "" {
+		return fmt.Errorf("job %s has no timezone", name)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("job %s has a bad timezone: %w", name, err)
+	}
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return fmt.Errorf("job %s has a bad schedule: %w", name, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := strings.ToLower(name)
+	if _, ok := s.jobs[key]; ok {
+		return fmt.Errorf("job %s is scheduled twice", name)
+	}
+	j := &job{name: name, spec: spec, schedule: inZone{schedule, location}, location: location, run: run}
+	s.jobs[key] = j
+	s.cron.Schedule(j.schedule, cron.FuncJob(func() { s.fire(j, false) }))
+	return nil
+}
+
+// Start runs anything that was missed while we were down, then everything on schedule. run should be tracked
+// work that shutdown waits for
+func (s *Scheduler) Start(run func(name string, f func(ctx context.Context)) bool) {
+	s.lock.Lock()
+	s.run = run
+	now := s.Clock()
+	missed := []*job{}
+	s.states.View(func(states map[string]State) {
+		for _, j := range s.jobs {
+			state := states[j.name]
+			if !state.LastRun.IsZero() && j.schedule.Next(state.LastRun).Before(now) {
+				missed = append(missed, j)
+			}
+		}
+	})
+	s.lock.Unlock()
+
+	for _, j := range missed {
+		logger.Info("running a job missed while we were down", "job", j.name)
+		s.fire(j, false)
+	}
+	s.cron.Start()
+}
+
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// fire runs j unless it's paused or deleted, triggering it by hand only skips deleted jobs
+func (s *Scheduler) fire(j *job, manual bool) bool {
+	s.lock.Lock()
+	run := s.run
+	s.lock.Unlock()
+	if run == nil {
+		return false
+	}
+	skip := s.change(j.name, func(state *State) error {
+		if state.Deleted || (state.Paused && !manual) {
+			return errSkipped
+		}
+		state.LastRun = s.Clock()
+		return nil
+	})
+	if skip != nil {
+		return false
+	}
+	return run(j.name, j.run)
+}
+
+// List is every job that hasn't been deleted sorted by name, or every job at all with deleted
+func (s *Scheduler) List(deleted bool) []Info {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.Clock()
+	infos := []Info{}
+	s.states.View(func(states map[string]State) {
+		for _, j := range s.jobs {
+			state := states[j.name]
+			if state.Deleted && !deleted {
+				continue
+			}
+			info := Info{Name: j.name, Spec: j.spec, Timezone: j.location.String(), State: state}
+			if !state.Paused && !state.Deleted {
+				info.Next = j.schedule.Next(now).In(j.location)
+			}
+			infos = append(infos, info)
+		}
+	})
+	sort.Slice(infos, func(i, k int) bool {
+		return infos[i].Name < infos[k].Name
+	})
+	return infos
+}
+
+// errSkipped leaves a state as it was
+var errSkipped = errors.New("skipped")
+
+// change updates a job's saved state, unless change returns an error
+func (s *Scheduler) change(name string, change func(state *State) error) error {
+	return s.states.Update(func(states *map[string]State) error {
+		if *states == nil {
+			*states = map[string]State{}
+		}
+		state := (*states)[name]
+		err := change(&state)
+		if err != nil {
+			return err
+		}
+		(*states)[name] = state
+		return nil
+	})
+}
+
+func (s *Scheduler) lookup(name string) (*job, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	j, ok := s.jobs[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("there is no job called %s", name)
+	}
+	return j, nil
+}
+
+// Pause stops a job running on its own until it's resumed, it can still be triggered
+func (s *Scheduler) Pause(name string, paused bool) error {
+	j, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	return s.change(j.name, func(state *State) error {
+		if state.Deleted {
+			return fmt.Errorf("%s is deleted, restore it first", name)
+		}
+		state.Paused = paused
+		return nil
+	})
+}
+
+// Delete stops a job for good, even across restarts, until it's restored
+func (s *Scheduler) Delete(name string, deleted bool) error {
+	j, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	return s.change(j.name, func(state *State) error {
+		state.Deleted = deleted
+		return nil
+	})
+}
+
+// Trigger runs a job now, paused or not
+func (s *Scheduler) Trigger(name string) error {
+	j, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	s.states.View(func(states map[string]State) {
+		deleted = states[j.name].Deleted
+	})
+	if deleted {
+		return fmt.Errorf("%s is deleted, restore it first", name)
+	}
+	if !s.fire(j, true) {
+		return fmt.Errorf("nothing can run right now")
+	}
+	return nil
+}
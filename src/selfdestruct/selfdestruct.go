@@ -0,0 +1,241 @@
+// Package selfdestruct deletes messages once they've been up long enough. Deadlines are saved, so a message due while
+// the bot was down is deleted when it comes back instead of staying forever
+package selfdestruct
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+	"MelvinBot/src/store"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+var logger = logging.For("selfdestruct")
+
+var (
+	deleted = metrics.NewCounter("melvin_selfdestruct_deleted_total", "Self destructing messages deleted, by whether it worked.", "outcome")
+	waiting = metrics.NewGauge("melvin_selfdestruct_pending", "Self destructing messages waiting to be deleted.")
+)
+
+const (
+	// How often Run looks for messages that are due
+	tick = time.Second
+	// Discord won't bulk delete more than this at once, or anything older than bulkMaxAge
+	bulkMax    = 100
+	bulkMaxAge = 14 * 24 * time.Hour
+	// A delete that failed on Discord's end is tried again this much later, up to retries times
+	retryAfter = time.Minute
+	retries    = 5
+)
+
+// Deletion is a message waiting to be deleted
+type Deletion struct {
+	ChannelID string
+	MessageID string
+	At        time.Time
+	SentAt    time.Time // Bulk delete only works on messages younger than two weeks
+	Failures  int
+}
+
+// Queue is every message waiting to be deleted keyed by message ID, in a store so the bot can keep it in a file
+type Queue struct {
+	*store.Store[map[string]Deletion]
+	// clock is time.Now unless something like replay needs its own idea of now, it's shared by every copy
+	clock *atomic.Pointer[func() time.Time]
+}
+
+func New() Queue {
+	q := Queue{Store: store.New(map[string]Deletion{}), clock: &atomic.Pointer[func() time.Time]{}}
+	q.SetClock(time.Now)
+	return q
+}
+
+// SetClock changes what the queue thinks now is, for deadlines and for what's due
+func (q Queue) SetClock(now func() time.Time) {
+	q.clock.Store(&now)
+}
+
+func (q Queue) now() time.Time {
+	return (*q.clock.Load())()
+}
+
+// queue changes the pending deletions, making the map if the file had none
+func (q Queue) queue(change func(byMessage map[string]Deletion)) {
+	q.Update(func(byMessage *map[string]Deletion) error {
+		if *byMessage == nil {
+			*byMessage = map[string]Deletion{}
+		}
+		change(*byMessage)
+		waiting.Set(float64(len(*byMessage)))
+		return nil
+	})
+}
+
+// After deletes a message once after has passed
+func (q Queue) After(channelID string, messageID string, after time.Duration) {
+	now := q.now()
+	q.queue(func(byMessage map[string]Deletion) {
+		byMessage[messageID] = Deletion{ChannelID: channelID, MessageID: messageID, At: now.Add(after), SentAt: now}
+	})
+}
+
+// Cancel keeps a message after all, it's false if the message wasn't going to be deleted
+func (q Queue) Cancel(messageID string) bool {
+	ok := false
+	q.queue(func(byMessage map[string]Deletion) {
+		_, ok = byMessage[messageID]
+		delete(byMessage, messageID)
+	})
+	return ok
+}
+
+// Extend pushes a message's deletion back by more, it's false if the message wasn't going to be deleted
+func (q Queue) Extend(messageID string, more time.Duration) bool {
+	ok := false
+	q.queue(func(byMessage map[string]Deletion) {
+		var deletion Deletion
+		deletion, ok = byMessage[messageID]
+		if ok {
+			deletion.At = deletion.At.Add(more)
+			byMessage[messageID] = deletion
+		}
+	})
+	return ok
+}
+
+// due takes every deletion that's due off the queue, grouped by channel
+func (q Queue) due(now time.Time) map[string][]Deletion {
+	channels := map[string][]Deletion{}
+	// Only changed once there's something to take, so a quiet tick doesn't need writing out
+	anyDue := false
+	q.View(func(byMessage map[string]Deletion) {
+		for _, deletion := range byMessage {
+			if !deletion.At.After(now) {
+				anyDue = true
+				break
+			}
+		}
+		waiting.Set(float64(len(byMessage)))
+	})
+	if !anyDue {
+		return channels
+	}
+	q.queue(func(byMessage map[string]Deletion) {
+		for id, deletion := range byMessage {
+			if deletion.At.After(now) {
+				continue
+			}
+			channels[deletion.ChannelID] = append(channels[deletion.ChannelID], deletion)
+			delete(byMessage, id)
+		}
+	})
+	return channels
+}
+
+// Next is when the soonest deletion is due, it's false if nothing is waiting
+func (q Queue) Next() (time.Time, bool) {
+	var next time.Time
+	q.View(func(byMessage map[string]Deletion) {
+		for _, deletion := range byMessage {
+			if next.IsZero() || deletion.At.Before(next) {
+				next = deletion.At
+			}
+		}
+	})
+	return next, !next.IsZero()
+}
+
+// DeleteDue deletes every message that's due by the queue's clock
+func (q Queue) DeleteDue(ctx context.Context, s session.Session) {
+	now := q.now()
+	for channelID, deletions := range q.due(now) {
+		q.deleteAll(ctx, s, channelID, deletions, now)
+	}
+}
+
+// Run deletes messages as they come due until ctx is done, starting with any that came due while we were down
+func (q Queue) Run(ctx context.Context, s session.Session) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		q.DeleteDue(ctx, s)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteAll bulk deletes whatever it can and deletes the rest one at a time
+func (q Queue) deleteAll(ctx context.Context, s session.Session, channelID string, deletions []Deletion, now time.Time) {
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].At.Before(deletions[j].At)
+	})
+	bulk := []Deletion{}
+	for _, deletion := range deletions {
+		if now.Sub(deletion.SentAt) < bulkMaxAge {
+			bulk = append(bulk, deletion)
+			continue
+		}
+		q.deleteOne(ctx, s, deletion, now)
+	}
+
+	for len(bulk) > 0 {
+		batch := bulk[:min(len(bulk), bulkMax)]
+		bulk = bulk[len(batch):]
+		if len(batch) == 1 {
+			q.deleteOne(ctx, s, batch[0], now)
+			continue
+		}
+		ids := []string{}
+		for _, deletion := range batch {
+			ids = append(ids, deletion.MessageID)
+		}
+		err := s.ChannelMessagesBulkDelete(channelID, ids)
+		if err == nil {
+			deleted.Add(float64(len(batch)), "ok")
+			continue
+		}
+		// Bulk delete fails outright if any message is already gone, so find out which ones by going one at a time
+		logger.DebugContext(ctx, "bulk delete failed, deleting one at a time", "channel", channelID, "messages", len(batch), "err", err)
+		for _, deletion := range batch {
+			q.deleteOne(ctx, s, deletion, now)
+		}
+	}
+}
+
+func (q Queue) deleteOne(ctx context.Context, s session.Session, deletion Deletion, now time.Time) {
+	err := s.ChannelMessageDelete(deletion.ChannelID, deletion.MessageID)
+	if err == nil {
+		deleted.Inc("ok")
+		return
+	}
+	var rest *disc.RESTError
+	if errors.As(err, &rest) && rest.Response != nil && rest.Response.StatusCode == http.StatusNotFound {
+		// Someone beat us to it
+		deleted.Inc("gone")
+		return
+	}
+
+	deletion.Failures++
+	if deletion.Failures >= retries || (rest != nil && rest.Response != nil && rest.Response.StatusCode < 500) {
+		deleted.Inc("failed")
+		logger.ErrorContext(ctx, "failed to delete message", "channel", deletion.ChannelID, "message", deletion.MessageID, "failures", deletion.Failures, "err", err)
+		return
+	}
+	logger.WarnContext(ctx, "failed to delete message, trying again later", "channel", deletion.ChannelID, "message", deletion.MessageID, "in", retryAfter, "err", err)
+	deletion.At = now.Add(retryAfter)
+	q.queue(func(byMessage map[string]Deletion) {
+		byMessage[deletion.MessageID] = deletion
+	})
+}
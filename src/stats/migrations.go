@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"MelvinBot/src/store"
+)
+
+func init() {
+	store.Register(store.Schema{Name: "stats", Migrations: []store.Migration{
+		{Description: "give every guild a StatMap", Run: fillStats},
+		{Description: "count posts by user ID instead of username", Run: countByID},
+	}})
+}
+
+// fillStats covers files from before every guild was saved with a StatMap, loading one of those used to leave it nil
+func fillStats(data json.RawMessage) (json.RawMessage, string, error) {
+	var guilds map[string]map[string]any
+	err := json.Unmarshal(data, &guilds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filled := 0
+	for guildID, guildStats := range guilds {
+		if guildStats == nil {
+			delete(guilds, guildID)
+			continue
+		}
+		if guildStats["StatMap"] == nil {
+			guildStats["StatMap"] = map[string]any{}
+			filled++
+		}
+	}
+
+	migrated, err := json.Marshal(guilds)
+	return migrated, fmt.Sprintf("filled in %d of %d guild(s)", filled, len(guilds)), err
+}
+
+// countByID sets the old counts aside under ByName, since a file has no way of telling us whose ID a username was.
+// TrackStats moves each one onto an ID the next time someone posts under that name
+func countByID(data json.RawMessage) (json.RawMessage, string, error) {
+	var guilds map[string]map[string]any
+	err := json.Unmarshal(data, &guilds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	moved := 0
+	for _, guildStats := range guilds {
+		byName, _ := guildStats["StatMap"].(map[string]any)
+		if byName == nil {
+			byName = map[string]any{}
+		}
+		moved += len(byName)
+		guildStats["ByName"] = byName
+		guildStats["StatMap"] = map[string]any{}
+		guildStats["Names"] = map[string]any{}
+	}
+
+	migrated, err := json.Marshal(guilds)
+	return migrated, fmt.Sprintf("set aside %d username count(s) to be matched to IDs as people post", moved), err
+}
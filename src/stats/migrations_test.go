@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/store"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+func TestFillStats(t *testing.T) {
+	migrated, _, err := fillStats(json.RawMessage(`{"a":{"StatMap":{"alice":3}},"b":{},"c":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[string]map[string]int
+	if err := json.Unmarshal(migrated, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]map[string]int{
+		"a": {"StatMap": {"alice": 3}},
+		"b": {"StatMap": {}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCountByID(t *testing.T) {
+	migrated, _, err := countByID(json.RawMessage(`{"a":{"StatMap":{"alice":3,"bob":1}},"b":{"StatMap":{}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]*Stats
+	if err := json.Unmarshal(migrated, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*Stats{
+		"a": {StatMap: map[string]int{}, Names: map[string]string{}, ByName: map[string]int{"alice": 3, "bob": 1}},
+		"b": {StatMap: map[string]int{}, Names: map[string]string{}, ByName: map[string]int{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// A file from before versions loads with its counts set aside, and each moves onto an ID when that name posts
+func TestLegacyStats(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "stats")
+	if err := os.WriteFile(filename, []byte(`{"g":{"StatMap":{"alice":3,"bob":1}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	storage, err := c.Open("stats", store.Backups{}, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	board, ok := c.Leaderboard("g")
+	if want := []Posts{{Name: "alice", Posts: 3}, {Name: "bob", Posts: 1}}; !ok || !reflect.DeepEqual(board, want) {
+		t.Errorf("before posting got %v, want %v", board, want)
+	}
+
+	m := &disc.MessageCreate{Message: &disc.Message{ID: "100", GuildID: "g", Author: &disc.User{ID: "30", Username: "alice"}}}
+	c.TrackStats(context.Background(), session.NewFake("bot"), m)
+
+	c.View(func(perGuild map[string]*Stats) {
+		guildStats := perGuild["g"]
+		if guildStats.StatMap["30"] != 4 || guildStats.Names["30"] != "alice" {
+			t.Errorf("alice's ID has %d posts as %q, want 4 as alice", guildStats.StatMap["30"], guildStats.Names["30"])
+		}
+		if _, ok := guildStats.ByName["alice"]; ok {
+			t.Error("alice's old count is still set aside")
+		}
+		if guildStats.ByName["bob"] != 1 {
+			t.Error("bob's old count moved without him posting")
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"context"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/module"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Module counts everyone's posts for !stats
+type Module struct {
+	module.Base
+	Counts Counts
+}
+
+func (*Module) Name() string {
+	return "stats"
+}
+
+func (st *Module) Init(deps module.Deps) error {
+	_, err := deps.Storage("stats", st.Counts, deps.Config.Storage.StatsFile)
+	return err
+}
+
+func (st *Module) Handlers() module.Handlers {
+	return module.Handlers{
+		Message:       []module.Handler[*disc.MessageCreate]{{Feature: "stats", Run: st.Counts.TrackStats}},
+		MessageDelete: []module.Handler[*disc.MessageDelete]{{Feature: "stats", Run: st.Counts.UntrackStats}},
+	}
+}
+
+func (st *Module) Commands() []*module.Command {
+	return []*module.Command{
+		{
+			Name:        "stats",
+			Description: "Shows who has posted the most in this server",
+			Feature:     "stats",
+			Slash:       true,
+			DM:          true,
+			Run: func(ctx context.Context, s session.Session, m *disc.MessageCreate, args module.Args) {
+				st.Counts.PrintStats(ctx, s, m)
+			},
+		},
+	}
+}
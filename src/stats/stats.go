@@ -1,86 +1,172 @@
 package stats
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
 
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/store"
+
 	disc "github.com/bwmarrin/discordgo"
 )
 
 type Stats struct {
-	StatMap map[string]int
-	Lock    *sync.Mutex
+	StatMap map[string]int    // User ID to posts
+	Names   map[string]string // User ID to the username they last posted as
+	// ByName is posts counted by username before we kept IDs. A name's count moves onto their ID when they next post
+	ByName map[string]int
 }
 
-var StatsPerGuild map[string]*Stats = map[string]*Stats{}
+// Counts is every guild's Stats keyed by guild ID, in a store so the bot can keep it in a file
+type Counts struct {
+	*store.Store[map[string]*Stats]
+	counted *counted
+}
 
-func TrackStats(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
-		return // it me
-	}
+func New() Counts {
+	return Counts{store.New(map[string]*Stats{}), &counted{authors: map[string]string{}}}
+}
 
-	guildStats, ok := StatsPerGuild[m.GuildID]
-	if !ok {
-		guildStats = &Stats{
-			StatMap: map[string]int{},
-			Lock:    &sync.Mutex{},
+// Guilds is every guild we've counted posts in
+func (c Counts) Guilds() []string {
+	guilds := []string{}
+	c.View(func(perGuild map[string]*Stats) {
+		for guildID := range perGuild {
+			guilds = append(guilds, guildID)
 		}
-		// What are the chances this races
-		StatsPerGuild[m.GuildID] = guildStats
-	}
+	})
+	return guilds
+}
 
-	guildStats.Lock.Lock()
-	defer guildStats.Lock.Unlock()
+// How many of the latest messages we remember the author of, so deleting one takes it back off their count.
+// Older messages, and anything from before a restart, stay counted
+const keepCounted = 10000
+
+// counted isn't persisted, it's only what this run of the bot has seen
+type counted struct {
+	lock    sync.Mutex
+	authors map[string]string // Message ID to user ID
+	order   []string          // Message IDs, oldest first
+}
 
-	_, ok = guildStats.StatMap[m.Author.Username]
-	if ok {
-		guildStats.StatMap[m.Author.Username]++
-	} else {
-		guildStats.StatMap[m.Author.Username] = 1
+func (c *counted) remember(messageID string, userID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.authors[messageID] = userID
+	c.order = append(c.order, messageID)
+	if len(c.order) > keepCounted {
+		delete(c.authors, c.order[0])
+		c.order = c.order[1:]
 	}
 }
 
-func PrintStats(s *disc.Session, m *disc.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+// forget is who sent messageID if we still remember, it won't be remembered again
+func (c *counted) forget(messageID string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	userID, ok := c.authors[messageID]
+	delete(c.authors, messageID)
+	return userID, ok
+}
+
+func (c Counts) TrackStats(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	if m.Author.ID == s.BotUserID() {
 		return // it me
 	}
 
-	if m.Content != "!stats" {
-		return
-	}
+	c.Update(func(perGuild *map[string]*Stats) error {
+		if *perGuild == nil {
+			*perGuild = map[string]*Stats{}
+		}
+		guildStats, ok := (*perGuild)[m.GuildID]
+		if !ok {
+			guildStats = &Stats{}
+			(*perGuild)[m.GuildID] = guildStats
+		}
+		if guildStats.StatMap == nil {
+			guildStats.StatMap = map[string]int{}
+		}
+		if guildStats.Names == nil {
+			guildStats.Names = map[string]string{}
+		}
+		if posts, ok := guildStats.ByName[m.Author.Username]; ok {
+			guildStats.StatMap[m.Author.ID] += posts
+			delete(guildStats.ByName, m.Author.Username)
+		}
+		guildStats.StatMap[m.Author.ID]++
+		guildStats.Names[m.Author.ID] = m.Author.Username
+		return nil
+	})
+	c.counted.remember(m.ID, m.Author.ID)
+}
 
-	guildStats, ok := StatsPerGuild[m.GuildID]
+func (c Counts) UntrackStats(ctx context.Context, s session.Session, m *disc.MessageDelete) {
+	userID, ok := c.counted.forget(m.ID)
 	if !ok {
-		s.ChannelMessageSend(m.ChannelID, "Sorry I'm not tracking stats for this server")
 		return
 	}
 
-	guildStats.Lock.Lock()
-
-	// Sort and create stats array
-	type MelvinPosts struct {
-		name  string
-		posts int
-	}
+	c.Update(func(perGuild *map[string]*Stats) error {
+		guildStats, ok := (*perGuild)[m.GuildID]
+		if ok && guildStats.StatMap[userID] > 0 {
+			guildStats.StatMap[userID]--
+		}
+		return nil
+	})
+}
 
-	sortable := []MelvinPosts{}
-	for username, posts := range guildStats.StatMap {
+type Posts struct {
+	Name  string
+	Posts int
+}
 
-		sortable = append(sortable, MelvinPosts{name: username, posts: posts})
+// Leaderboard is everyone in guildID by how much they've posted, most first. ok is false if we aren't tracking it
+func (c Counts) Leaderboard(guildID string) (board []Posts, ok bool) {
+	sortable := []Posts{}
+	c.View(func(perGuild map[string]*Stats) {
+		var guildStats *Stats
+		guildStats, ok = perGuild[guildID]
+		if !ok {
+			return
+		}
+		for userID, posts := range guildStats.StatMap {
+			name, ok := guildStats.Names[userID]
+			if !ok {
+				name = userID
+			}
+			sortable = append(sortable, Posts{Name: name, Posts: posts})
+		}
+		// Anyone who hasn't posted since we switched to IDs
+		for username, posts := range guildStats.ByName {
+			sortable = append(sortable, Posts{Name: username, Posts: posts})
+		}
+	})
+	if !ok {
+		return nil, false
 	}
-	// Don't need lock anymore
-	guildStats.Lock.Unlock()
 
 	sort.Slice(sortable, func(i, j int) bool {
-		return sortable[i].posts > sortable[j].posts
+		return sortable[i].Posts > sortable[j].Posts
 	})
+	return sortable, true
+}
+
+func (c Counts) PrintStats(ctx context.Context, s session.Session, m *disc.MessageCreate) {
+	sortable, ok := c.Leaderboard(m.GuildID)
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, "Sorry I'm not tracking stats for this server")
+		return
+	}
 
 	var statsMessage strings.Builder
 	statsMessage.WriteString("Melvin Posts Leaderboard:")
 	for _, message := range sortable {
-		statsMessage.WriteString(fmt.Sprintf("\n%s : %d", message.name, message.posts))
+		statsMessage.WriteString(fmt.Sprintf("\n%s : %d", message.Name, message.Posts))
 	}
 
 	s.ChannelMessageSend(m.ChannelID, statsMessage.String())
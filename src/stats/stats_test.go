@@ -0,0 +1,38 @@
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"MelvinBot/src/discord/session"
+
+	disc "github.com/bwmarrin/discordgo"
+)
+
+// Deleting a message takes it off the count of whichever Counts saw it, and nobody else's
+func TestUntrackStats(t *testing.T) {
+	s := session.NewFake("bot")
+	c, other := New(), New()
+	for _, id := range []string{"100", "101"} {
+		m := &disc.MessageCreate{Message: &disc.Message{ID: id, GuildID: "g", Author: &disc.User{ID: "30", Username: "alice"}}}
+		c.TrackStats(context.Background(), s, m)
+		other.TrackStats(context.Background(), s, m)
+	}
+
+	c.UntrackStats(context.Background(), s, &disc.MessageDelete{Message: &disc.Message{ID: "100", GuildID: "g"}})
+	// Already taken off, and never seen
+	c.UntrackStats(context.Background(), s, &disc.MessageDelete{Message: &disc.Message{ID: "100", GuildID: "g"}})
+	c.UntrackStats(context.Background(), s, &disc.MessageDelete{Message: &disc.Message{ID: "999", GuildID: "g"}})
+
+	for _, test := range []struct {
+		name   string
+		counts Counts
+		want   int
+	}{{"deleted from", c, 1}, {"other", other, 2}} {
+		test.counts.View(func(perGuild map[string]*Stats) {
+			if got := perGuild["g"].StatMap["30"]; got != test.want {
+				t.Errorf("%s counts %d posts, want %d", test.name, got, test.want)
+			}
+		})
+	}
+}
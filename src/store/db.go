@@ -0,0 +1,338 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// versions is the bucket holding which version of its schema each store in a DB is at
+const versions = "versions"
+
+// DB is an embedded database to keep stores in instead of files. Each store gets a bucket split into records by its
+// Schema's Layout, and a sync only writes the records that changed since the last one. A sync still marshals and
+// checksums the whole store to find out which those are, it's the disk writes that shrink
+type DB struct {
+	bolt *bolt.DB
+	path string
+}
+
+// OpenDB opens the database at path, making it if it's not there. Only one process can have it open at a time
+func OpenDB(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is open somewhere else, is the bot still running?", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	return &DB{bolt: db, path: path}, nil
+}
+
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+// Has is whether name has ever been written to the database
+func (db *DB) Has(name string) (bool, error) {
+	has := false
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		has = tx.Bucket([]byte(versions)) != nil && tx.Bucket([]byte(versions)).Get([]byte(name)) != nil
+		return nil
+	})
+	return has, err
+}
+
+// Lookup reads one record of name's, it's false if there isn't one at path
+func (db *DB) Lookup(name string, path ...string) (json.RawMessage, bool, error) {
+	var value json.RawMessage
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		bucket := bucketAt(tx.Bucket([]byte(name)), path[:len(path)-1])
+		if bucket == nil {
+			return nil
+		}
+		if found := bucket.Get([]byte(path[len(path)-1])); found != nil {
+			value = bytes.Clone(found)
+		}
+		return nil
+	})
+	return value, value != nil, err
+}
+
+// Scan calls f on every record of name's in the bucket at path whose key starts with prefix, in key order
+func (db *DB) Scan(name string, path []string, prefix string, f func(key string, value json.RawMessage) error) error {
+	return db.bolt.View(func(tx *bolt.Tx) error {
+		bucket := bucketAt(tx.Bucket([]byte(name)), path)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+			if value == nil {
+				continue // A bucket
+			}
+			err := f(string(key), bytes.Clone(value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func bucketAt(bucket *bolt.Bucket, path []string) *bolt.Bucket {
+	for _, name := range path {
+		if bucket == nil {
+			return nil
+		}
+		bucket = bucket.Bucket([]byte(name))
+	}
+	return bucket
+}
+
+// Record is one piece of a store in a DB. Path is the buckets it's in and then its key
+type Record struct {
+	Path  []string
+	Value json.RawMessage
+}
+
+// Layout splits data into records and joins them back up. Join gets them sorted by path, and only ever sees records
+// Split made from the current version of the data, so a migration that changes its shape has to change Layout too
+type Layout struct {
+	Split func(data json.RawMessage) ([]Record, error)
+	Join  func(records []Record) (json.RawMessage, error)
+}
+
+// byKey is the Layout for anything without one, each top level key is a record
+var byKey = Layout{
+	Split: func(data json.RawMessage) ([]Record, error) {
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, err
+		}
+		records := []Record{}
+		for key, value := range fields {
+			records = append(records, Record{Path: []string{key}, Value: value})
+		}
+		return records, nil
+	},
+	Join: func(records []Record) (json.RawMessage, error) {
+		fields := map[string]json.RawMessage{}
+		for _, record := range records {
+			fields[record.Path[0]] = record.Value
+		}
+		return json.Marshal(fields)
+	},
+}
+
+func layoutFor(name string) Layout {
+	if layout := schemaFor(name).Layout; layout != nil {
+		return *layout
+	}
+	return byKey
+}
+
+// table is a store kept in a DB
+type table struct {
+	db   *DB
+	name string
+	// written is a checksum of every record as it was last read or written, nil until then. Anything the same as
+	// last time isn't written again
+	written  map[string][sha256.Size]byte
+	lastSync atomic.Pointer[time.Time]
+}
+
+func recordKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func (t *table) put(payload []byte) error {
+	start := time.Now()
+	err := t.write(payload)
+	putSeconds.Since(start, t.name)
+	if err != nil {
+		putFailures.Inc(t.name)
+		return err
+	}
+	t.synced()
+	return nil
+}
+
+func (t *table) write(payload []byte) error {
+	records, err := layoutFor(t.name).Split(payload)
+	if err != nil {
+		return fmt.Errorf("could not split %s into records: %w", t.name, err)
+	}
+	fresh := make(map[string][sha256.Size]byte, len(records))
+	for _, record := range records {
+		if len(record.Path) == 0 || slices.Contains(record.Path, "") {
+			return fmt.Errorf("%s has a record with an empty key", t.name)
+		}
+		fresh[recordKey(record.Path)] = sha256.Sum256(record.Value)
+	}
+
+	err = t.db.bolt.Update(func(tx *bolt.Tx) error {
+		// Without knowing what's there, the only way to be sure nothing stale is left is to start again
+		if t.written == nil && tx.Bucket([]byte(t.name)) != nil {
+			err := tx.DeleteBucket([]byte(t.name))
+			if err != nil {
+				return err
+			}
+		}
+		root, err := tx.CreateBucketIfNotExists([]byte(t.name))
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			sum, seen := t.written[recordKey(record.Path)]
+			if seen && sum == fresh[recordKey(record.Path)] {
+				continue
+			}
+			bucket := root
+			for _, name := range record.Path[:len(record.Path)-1] {
+				bucket, err = bucket.CreateBucketIfNotExists([]byte(name))
+				if err != nil {
+					return fmt.Errorf("could not make bucket %s: %w", name, err)
+				}
+			}
+			err = bucket.Put([]byte(record.Path[len(record.Path)-1]), record.Value)
+			if err != nil {
+				return fmt.Errorf("could not write %s: %w", strings.Join(record.Path, "/"), err)
+			}
+		}
+		for key := range t.written {
+			if _, ok := fresh[key]; ok {
+				continue
+			}
+			path := strings.Split(key, "\x00")
+			if bucket := bucketAt(root, path[:len(path)-1]); bucket != nil {
+				err = bucket.Delete([]byte(path[len(path)-1]))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		all, err := tx.CreateBucketIfNotExists([]byte(versions))
+		if err != nil {
+			return err
+		}
+		return all.Put([]byte(t.name), []byte(strconv.Itoa(schemaFor(t.name).Version())))
+	})
+	if err != nil {
+		return err
+	}
+	t.written = fresh
+	return nil
+}
+
+// read is every record and what version they're at, it's an os.ErrNotExist error if the store was never written
+func (t *table) read() ([]Record, int, error) {
+	records := []Record{}
+	version := 0
+	err := t.db.bolt.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket([]byte(versions))
+		if all == nil || all.Get([]byte(t.name)) == nil {
+			return fmt.Errorf("nothing in %s for %s yet: %w", t.db.path, t.name, os.ErrNotExist)
+		}
+		var err error
+		version, err = strconv.Atoi(string(all.Get([]byte(t.name))))
+		if err != nil {
+			return fmt.Errorf("bad version for %s: %w", t.name, err)
+		}
+		root := tx.Bucket([]byte(t.name))
+		if root == nil {
+			return nil
+		}
+		return walk(root, nil, func(path []string, value []byte) {
+			records = append(records, Record{Path: path, Value: bytes.Clone(value)})
+		})
+	})
+	return records, version, err
+}
+
+// walk visits every record in bucket and the buckets in it, in order
+func walk(bucket *bolt.Bucket, path []string, visit func(path []string, value []byte)) error {
+	return bucket.ForEach(func(key, value []byte) error {
+		here := append(append([]string{}, path...), string(key))
+		if value == nil {
+			return walk(bucket.Bucket(key), here, visit)
+		}
+		visit(here, value)
+		return nil
+	})
+}
+
+func (t *table) load() (json.RawMessage, []Step, map[string][sha256.Size]byte, error) {
+	records, version, err := t.read()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	joined, err := layoutFor(t.name).Join(records)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not put %s back together: %w", t.name, err)
+	}
+	payload, err := json.Marshal(envelope{Schema: t.name, Version: &version, Data: joined})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, steps, err := migrate(t.name, payload)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not migrate %s: %w", t.name, err)
+	}
+	written := make(map[string][sha256.Size]byte, len(records))
+	for _, record := range records {
+		written[recordKey(record.Path)] = sha256.Sum256(record.Value)
+	}
+	return data, steps, written, nil
+}
+
+func (t *table) get(unmarshal func(data json.RawMessage) error) (bool, error) {
+	data, steps, written, err := t.load()
+	if err != nil {
+		return false, err
+	}
+	err = unmarshal(data)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshaling %s, %v", t.name, err)
+	}
+	for _, step := range steps {
+		logger.Info("migrated", "store", t.name, "from", step.From, "to", step.From+1, "migration", step.Description, "changes", step.Summary)
+	}
+	t.written = written
+	t.synced()
+	return len(steps) > 0, nil
+}
+
+func (t *table) plan() ([]Step, error) {
+	_, steps, _, err := t.load()
+	return steps, err
+}
+
+func (t *table) synced() {
+	now := time.Now()
+	t.lastSync.Store(&now)
+	lastSynced.Set(float64(now.Unix()), t.name)
+}
+
+func (t *table) last() time.Time {
+	if last := t.lastSync.Load(); last != nil {
+		return *last
+	}
+	return time.Time{}
+}
+
+func (t *table) where() string {
+	return t.db.path + ":" + t.name
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func openTable(t *testing.T, db *DB, data map[string]int) *Store[map[string]int] {
+	t.Helper()
+	s := New(data)
+	if _, err := s.OpenIn(db, "db test"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// poke writes a record behind the store's back, so we can tell whether a put writes over it
+func poke(t *testing.T, db *DB, key string, value string) {
+	t.Helper()
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("db test")).Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stored is every record in the store's bucket
+func stored(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	all := map[string]string{}
+	err := db.Scan("db test", nil, "", func(key string, value json.RawMessage) error {
+		all[key] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestDBSync(t *testing.T) {
+	db := openDB(t)
+	s := openTable(t, db, map[string]int{"a": 1, "b": 2})
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored(t, db), map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first put stored %v, want %v", got, want)
+	}
+
+	// Only b changed, so a isn't written again and keeps what we poked into it
+	poke(t, db, "a", "99")
+	s.Update(func(data *map[string]int) error {
+		(*data)["b"] = 3
+		return nil
+	})
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored(t, db), map[string]string{"a": "99", "b": "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second put stored %v, want %v", got, want)
+	}
+
+	s.Update(func(data *map[string]int) error {
+		delete(*data, "b")
+		return nil
+	})
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored(t, db), map[string]string{"a": "99"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after removing b stored %v, want %v", got, want)
+	}
+
+	// A store that's never read or written the table doesn't know what's there, so it starts the bucket over
+	poke(t, db, "stale", "1")
+	fresh := openTable(t, db, map[string]int{"c": 4})
+	if err := fresh.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored(t, db), map[string]string{"c": "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("a fresh put stored %v, want %v", got, want)
+	}
+}
+
+func TestDBGet(t *testing.T) {
+	db := openDB(t)
+	empty := openTable(t, db, map[string]int{})
+	if err := empty.Get(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("getting before anything was put got %v, want os.ErrNotExist", err)
+	}
+	if has, err := db.Has("db test"); has || err != nil {
+		t.Fatalf("Has is %v, %v before anything was put", has, err)
+	}
+
+	s := openTable(t, db, map[string]int{"a": 1, "b": 2})
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := db.Has("db test"); !has || err != nil {
+		t.Fatalf("Has is %v, %v after a put", has, err)
+	}
+
+	loaded := openTable(t, db, map[string]int{})
+	if err := loaded.Get(); err != nil {
+		t.Fatal(err)
+	}
+	loaded.View(func(data map[string]int) {
+		if want := map[string]int{"a": 1, "b": 2}; !reflect.DeepEqual(data, want) {
+			t.Errorf("loaded %v, want %v", data, want)
+		}
+	})
+
+	// What it read counts as written, so removing a key after a get deletes its record
+	loaded.Update(func(data *map[string]int) error {
+		delete(*data, "a")
+		return nil
+	})
+	if err := loaded.Put(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := db.Lookup("db test", "a"); ok {
+		t.Error("a is still stored after removing it")
+	}
+	if value, ok, _ := db.Lookup("db test", "b"); !ok || string(value) != "2" {
+		t.Errorf("b is %s, %v", value, ok)
+	}
+}
+
+func TestDBEmptyKey(t *testing.T) {
+	db := openDB(t)
+	s := openTable(t, db, map[string]int{"": 1})
+	if err := s.Put(); err == nil {
+		t.Error("put a record with an empty key")
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Every file starts with a header line holding a checksum of the JSON after it, so a half written or damaged file
+// gets caught on load instead of handing back whatever it happens to parse as
+const header = "#melvin sha256:"
+
+const (
+	backupTime   = "20060102T150405Z"
+	backupSuffix = ".bak"
+)
+
+func seal(payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	var sealed bytes.Buffer
+	sealed.Grow(len(header) + hex.EncodedLen(len(sum)) + 1 + len(payload))
+	sealed.WriteString(header)
+	sealed.WriteString(hex.EncodeToString(sum[:]))
+	sealed.WriteByte('\n')
+	sealed.Write(payload)
+	return sealed.Bytes()
+}
+
+// unseal checks contents against its header and returns the JSON. Files from before there was a header are just
+// JSON, so all there is to check on those is that they parse
+func unseal(contents []byte) ([]byte, error) {
+	if !bytes.HasPrefix(contents, []byte(header)) {
+		if len(bytes.TrimSpace(contents)) == 0 {
+			return nil, errors.New("the file is empty")
+		}
+		return contents, nil
+	}
+	line, payload, found := bytes.Cut(contents[len(header):], []byte("\n"))
+	if !found {
+		return nil, errors.New("the header isn't finished")
+	}
+	sum := sha256.Sum256(payload)
+	if want := string(line); want != hex.EncodeToString(sum[:]) {
+		return nil, fmt.Errorf("the checksum doesn't match, it's %x but should be %s", sum, want)
+	}
+	return payload, nil
+}
+
+// writeAtomic replaces filename with contents so a crash part way through leaves either the old file or the new
+// one, never a mix. It's written to a temp file next to it, synced, and renamed over the top
+func writeAtomic(filename string, contents []byte) (err error) {
+	dir := filepath.Dir(filename)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create a temp file for %s: %w", filename, err)
+	}
+	defer func() {
+		if err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+		}
+	}()
+
+	if _, err = temp.Write(contents); err != nil {
+		return fmt.Errorf("could not write %s: %w", temp.Name(), err)
+	}
+	if err = temp.Sync(); err != nil {
+		return fmt.Errorf("could not sync %s: %w", temp.Name(), err)
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes it 0600, keep what the file had before so nothing else that reads it gets locked out
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(filename); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	if err = os.Rename(temp.Name(), filename); err != nil {
+		return fmt.Errorf("could not replace %s: %w", filename, err)
+	}
+
+	// The rename only survives a crash once the directory is synced too. Not every filesystem can, which is fine
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// globEscape stops anything in a path being read as a pattern by filepath.Glob
+func globEscape(path string) string {
+	var escaped strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnseal(t *testing.T) {
+	sealed := seal([]byte(`{"a":1}`))
+	tests := []struct {
+		name     string
+		contents []byte
+		want     string
+		err      string
+	}{
+		{name: "intact", contents: sealed, want: `{"a":1}`},
+		{name: "changed after sealing", contents: bytes.Replace(sealed, []byte(`"a":1`), []byte(`"a":2`), 1), err: "checksum doesn't match"},
+		{name: "cut short", contents: sealed[:len(sealed)-2], err: "checksum doesn't match"},
+		{name: "header cut short", contents: sealed[:len(header)+10], err: "header isn't finished"},
+		{name: "empty", contents: []byte("  \n"), err: "empty"},
+		{name: "from before headers", contents: []byte(`{"a":1}`), want: `{"a":1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := unseal(test.contents)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error about %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || string(payload) != test.want {
+				t.Fatalf("got %q, %v want %q", payload, err, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data")
+
+	err := writeAtomic(filename, []byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filename, "one", 0644)
+
+	// Replacing it keeps whatever mode it had
+	err = os.Chmod(filename, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeAtomic(filename, []byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filename, "two", 0640)
+
+	err = writeAtomic(filepath.Join(dir, "missing", "data"), []byte("three"))
+	if err == nil {
+		t.Error("writing into a directory that isn't there worked")
+	}
+
+	// No temp files left behind either way
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("left %v in the directory, want only data", names)
+	}
+}
+
+func assertFile(t *testing.T, filename string, contents string, mode os.FileMode) {
+	t.Helper()
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != contents {
+		t.Errorf("%s holds %q, want %q", filename, got, contents)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s is %v, want %v", filename, info.Mode().Perm(), mode)
+	}
+}
+
+func TestGlobEscape(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "odd[name]*")
+	other := filepath.Join(dir, "oddn")
+	for _, name := range []string{filename + ".x.bak", other + ".x.bak"} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := filepath.Glob(globEscape(filename) + ".*" + backupSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != filename+".x.bak" {
+		t.Errorf("got %v, want only the backup of %s", matches, filename)
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Migration takes data from one version of a schema to the next. It works on the JSON rather than Go types, since the
+// types it was written against won't stay the same. Summary says what it changed, for logs and dry runs
+type Migration struct {
+	Description string
+	Run         func(data json.RawMessage) (migrated json.RawMessage, summary string, err error)
+}
+
+// Schema is every migration a kind of data has had, in order. Migrations[0] takes a file from before there were
+// versions to version 1, so the current version is how many there are. Only ever add to the end
+type Schema struct {
+	Name       string
+	Migrations []Migration
+	// Layout is how a DB splits the data into records, nil makes each top level key a record
+	Layout *Layout
+}
+
+func (s Schema) Version() int {
+	return len(s.Migrations)
+}
+
+// Step is a migration that ran on a file, or would in a dry run
+type Step struct {
+	From        int
+	Description string
+	Summary     string
+}
+
+var (
+	schemasLock sync.Mutex
+	schemas     = map[string]Schema{}
+)
+
+// Register sets the migrations for data stored under schema.Name. Anything without a schema stays at version 0
+func Register(schema Schema) {
+	schemasLock.Lock()
+	defer schemasLock.Unlock()
+	schemas[schema.Name] = schema
+}
+
+func schemaFor(name string) Schema {
+	schemasLock.Lock()
+	defer schemasLock.Unlock()
+	schema, ok := schemas[name]
+	if !ok {
+		return Schema{Name: name}
+	}
+	return schema
+}
+
+// envelope is what's written after the header, so a file always says which version its data is
+type envelope struct {
+	Schema  string          `json:"schema"`
+	Version *int            `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func wrap(name string, data json.RawMessage) ([]byte, error) {
+	version := schemaFor(name).Version()
+	return json.Marshal(envelope{Schema: name, Version: &version, Data: data})
+}
+
+// migrate runs every migration payload is missing, it's version 0 if it isn't in an envelope at all
+func migrate(name string, payload []byte) (json.RawMessage, []Step, error) {
+	schema := schemaFor(name)
+	var wrapped envelope
+	version, data := 0, json.RawMessage(payload)
+	if bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) && json.Unmarshal(payload, &wrapped) == nil && wrapped.Version != nil && wrapped.Data != nil {
+		if wrapped.Schema != name {
+			return nil, nil, fmt.Errorf("it holds %s, not %s", wrapped.Schema, name)
+		}
+		version, data = *wrapped.Version, wrapped.Data
+	}
+	if version > schema.Version() {
+		return nil, nil, fmt.Errorf("it's version %d but this bot only knows up to %d, it was written by a newer one", version, schema.Version())
+	}
+
+	steps := []Step{}
+	for ; version < schema.Version(); version++ {
+		migration := schema.Migrations[version]
+		migrated, summary, err := migration.Run(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s from version %d: %w", migration.Description, version, err)
+		}
+		data = migrated
+		steps = append(steps, Step{From: version, Description: migration.Description, Summary: summary})
+	}
+	return data, steps, nil
+}
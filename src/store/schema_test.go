@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counting appends its step to a list in the data, so what ran and in what order shows up in the result
+func counting(step string) Migration {
+	return Migration{Description: "add " + step, Run: func(data json.RawMessage) (json.RawMessage, string, error) {
+		var ran []string
+		if err := json.Unmarshal(data, &ran); err != nil {
+			return nil, "", err
+		}
+		migrated, err := json.Marshal(append(ran, step))
+		return migrated, "added " + step, err
+	}}
+}
+
+func init() {
+	Register(Schema{Name: "migrate test", Migrations: []Migration{counting("one"), counting("two")}})
+	Register(Schema{Name: "failing test", Migrations: []Migration{
+		counting("one"),
+		{Description: "break", Run: func(json.RawMessage) (json.RawMessage, string, error) {
+			return nil, "", errors.New("broken")
+		}},
+	}})
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		payload string
+		want    []string
+		steps   []int // The versions each step ran from
+		err     string
+	}{
+		{name: "from before versions", schema: "migrate test", payload: `[]`, want: []string{"one", "two"}, steps: []int{0, 1}},
+		{name: "part way", schema: "migrate test", payload: `{"schema":"migrate test","version":1,"data":["one"]}`, want: []string{"one", "two"}, steps: []int{1}},
+		{name: "up to date", schema: "migrate test", payload: `{"schema":"migrate test","version":2,"data":["one","two"]}`, want: []string{"one", "two"}, steps: []int{}},
+		{name: "no schema", schema: "unregistered", payload: `["as is"]`, want: []string{"as is"}, steps: []int{}},
+		{name: "newer than us", schema: "migrate test", payload: `{"schema":"migrate test","version":3,"data":[]}`, err: "written by a newer one"},
+		{name: "someone else's", schema: "migrate test", payload: `{"schema":"other","version":1,"data":[]}`, err: "it holds other, not migrate test"},
+		{name: "migration fails", schema: "failing test", payload: `[]`, err: "break from version 1: broken"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, steps, err := migrate(test.schema, []byte(test.payload))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error about %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("migrated to %v, want %v", got, test.want)
+			}
+			from := []int{}
+			for _, step := range steps {
+				from = append(from, step.From)
+			}
+			if !reflect.DeepEqual(from, test.steps) {
+				t.Errorf("ran steps from %v, want %v", from, test.steps)
+			}
+		})
+	}
+}
+
+// A file that gets migrated is backed up first, and written back at the current version on the next put
+func TestGetMigratesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(filename, []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := New([]string{})
+	if _, err := s.Open("migrate test", Backups{Keep: 1, Every: time.Hour}, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := s.Plan()
+	if err != nil || len(steps) != 2 {
+		t.Fatalf("planned %v, %v want two steps", steps, err)
+	}
+	if err := s.Get(); err != nil {
+		t.Fatal(err)
+	}
+	s.View(func(data []string) {
+		if !reflect.DeepEqual(data, []string{"one", "two"}) {
+			t.Errorf("loaded %v", data)
+		}
+	})
+	if !s.dirty.Load() {
+		t.Error("a migrated store isn't marked to be put")
+	}
+
+	backups := backupsOf(t, filename)
+	if len(backups) != 1 {
+		t.Fatalf("got backups %v, want the file from before migrating", backups)
+	}
+	if contents, _ := os.ReadFile(backups[0]); string(contents) != `[]` {
+		t.Errorf("backup holds %q, want the old file", contents)
+	}
+
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+	steps, err = s.Plan()
+	if err != nil || len(steps) != 0 {
+		t.Errorf("after putting planned %v, %v want nothing", steps, err)
+	}
+}
@@ -1,102 +1,235 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"MelvinBot/src/logging"
+	"MelvinBot/src/metrics"
+)
+
+var logger = logging.For("store")
+
+var (
+	putSeconds  = metrics.NewHistogram("melvin_store_put_seconds", "How long writing a store to disk took.", metrics.DefaultBuckets, "file")
+	putFailures = metrics.NewCounter("melvin_store_put_failures_total", "Writes of a store to disk that failed.", "file")
+	backupLoads = metrics.NewCounter("melvin_store_backup_loads_total", "Times a store was loaded from a backup because its file was missing or corrupt.", "file")
+	lastSynced  = metrics.NewGauge("melvin_store_last_sync_timestamp_seconds", "When a store last matched what's on disk, as a unix timestamp.", "file")
 )
 
 type Storage interface {
 	Put() error
 	Get() error
-	SyncOnTimer(time.Duration) error
+	// Plan is the migrations Get would run on what's stored now, without loading it or writing anything
+	Plan() ([]Step, error)
+	// SyncOnTimer blocks, putting on every tick where something's changed until ctx is done
+	SyncOnTimer(ctx context.Context, interval time.Duration) error
+	// LastSync is when what's stored last matched what's in memory, zero if it never has
+	LastSync() time.Time
 }
 
-type localStorage struct {
-	filename   string
-	input      any
-	keepBackup bool
+// Backups is how many old copies of a file to keep. A new one is only made once the newest is Every old, so they
+// reach back further than the last few syncs
+type Backups struct {
+	Keep  int // Zero keeps none
+	Every time.Duration
 }
 
-func NewLocalStorage(input any, backup bool, filename ...string) (*localStorage, error) {
-	if len(filename) > 1 {
-		return nil, errors.New("cannot specify more than one filepath for local stoage")
-	}
+// file is where a Store is kept, it knows nothing about what's in it
+type file struct {
+	name     string // Which Schema the data is
+	filename string
+	backups  Backups
+	lastSync atomic.Pointer[time.Time]
+	// Clock is what backups are timestamped with
+	Clock func() time.Time
+}
 
-	if len(filename) == 0 {
-		filename = []string{"./stats"}
+func (s *file) put(payload []byte) error {
+	start := time.Now()
+	err := s.write(payload)
+	putSeconds.Since(start, filepath.Base(s.filename))
+	if err != nil {
+		putFailures.Inc(filepath.Base(s.filename))
+		return err
 	}
-
-	return &localStorage{
-		filename:   filename[0],
-		input:      input,
-		keepBackup: backup,
-	}, nil
+	s.synced()
+	return nil
 }
 
-func (s *localStorage) Put() error {
-	statsAsJson, err := json.Marshal(s.input)
+func (s *file) write(payload []byte) error {
+	payload, err := wrap(s.name, payload)
 	if err != nil {
 		return err
 	}
+	contents := seal(payload)
 
-	statsFile, err := os.Create(s.filename)
+	err = writeAtomic(s.filename, contents)
 	if err != nil {
-		return errors.New("could not create stats file")
+		return err
 	}
-	_, err = statsFile.Write(statsAsJson)
+	// Backups are only ever copies of something we've already written safely
+	return s.backup(contents, false)
+}
+
+// get loads the file, or the newest backup that's intact if the file is missing or corrupt. It's only an
+// os.ErrNotExist error when there's no file and no backups at all. Data from an older version is migrated before
+// it's handed to unmarshal
+func (s *file) get(unmarshal func(data json.RawMessage) error) (bool, error) {
+	loaded, err := s.read(unmarshal)
 	if err != nil {
-		return errors.New("could not write stats to file")
+		return false, err
+	}
+	for _, step := range loaded.steps {
+		logger.Info("migrated", "file", s.filename, "from", step.From, "to", step.From+1, "migration", step.Description, "changes", step.Summary)
 	}
-	err = statsFile.Close()
+	if len(loaded.steps) > 0 {
+		// The next put writes over the old version, keep a copy in case a migration got it wrong
+		err = s.backup(loaded.contents, true)
+		if err != nil {
+			logger.Error("could not back up before migrating", "file", s.filename, "err", err)
+		}
+	}
+	s.synced()
+	return len(loaded.steps) > 0, nil
+}
+
+// plan is the migrations get would run on what's on disk now, without loading it or writing anything
+func (s *file) plan() ([]Step, error) {
+	loaded, err := s.read(nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return loaded.steps, nil
+}
 
-	if s.keepBackup {
-		statsFileBackup, err := os.Create(s.filename + "_backup")
-		if err != nil {
-			return errors.New("could not create stats backup file")
+type loaded struct {
+	contents []byte
+	steps    []Step
+}
+
+// read tries the file then its backups newest first, migrating whichever is intact and unmarshaling it unless
+// unmarshal is nil
+func (s *file) read(unmarshal func(data json.RawMessage) error) (*loaded, error) {
+	loaded, err := s.load(s.filename, unmarshal)
+	if err == nil {
+		return loaded, nil
+	}
+
+	backups, globErr := s.backupFiles()
+	if globErr != nil {
+		return nil, globErr
+	}
+	if len(backups) == 0 {
+		return nil, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		loaded, backupErr := s.load(backups[i], unmarshal)
+		if backupErr != nil {
+			logger.Warn("skipping a bad backup", "file", backups[i], "err", backupErr)
+			continue
 		}
-		_, err = statsFileBackup.Write(statsAsJson)
-		if err != nil {
-			return errors.New("could not write stats backup to file")
+		if unmarshal != nil {
+			logger.Warn("loaded a backup instead", "file", s.filename, "backup", backups[i], "err", err)
+			backupLoads.Inc(filepath.Base(s.filename))
 		}
-		err = statsFileBackup.Close()
+		return loaded, nil
+	}
+	// Not wrapped, a missing file with backups isn't a first run. Starting fresh would put over them one at a time
+	return nil, fmt.Errorf("%v, and none of its %d backup(s) could be loaded either", err, len(backups))
+}
+
+func (s *file) load(filename string, unmarshal func(data json.RawMessage) error) (*loaded, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", filename, err)
+	}
+	payload, err := unseal(contents)
+	if err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", filename, err)
+	}
+	data, steps, err := migrate(s.name, payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not migrate %s: %w", filename, err)
+	}
+	if unmarshal != nil {
+		err = unmarshal(data)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error unmarshaling %s, %v", filename, err)
 		}
 	}
-	return nil
+	return &loaded{contents: contents, steps: steps}, nil
 }
 
-func (s *localStorage) Get() error {
-	bytes, err := os.ReadFile(s.filename)
+// backup writes contents as a new backup if the newest one is old enough or it's forced, then deletes any past the
+// ones to keep
+func (s *file) backup(contents []byte, force bool) error {
+	if s.backups.Keep <= 0 {
+		return nil
+	}
+	backups, err := s.backupFiles()
 	if err != nil {
-		return errors.New("could not read local stats file")
+		return err
+	}
+	now := s.Clock().UTC()
+	if len(backups) > 0 {
+		newest, err := time.Parse(backupTime, strings.TrimSuffix(strings.TrimPrefix(backups[len(backups)-1], s.filename+"."), backupSuffix))
+		if err == nil && now.Sub(newest) < s.backups.Every && !force {
+			return nil
+		}
 	}
-	newStats := s.input
-	err = json.Unmarshal(bytes, &newStats)
+
+	name := fmt.Sprintf("%s.%s%s", s.filename, now.Format(backupTime), backupSuffix)
+	err = writeAtomic(name, contents)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling, %v", err)
+		return fmt.Errorf("could not back up %s: %w", s.filename, err)
+	}
+	backups = append(backups, name)
+	for len(backups) > s.backups.Keep {
+		err = os.Remove(backups[0])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not delete old backup: %w", err)
+		}
+		backups = backups[1:]
 	}
 	return nil
 }
 
-func (s *localStorage) SyncOnTimer(timer time.Duration) error {
-	newTimer := time.NewTicker(timer)
+// backupFiles is every backup of the file oldest first, which is also the order their names sort in. The one
+// backup older versions kept is counted as the oldest
+func (s *file) backupFiles() ([]string, error) {
+	backups, err := filepath.Glob(globEscape(s.filename) + ".*" + backupSuffix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	if _, err := os.Stat(s.filename + "_backup"); err == nil {
+		backups = append([]string{s.filename + "_backup"}, backups...)
+	}
+	return backups, nil
+}
+
+func (s *file) synced() {
+	now := time.Now()
+	s.lastSync.Store(&now)
+	lastSynced.Set(float64(now.Unix()), filepath.Base(s.filename))
+}
 
-	go func() {
-		for {
-			<-newTimer.C
-			err := s.Put()
-			if err != nil {
-				log.Print("error putting stats")
-			}
-		}
-	}()
-	return nil
+func (s *file) where() string {
+	return s.filename
+}
+
+func (s *file) last() time.Time {
+	if last := s.lastSync.Load(); last != nil {
+		return *last
+	}
+	return time.Time{}
 }
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openFile is a map store kept in a temp file, with its backup clock stopped at now
+func openFile(t *testing.T, filename string, backups Backups, now *time.Time) *Store[map[string]int] {
+	t.Helper()
+	s := New(map[string]int{})
+	_, err := s.Open("test", backups, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backend.(*file).Clock = func() time.Time { return *now }
+	return s
+}
+
+func set(t *testing.T, s *Store[map[string]int], key string, value int) {
+	t.Helper()
+	s.Update(func(data *map[string]int) error {
+		(*data)[key] = value
+		return nil
+	})
+	if err := s.Put(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func backupsOf(t *testing.T, filename string) []string {
+	t.Helper()
+	backups, err := (&file{filename: filename}).backupFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups
+}
+
+func TestBackups(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	s := openFile(t, filename, Backups{Keep: 2, Every: time.Hour}, &now)
+
+	steps := []struct {
+		after time.Duration
+		want  []string // Backup times
+	}{
+		{after: 0, want: []string{"20240102T030405Z"}},
+		{after: 30 * time.Minute, want: []string{"20240102T030405Z"}},
+		{after: time.Hour, want: []string{"20240102T030405Z", "20240102T040405Z"}},
+		{after: 3 * time.Hour, want: []string{"20240102T040405Z", "20240102T060405Z"}},
+	}
+	for i, step := range steps {
+		now = start.Add(step.after)
+		set(t, s, "put", i)
+
+		want := []string{}
+		for _, at := range step.want {
+			want = append(want, filename+"."+at+backupSuffix)
+		}
+		if got := backupsOf(t, filename); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("after %s got backups %v, want %v", step.after, got, want)
+		}
+	}
+}
+
+func TestNoBackups(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data")
+	now := time.Now()
+	s := openFile(t, filename, Backups{}, &now)
+	set(t, s, "a", 1)
+	if got := backupsOf(t, filename); len(got) != 0 {
+		t.Errorf("keeping none made %v", got)
+	}
+}
+
+func TestGetFallsBack(t *testing.T) {
+	tests := []struct {
+		name string
+		// breakIt does something to the file and backups after a is put at 1 then 2, an hour apart
+		breakIt func(t *testing.T, filename string, backups []string)
+		want    int   // What a loads as
+		err     error // Matched with errors.Is, nil for any error when corrupt is true
+		corrupt bool  // Get should fail, but not like it's a first run
+	}{
+		{name: "intact", breakIt: func(*testing.T, string, []string) {}, want: 2},
+		{name: "file corrupt", breakIt: func(t *testing.T, filename string, _ []string) { damage(t, filename) }, want: 2},
+		{name: "file missing", breakIt: func(t *testing.T, filename string, _ []string) { remove(t, filename) }, want: 2},
+		{
+			name: "file decodes partway",
+			breakIt: func(t *testing.T, filename string, _ []string) {
+				contents, err := os.ReadFile(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				payload, err := unseal(contents)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Checksum's fine, but b's decoded before a fails to
+				payload = bytes.Replace(payload, []byte(`"a":2`), []byte(`"b":7,"a":"x"`), 1)
+				if err := os.WriteFile(filename, seal(payload), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: 2,
+		},
+		{
+			name: "newest backup corrupt too",
+			breakIt: func(t *testing.T, filename string, backups []string) {
+				damage(t, filename)
+				damage(t, backups[1])
+			},
+			want: 1,
+		},
+		{
+			name: "every backup corrupt",
+			breakIt: func(t *testing.T, filename string, backups []string) {
+				remove(t, filename)
+				for _, backup := range backups {
+					damage(t, backup)
+				}
+			},
+			corrupt: true,
+		},
+		{
+			name: "nothing left",
+			breakIt: func(t *testing.T, filename string, backups []string) {
+				remove(t, filename)
+				for _, backup := range backups {
+					remove(t, backup)
+				}
+			},
+			err: os.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "data")
+			now := time.Now()
+			s := openFile(t, filename, Backups{Keep: 5, Every: time.Hour}, &now)
+			set(t, s, "a", 1)
+			now = now.Add(time.Hour)
+			set(t, s, "a", 2)
+			backups := backupsOf(t, filename)
+			if len(backups) != 2 {
+				t.Fatalf("got backups %v, want two", backups)
+			}
+			test.breakIt(t, filename, backups)
+
+			loaded := openFile(t, filename, Backups{Keep: 5, Every: time.Hour}, &now)
+			err := loaded.Get()
+			switch {
+			case test.corrupt:
+				if err == nil || errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("got %v, want an error that isn't os.ErrNotExist so nothing puts over the backups", err)
+				}
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				loaded.View(func(data map[string]int) {
+					if data["a"] != test.want || len(data) != 1 {
+						t.Errorf("loaded %v, want just a = %d", data, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+// damage changes a byte in the middle of the file, which only the checksum catches
+func damage(t *testing.T, filename string) {
+	t.Helper()
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.LastIndex(string(contents), `"a":`) + len(`"a":`)
+	contents[i]++
+	if err := os.WriteFile(filename, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, filename string) {
+	t.Helper()
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutNotOpen(t *testing.T) {
+	s := New(map[string]int{})
+	if err := s.Put(); err == nil {
+		t.Error("put worked on a store that isn't open")
+	}
+	if err := s.Get(); err == nil {
+		t.Error("get worked on a store that isn't open")
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Persistent is a Store of any type, so whoever keeps them in files doesn't need to know what's in them
+type Persistent interface {
+	// Open keeps the store in filename, name is the Schema its migrations are registered under
+	Open(name string, backups Backups, filename string) (Storage, error)
+	// OpenIn keeps the store in db instead, under name
+	OpenIn(db *DB, name string) (Storage, error)
+}
+
+// backend is somewhere a Store is kept
+type backend interface {
+	put(payload []byte) error
+	// get hands unmarshal the data, migrated to the current version. It's true if any migrations ran
+	get(unmarshal func(data json.RawMessage) error) (bool, error)
+	plan() ([]Step, error)
+	synced()
+	last() time.Time
+	where() string
+}
+
+// Store owns a T. Everything goes through View or Update so nothing can read it while it's being changed, including
+// writing it to disk. A Store that was never opened is only in memory
+type Store[T any] struct {
+	lock  sync.RWMutex
+	data  T
+	dirty atomic.Bool
+	// putting is held for a whole Put, so an older copy can never be written over a newer one
+	putting sync.Mutex
+	backend backend
+}
+
+func New[T any](data T) *Store[T] {
+	return &Store[T]{data: data}
+}
+
+func (s *Store[T]) Open(name string, backups Backups, filename string) (Storage, error) {
+	if filename == "" {
+		return nil, errors.New("no file to keep " + name + " in")
+	}
+	s.backend = &file{name: name, filename: filename, backups: backups, Clock: time.Now}
+	return s, nil
+}
+
+func (s *Store[T]) OpenIn(db *DB, name string) (Storage, error) {
+	if db == nil {
+		return nil, errors.New("no database to keep " + name + " in")
+	}
+	s.backend = &table{db: db, name: name}
+	return s, nil
+}
+
+// View reads the data. Anything it points to is still the Store's, so f mustn't change it or keep it after returning
+func (s *Store[T]) View(f func(data T)) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	f(s.data)
+}
+
+// Update changes the data, marking it to be written on the next sync unless f returns an error. f should leave the
+// data how it was if it's going to return one
+func (s *Store[T]) Update(f func(data *T) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := f(&s.data)
+	if err == nil {
+		s.dirty.Store(true)
+	}
+	return err
+}
+
+func (s *Store[T]) Put() error {
+	if s.backend == nil {
+		return errors.New("store isn't open")
+	}
+	s.putting.Lock()
+	defer s.putting.Unlock()
+	// Cleared first so anything changed while it's being written is written next time too
+	s.dirty.Store(false)
+	s.lock.RLock()
+	payload, err := json.Marshal(s.data)
+	s.lock.RUnlock()
+	if err == nil {
+		err = s.backend.put(payload)
+	}
+	if err != nil {
+		s.dirty.Store(true)
+	}
+	return err
+}
+
+func (s *Store[T]) Get() error {
+	if s.backend == nil {
+		return errors.New("store isn't open")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	migrated, err := s.backend.get(func(data json.RawMessage) error {
+		// Decoded into a fresh value so a file that only half decodes doesn't leave its fields mixed into the backup's
+		var fresh T
+		if err := json.Unmarshal(data, &fresh); err != nil {
+			return err
+		}
+		s.data = fresh
+		return nil
+	})
+	if migrated {
+		// What's stored is still the old version until it's written again
+		s.dirty.Store(true)
+	}
+	return err
+}
+
+// Plan is the migrations Get would run on what's stored now, without loading it or writing anything
+func (s *Store[T]) Plan() ([]Step, error) {
+	if s.backend == nil {
+		return nil, errors.New("store isn't open")
+	}
+	return s.backend.plan()
+}
+
+func (s *Store[T]) LastSync() time.Time {
+	if s.backend == nil {
+		return time.Time{}
+	}
+	return s.backend.last()
+}
+
+// SyncOnTimer only writes when something's changed, otherwise what's stored is already up to date
+func (s *Store[T]) SyncOnTimer(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !s.dirty.Load() {
+				s.backend.synced()
+				continue
+			}
+			err := s.Put()
+			if err != nil {
+				logger.ErrorContext(ctx, "error syncing", "store", s.backend.where(), "err", err)
+			}
+		}
+	}
+}
@@ -1,5 +0,0 @@
-package util
-
-const Wolfcord_GuildID = "1084972888374919211"
-
-const Melvin_GuildID = "1180043668300771328"
@@ -2,24 +2,46 @@ package util
 
 import (
 	"fmt"
-	"log"
+	"strings"
 	"time"
+	"unicode"
+
+	"MelvinBot/src/discord/session"
+	"MelvinBot/src/logging"
+	"MelvinBot/src/selfdestruct"
 
 	disc "github.com/bwmarrin/discordgo"
 )
 
-// Since this has a sleep, we should run it in a goroutine
-func SendSelfDestructingMessage(s *disc.Session, channelID string, content string, duration time.Duration) {
-	go func() {
-		content += fmt.Sprintf(" [This message will self delete in %s]", duration)
-		msg, err := s.ChannelMessageSend(channelID, content)
-		if err != nil {
-			log.Printf("failed to send message: %v", err)
-		}
-		time.Sleep(duration)
-		err = s.ChannelMessageDelete(channelID, msg.ID)
+var logger = logging.For("util")
+
+// SendSelfDestructingMessage sends content and queues it in deletions to go after duration, even if we restart first.
+// Every piece goes if it had to be split up
+// Slash commands get an ephemeral message instead, only the invoker sees it so there's nothing to clean up
+func SendSelfDestructingMessage(s session.Session, deletions selfdestruct.Queue, channelID string, content string, duration time.Duration) {
+	if ephemeral, ok := s.(session.Ephemeral); ok {
+		_, err := ephemeral.ChannelMessageSendEphemeral(channelID, content)
 		if err != nil {
-			log.Printf("failed to delete message: %v", err)
+			logger.Error("failed to send message", "channel", channelID, "err", err)
 		}
-	}()
+		return
+	}
+
+	content += fmt.Sprintf(" [This message will self delete in %s]", duration)
+	sent, err := session.SendSplit(s, channelID, &disc.MessageSend{Content: content})
+	if err != nil {
+		logger.Error("failed to send message", "channel", channelID, "err", err)
+	}
+	for _, msg := range sent {
+		deletions.After(channelID, msg.ID, duration)
+	}
+}
+
+// CutWord splits off the first whitespace separated word of s
+func CutWord(s string) (string, string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i:]
 }